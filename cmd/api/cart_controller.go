@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"project/internal/data"
 	"project/utils"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -199,3 +201,153 @@ func (app *application) DeleteCartHandler(w http.ResponseWriter, r *http.Request
 		"message": "تم حذف السلة بنجاح",
 	})
 }
+
+// userCart loads the cart in the path and checks that it belongs to the user.
+func (app *application) userCart(w http.ResponseWriter, r *http.Request) (*data.Cart, bool) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return nil, false
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف السلة غير صالح"))
+		return nil, false
+	}
+
+	cart, err := app.Model.CartDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if cart.UserID != userID {
+		app.forbiddenResponse(w, r)
+		return nil, false
+	}
+
+	return cart, true
+}
+
+// ApplyCartCouponHandler attaches a coupon to the cart after checking it
+// against the cart as it is now. It is checked again when the order is placed.
+func (app *application) ApplyCartCouponHandler(w http.ResponseWriter, r *http.Request) {
+	cart, ok := app.userCart(w, r)
+	if !ok {
+		return
+	}
+	if cart.StoreID == nil {
+		app.badRequestResponse(w, r, errors.New("السلة فارغة"))
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		app.badRequestResponse(w, r, errors.New("يجب إدخال رمز الكوبون"))
+		return
+	}
+
+	coupon, err := app.Model.CouponDB.GetByCode(code, *cart.StoreID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	store, err := app.Model.StoreDB.GetStore(*cart.StoreID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	quote, err := app.Model.CartDB.Quote(cart, nil, now)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.Model.CouponDB.Check(coupon, data.CouponBasket{
+		UserID:      cart.UserID,
+		StoreID:     store.ID,
+		StoreTypeID: store.StoreTypeID,
+		Subtotal:    quote.ItemsTotal(),
+	}, now)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	err = app.Model.CartDB.SetCoupon(cart, &coupon.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":  "تم تطبيق الكوبون بنجاح",
+		"cart":     cart,
+		"coupon":   coupon,
+		"discount": coupon.Discount(quote.ItemsTotal()),
+	})
+}
+
+func (app *application) RemoveCartCouponHandler(w http.ResponseWriter, r *http.Request) {
+	cart, ok := app.userCart(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.CartDB.SetCoupon(cart, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم إزالة الكوبون من السلة",
+		"cart":    cart,
+	})
+}
+
+// QuoteCartHandler prices the cart as an order placed now would be priced,
+// without reserving stock or redeeming the coupon. Delivery is priced to the
+// delivery_latitude/delivery_longitude query parameters, or to the user's
+// saved location when they are absent, like order creation does.
+func (app *application) QuoteCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart, ok := app.userCart(w, r)
+	if !ok {
+		return
+	}
+
+	var point *data.DeliveryPoint
+	query := r.URL.Query()
+	if query.Get("delivery_latitude") != "" || query.Get("delivery_longitude") != "" {
+		latitude, errLat := strconv.ParseFloat(query.Get("delivery_latitude"), 64)
+		longitude, errLng := strconv.ParseFloat(query.Get("delivery_longitude"), 64)
+		if errLat != nil || errLng != nil {
+			app.badRequestResponse(w, r, errors.New("إحداثيات التوصيل غير صالحة"))
+			return
+		}
+		point = &data.DeliveryPoint{Latitude: latitude, Longitude: longitude}
+	} else {
+		user, err := app.Model.UserDB.GetUser(cart.UserID)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+		if user.Latitude != nil && user.Longitude != nil {
+			point = &data.DeliveryPoint{Latitude: *user.Latitude, Longitude: *user.Longitude}
+		}
+	}
+
+	quote, err := app.Model.CartDB.Quote(cart, point, time.Now())
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"cart":  cart,
+		"quote": quote,
+	})
+}
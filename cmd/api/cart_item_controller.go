@@ -8,10 +8,32 @@ import (
 	"project/utils"
 	"project/utils/validator"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// parseOptionIDs reads the comma-separated option IDs picked for an item.
+func parseOptionIDs(value string) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, errors.New("معرف الخيار غير صالح")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// AddCartItemHandler adds a product to the user's cart with the options in
+// option_ids, which must follow the product's option groups. The same product
+// can be in the cart more than once with different options.
 func (app *application) AddCartItemHandler(w http.ResponseWriter, r *http.Request) {
 	userIDStr, ok := r.Context().Value(UserIDKey).(string)
 	if !ok {
@@ -37,6 +59,12 @@ func (app *application) AddCartItemHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	optionIDs, err := parseOptionIDs(r.FormValue("option_ids"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	product, err := app.Model.ProductDB.Get(productID)
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
@@ -51,11 +79,21 @@ func (app *application) AddCartItemHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	var cartID uuid.UUID
-	cartIDStr := r.FormValue("cart_id")
+	groups, err := app.Model.ProductOptionDB.ListByProduct(productID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	data.ValidateOptionChoice(v, groups, optionIDs, quantity)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	var cart *data.Cart
-	if cartIDStr != "" {
-		cartID, err = uuid.Parse(cartIDStr)
+	if cartIDStr := r.FormValue("cart_id"); cartIDStr != "" {
+		cartID, err := uuid.Parse(cartIDStr)
 		if err != nil {
 			app.badRequestResponse(w, r, errors.New("معرف السلة غير صالح"))
 			return
@@ -69,59 +107,63 @@ func (app *application) AddCartItemHandler(w http.ResponseWriter, r *http.Reques
 			app.badRequestResponse(w, r, errors.New("السلة لا تخص المستخدم"))
 			return
 		}
-		if cart.StoreID != nil && *cart.StoreID != product.StoreID {
-			app.badRequestResponse(w, r, errors.New("لا يمكن إضافة منتج من متجر مختلف إلى السلة"))
-			return
-		}
-		if cart.StoreID == nil {
-			cart.StoreID = &product.StoreID
-			err = app.Model.CartDB.Update(cart)
-			if err != nil {
-				app.serverErrorResponse(w, r, fmt.Errorf("فشل في تحديث معرف المتجر للسلة: %v", err))
-				return
-			}
-		}
 	} else {
 		cart, err = app.Model.CartDB.GetByUser(userID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		if cart == nil {
-			storeID := product.StoreID
-			cart = &data.Cart{
-				UserID:  userID,
-				StoreID: &storeID,
-			}
-			err = app.Model.CartDB.Insert(cart)
-			if err != nil {
-				app.serverErrorResponse(w, r, fmt.Errorf("فشل في إنشاء السلة: %v", err))
-				return
-			}
-		} else {
-			if cart.StoreID != nil && *cart.StoreID != product.StoreID {
-				app.badRequestResponse(w, r, errors.New("لا يمكن إضافة منتج من متجر مختلف إلى السلة"))
-				return
-			}
-			if cart.StoreID == nil {
-				cart.StoreID = &product.StoreID
-				err = app.Model.CartDB.Update(cart)
-				if err != nil {
-					app.serverErrorResponse(w, r, fmt.Errorf("فشل في تحديث معرف المتجر للسلة: %v", err))
-					return
-				}
-			}
+	}
+	if cart != nil && cart.StoreID != nil && *cart.StoreID != product.StoreID {
+		app.badRequestResponse(w, r, errors.New("لا يمكن إضافة منتج من متجر مختلف إلى السلة"))
+		return
+	}
+
+	// A cart holding a delivery slot only needs the store open when the slot
+	// starts, so it can be filled while the store is closed.
+	openAt := time.Now()
+	if cart != nil {
+		openAt, err = app.Model.DeliverySlotDB.OpenCheckTime(cart.ID, openAt)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
 		}
-		cartID = cart.ID
+	}
+	err = app.Model.StoreHoursDB.CheckOpen(product.StoreID, openAt)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
 	}
 
+	switch {
+	case cart == nil:
+		storeID := product.StoreID
+		cart = &data.Cart{
+			UserID:  userID,
+			StoreID: &storeID,
+		}
+		err = app.Model.CartDB.Insert(cart)
+		if err != nil {
+			app.serverErrorResponse(w, r, fmt.Errorf("فشل في إنشاء السلة: %v", err))
+			return
+		}
+	case cart.StoreID == nil:
+		cart.StoreID = &product.StoreID
+		err = app.Model.CartDB.Update(cart)
+		if err != nil {
+			app.serverErrorResponse(w, r, fmt.Errorf("فشل في تحديث معرف المتجر للسلة: %v", err))
+			return
+		}
+	}
+	cartID := cart.ID
+
 	existingItems, err := app.Model.CartItemDB.ListByCart(cartID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	for _, item := range existingItems {
-		if item.ProductID == productID {
+		if item.ProductID == productID && data.SameOptions(item.OptionIDs, optionIDs) {
 			app.badRequestResponse(w, r, errors.New("المنتج موجود بالفعل في السلة"))
 			return
 		}
@@ -131,9 +173,9 @@ func (app *application) AddCartItemHandler(w http.ResponseWriter, r *http.Reques
 		CartID:    cartID,
 		ProductID: productID,
 		Quantity:  quantity,
+		OptionIDs: optionIDs,
 	}
 
-	v := validator.New()
 	data.ValidateCartItem(v, item)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -194,6 +236,17 @@ func (app *application) UpdateCartItemHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	groups, err := app.Model.ProductOptionDB.ListByProduct(item.ProductID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	data.ValidateOptionChoice(v, groups, item.OptionIDs, item.Quantity)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.Model.CartItemDB.Update(item)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
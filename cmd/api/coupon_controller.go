@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// readCouponForm copies the coupon terms present in the request onto coupon.
+func readCouponForm(r *http.Request, coupon *data.Coupon) error {
+	if description := r.FormValue("description"); description != "" {
+		coupon.Description = &description
+	}
+	if discountType := r.FormValue("discount_type"); discountType != "" {
+		coupon.DiscountType = discountType
+		// Switching type drops the value of the other one.
+		coupon.PercentOff = nil
+		coupon.AmountOff = nil
+	}
+	if percentOff := r.FormValue("percent_off"); percentOff != "" {
+		val, err := strconv.Atoi(percentOff)
+		if err != nil {
+			return errors.New("نسبة الخصم يجب أن تكون عددًا صحيحًا")
+		}
+		coupon.PercentOff = &val
+	}
+	if amountOff := r.FormValue("amount_off"); amountOff != "" {
+		val, err := data.ParseMoney(amountOff)
+		if err != nil {
+			return errors.New("مبلغ الخصم يجب أن يكون رقمًا صالحًا (مثال: 5 أو 7.50)")
+		}
+		coupon.AmountOff = &val
+	}
+	if minBasket := r.FormValue("min_basket"); minBasket != "" {
+		val, err := data.ParseMoney(minBasket)
+		if err != nil {
+			return errors.New("الحد الأدنى يجب أن يكون رقمًا صالحًا (مثال: 50 أو 75.50)")
+		}
+		coupon.MinBasket = val
+	}
+	if usageLimit := r.FormValue("usage_limit"); usageLimit != "" {
+		val, err := strconv.Atoi(usageLimit)
+		if err != nil {
+			return errors.New("حد الاستخدام يجب أن يكون عددًا صحيحًا")
+		}
+		coupon.UsageLimit = &val
+	}
+	if perUserLimit := r.FormValue("per_user_limit"); perUserLimit != "" {
+		val, err := strconv.Atoi(perUserLimit)
+		if err != nil {
+			return errors.New("حد الاستخدام لكل مستخدم يجب أن يكون عددًا صحيحًا")
+		}
+		coupon.PerUserLimit = &val
+	}
+	if startsAt := r.FormValue("starts_at"); startsAt != "" {
+		val, err := time.Parse(time.RFC3339, startsAt)
+		if err != nil {
+			return errors.New("تاريخ البدء يجب أن يكون بصيغة RFC3339")
+		}
+		coupon.StartsAt = val
+	}
+	if endsAt := r.FormValue("ends_at"); endsAt != "" {
+		val, err := time.Parse(time.RFC3339, endsAt)
+		if err != nil {
+			return errors.New("تاريخ الانتهاء يجب أن يكون بصيغة RFC3339")
+		}
+		coupon.EndsAt = &val
+	}
+	if isActive := r.FormValue("is_active"); isActive != "" {
+		val, err := strconv.ParseBool(isActive)
+		if err != nil {
+			return errors.New("قيمة is_active غير صالحة")
+		}
+		coupon.IsActive = val
+	}
+	return nil
+}
+
+// couponForManager loads a coupon from the path and checks that the user may
+// manage it: admins manage every coupon, store owners those of their stores.
+func (app *application) couponForManager(w http.ResponseWriter, r *http.Request) (*data.Coupon, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الكوبون غير صالح"))
+		return nil, false
+	}
+
+	coupon, err := app.Model.CouponDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	if hasRole(userRoles, "admin") {
+		return coupon, true
+	}
+	if coupon.StoreID == nil {
+		app.forbiddenResponse(w, r)
+		return nil, false
+	}
+	if _, ok := app.storeForOwner(w, r, *coupon.StoreID); !ok {
+		return nil, false
+	}
+
+	return coupon, true
+}
+
+// CreateCouponHandler creates a coupon. Admins may create coupons for any
+// store, store type or the whole platform; store owners only for their own
+// store.
+func (app *application) CreateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	isAdmin := hasRole(userRoles, "admin")
+
+	coupon := &data.Coupon{
+		Code:      r.FormValue("code"),
+		MinBasket: data.NewMoney(0),
+		StartsAt:  time.Now(),
+		CreatedBy: userID,
+		IsActive:  true,
+	}
+
+	if storeIDStr := r.FormValue("store_id"); storeIDStr != "" {
+		storeID, err := uuid.Parse(storeIDStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+			return
+		}
+		if _, ok := app.storeForOwner(w, r, storeID); !ok {
+			return
+		}
+		coupon.StoreID = &storeID
+	} else if !isAdmin {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر مفقود"))
+		return
+	}
+
+	if storeTypeIDStr := r.FormValue("store_type_id"); storeTypeIDStr != "" {
+		if !isAdmin {
+			app.forbiddenResponse(w, r)
+			return
+		}
+		storeTypeID, err := strconv.Atoi(storeTypeIDStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف نوع المتجر غير صالح"))
+			return
+		}
+		coupon.StoreTypeID = &storeTypeID
+	}
+
+	err = readCouponForm(r, coupon)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	coupon.Code = data.NormalizeCouponCode(coupon.Code)
+
+	v := validator.New()
+	data.ValidateCoupon(v, coupon)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.CouponDB.Insert(coupon)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تم إنشاء الكوبون بنجاح",
+		"coupon":  coupon,
+	})
+}
+
+func (app *application) GetCouponHandler(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := app.couponForManager(w, r)
+	if !ok {
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"coupon": coupon,
+	})
+}
+
+// ListCouponsHandler lists every coupon to admins and the coupons of their
+// own stores to everyone else.
+func (app *application) ListCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+
+	var storeIDs []uuid.UUID
+	if !hasRole(userRoles, "admin") {
+		storeIDs, err = app.Model.StoreDB.ListIDsByOwner(userID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	coupons, meta, err := app.Model.CouponDB.List(r.URL.Query(), storeIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"coupons": coupons,
+		"meta":    meta,
+	})
+}
+
+// UpdateCouponHandler changes a coupon's terms. The code and the store or
+// store type it is limited to cannot be changed.
+func (app *application) UpdateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := app.couponForManager(w, r)
+	if !ok {
+		return
+	}
+
+	err := readCouponForm(r, coupon)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateCoupon(v, coupon)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.CouponDB.Update(coupon)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث الكوبون بنجاح",
+		"coupon":  coupon,
+	})
+}
+
+// DeleteCouponHandler deactivates the coupon. Coupons are never removed, so
+// their usage history is kept.
+func (app *application) DeleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	coupon, ok := app.couponForManager(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.CouponDB.Deactivate(coupon.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم إيقاف الكوبون بنجاح",
+	})
+}
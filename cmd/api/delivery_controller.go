@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+func (app *application) GetStoreDeliverySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	_, err = app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	settings, err := app.Model.StoreDeliverySettingsDB.Get(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"delivery_settings": settings,
+	})
+}
+
+// UpdateStoreDeliverySettingsHandler replaces a store's delivery settings.
+// Fields left out fall back to their defaults: no radius, no fees, no free
+// delivery threshold and no minimum order.
+func (app *application) UpdateStoreDeliverySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	settings := data.DefaultDeliverySettings(storeID)
+	if radius := r.FormValue("radius_km"); radius != "" {
+		val, err := strconv.ParseFloat(radius, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("نطاق التوصيل يجب أن يكون رقمًا صالحًا"))
+			return
+		}
+		settings.RadiusKm = &val
+	}
+
+	moneyFields := []struct {
+		name    string
+		dest    *data.Money
+		message string
+	}{
+		{"base_fee", &settings.BaseFee, "رسوم التوصيل يجب أن تكون رقمًا صالحًا (مثال: 5 أو 7.50)"},
+		{"per_km_fee", &settings.PerKmFee, "رسوم الكيلومتر يجب أن تكون رقمًا صالحًا (مثال: 1 أو 0.75)"},
+		{"min_order_value", &settings.MinOrderValue, "الحد الأدنى للطلب يجب أن يكون رقمًا صالحًا (مثال: 20 أو 25.50)"},
+	}
+	for _, field := range moneyFields {
+		if value := r.FormValue(field.name); value != "" {
+			val, err := data.ParseMoney(value)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New(field.message))
+				return
+			}
+			*field.dest = val
+		}
+	}
+	if threshold := r.FormValue("free_delivery_threshold"); threshold != "" {
+		val, err := data.ParseMoney(threshold)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("حد التوصيل المجاني يجب أن يكون رقمًا صالحًا (مثال: 100 أو 150.50)"))
+			return
+		}
+		settings.FreeDeliveryThreshold = &val
+	}
+
+	v := validator.New()
+	data.ValidateStoreDeliverySettings(v, settings)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.StoreDeliverySettingsDB.Upsert(settings)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":           "تم تحديث إعدادات التوصيل بنجاح",
+		"delivery_settings": settings,
+	})
+}
+
+// readDeliveryZoneForm copies the zone fields present in the request onto
+// zone. A fee override sent empty is cleared.
+func readDeliveryZoneForm(r *http.Request, zone *data.DeliveryZone) error {
+	if name := r.FormValue("name"); name != "" {
+		zone.Name = name
+	}
+	if geometry := r.FormValue("geometry"); geometry != "" {
+		if err := zone.SetGeometry([]byte(geometry)); err != nil {
+			return err
+		}
+	}
+
+	feeFields := []struct {
+		name    string
+		dest    **data.Money
+		message string
+	}{
+		{"base_fee", &zone.BaseFee, "رسوم التوصيل يجب أن تكون رقمًا صالحًا (مثال: 5 أو 7.50)"},
+		{"per_km_fee", &zone.PerKmFee, "رسوم الكيلومتر يجب أن تكون رقمًا صالحًا (مثال: 1 أو 0.75)"},
+	}
+	for _, field := range feeFields {
+		if _, ok := r.Form[field.name]; !ok {
+			continue
+		}
+		value := r.FormValue(field.name)
+		if value == "" {
+			*field.dest = nil
+			continue
+		}
+		val, err := data.ParseMoney(value)
+		if err != nil {
+			return errors.New(field.message)
+		}
+		*field.dest = &val
+	}
+
+	if isActive := r.FormValue("is_active"); isActive != "" {
+		val, err := strconv.ParseBool(isActive)
+		if err != nil {
+			return errors.New("قيمة is_active غير صالحة")
+		}
+		zone.IsActive = val
+	}
+	return nil
+}
+
+// CreateDeliveryZoneHandler creates a zone from a GeoJSON Polygon or
+// MultiPolygon sent in the geometry field.
+func (app *application) CreateDeliveryZoneHandler(w http.ResponseWriter, r *http.Request) {
+	zone := &data.DeliveryZone{IsActive: true}
+	err := readDeliveryZoneForm(r, zone)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateDeliveryZone(v, zone)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.DeliveryZoneDB.Insert(zone)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message":       "تم إنشاء منطقة التوصيل بنجاح",
+		"delivery_zone": zone,
+	})
+}
+
+func (app *application) GetDeliveryZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنطقة غير صالح"))
+		return
+	}
+
+	zone, err := app.Model.DeliveryZoneDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"delivery_zone": zone,
+	})
+}
+
+func (app *application) ListDeliveryZonesHandler(w http.ResponseWriter, r *http.Request) {
+	zones, meta, err := app.Model.DeliveryZoneDB.List(r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"delivery_zones": zones,
+		"meta":           meta,
+	})
+}
+
+func (app *application) UpdateDeliveryZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنطقة غير صالح"))
+		return
+	}
+
+	zone, err := app.Model.DeliveryZoneDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	err = readDeliveryZoneForm(r, zone)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateDeliveryZone(v, zone)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.DeliveryZoneDB.Update(zone)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":       "تم تحديث منطقة التوصيل بنجاح",
+		"delivery_zone": zone,
+	})
+}
+
+func (app *application) DeleteDeliveryZoneHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنطقة غير صالح"))
+		return
+	}
+
+	err = app.Model.DeliveryZoneDB.Delete(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم حذف منطقة التوصيل بنجاح",
+	})
+}
+
+func (app *application) ListStoreDeliveryZonesHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	_, err = app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	zones, err := app.Model.DeliveryZoneDB.ListByStore(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"delivery_zones": zones,
+	})
+}
+
+// SetStoreDeliveryZonesHandler replaces the zones a store serves with the
+// comma-separated zone_ids. Sending none makes the store deliver within its
+// radius again.
+func (app *application) SetStoreDeliveryZonesHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	zoneIDs := []uuid.UUID{}
+	for _, value := range strings.Split(r.FormValue("zone_ids"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		zoneID, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف المنطقة غير صالح"))
+			return
+		}
+		zoneIDs = append(zoneIDs, zoneID)
+	}
+
+	err = app.Model.DeliveryZoneDB.SetStoreZones(storeID, zoneIDs)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	zones, err := app.Model.DeliveryZoneDB.ListByStore(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":        "تم تحديث مناطق توصيل المتجر بنجاح",
+		"delivery_zones": zones,
+	})
+}
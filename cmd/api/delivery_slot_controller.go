@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// readDeliverySlotForm copies the slot fields present in the request onto slot.
+func readDeliverySlotForm(r *http.Request, slot *data.DeliverySlot) error {
+	if dayOfWeek := r.FormValue("day_of_week"); dayOfWeek != "" {
+		val, err := strconv.Atoi(dayOfWeek)
+		if err != nil {
+			return errors.New("يوم الأسبوع يجب أن يكون عددًا صحيحًا")
+		}
+		slot.DayOfWeek = val
+	}
+	if startsAt := r.FormValue("starts_at"); startsAt != "" {
+		slot.StartsAt = startsAt
+	}
+	if endsAt := r.FormValue("ends_at"); endsAt != "" {
+		slot.EndsAt = endsAt
+	}
+	if capacity := r.FormValue("capacity"); capacity != "" {
+		val, err := strconv.Atoi(capacity)
+		if err != nil {
+			return errors.New("السعة يجب أن تكون عددًا صحيحًا")
+		}
+		slot.Capacity = val
+	}
+	if isActive := r.FormValue("is_active"); isActive != "" {
+		val, err := strconv.ParseBool(isActive)
+		if err != nil {
+			return errors.New("قيمة is_active غير صالحة")
+		}
+		slot.IsActive = val
+	}
+	return nil
+}
+
+// slotForOwner loads a slot from the path and checks that the user owns its
+// store or is an admin.
+func (app *application) slotForOwner(w http.ResponseWriter, r *http.Request) (*data.DeliverySlot, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف فترة التوصيل غير صالح"))
+		return nil, false
+	}
+
+	slot, err := app.Model.DeliverySlotDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if _, ok := app.storeForOwner(w, r, slot.StoreID); !ok {
+		return nil, false
+	}
+
+	return slot, true
+}
+
+// ListStoreDeliverySlotsHandler lists a store's weekly slots, or with
+// date=YYYY-MM-DD the slots that can still be booked that day and the places
+// left in each.
+func (app *application) ListStoreDeliverySlotsHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	_, err = app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	if date := r.URL.Query().Get("date"); date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			app.badRequestResponse(w, r, errors.New("التاريخ يجب أن يكون بصيغة YYYY-MM-DD"))
+			return
+		}
+		slots, err := app.Model.DeliverySlotDB.Availability(storeID, date, time.Now())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"delivery_slots": slots,
+		})
+		return
+	}
+
+	slots, err := app.Model.DeliverySlotDB.ListByStore(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"delivery_slots": slots,
+	})
+}
+
+func (app *application) CreateDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	slot := &data.DeliverySlot{StoreID: storeID, DayOfWeek: -1, IsActive: true}
+	err = readDeliverySlotForm(r, slot)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateDeliverySlot(v, slot)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.DeliverySlotDB.Insert(slot)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message":       "تم إنشاء فترة التوصيل بنجاح",
+		"delivery_slot": slot,
+	})
+}
+
+func (app *application) UpdateDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	slot, ok := app.slotForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := readDeliverySlotForm(r, slot)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateDeliverySlot(v, slot)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.DeliverySlotDB.Update(slot)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":       "تم تحديث فترة التوصيل بنجاح",
+		"delivery_slot": slot,
+	})
+}
+
+// DeleteDeliverySlotHandler deletes a slot. Orders already scheduled in it
+// keep their delivery time.
+func (app *application) DeleteDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	slot, ok := app.slotForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.DeliverySlotDB.Delete(slot.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم حذف فترة التوصيل بنجاح",
+	})
+}
+
+// HoldCartDeliverySlotHandler keeps a place in a slot for the cart while the
+// customer checks out. Checkout uses the held slot unless it is given another.
+func (app *application) HoldCartDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	cart, ok := app.userCart(w, r)
+	if !ok {
+		return
+	}
+	if cart.StoreID == nil {
+		app.badRequestResponse(w, r, errors.New("السلة فارغة"))
+		return
+	}
+
+	slotID, err := uuid.Parse(r.FormValue("slot_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف فترة التوصيل غير صالح"))
+		return
+	}
+	choice := data.SlotChoice{SlotID: slotID, Date: r.FormValue("date")}
+	if _, err := time.Parse("2006-01-02", choice.Date); err != nil {
+		app.badRequestResponse(w, r, errors.New("التاريخ يجب أن يكون بصيغة YYYY-MM-DD"))
+		return
+	}
+
+	reservation, err := app.Model.DeliverySlotDB.Hold(cart, choice, time.Now(), app.cfg.slots.holdTTL)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":     "تم حجز فترة التوصيل مؤقتاً",
+		"reservation": reservation,
+	})
+}
+
+func (app *application) ReleaseCartDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	cart, ok := app.userCart(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.DeliverySlotDB.ReleaseHold(cart.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم إلغاء حجز فترة التوصيل",
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"project/internal/data"
+	"project/internal/events"
+	"project/utils"
+
+	"github.com/google/uuid"
+)
+
+// DriverScorer ranks a driver for picking up an order at the given point.
+// Lower scores are better.
+type DriverScorer interface {
+	Score(pickupLat, pickupLng float64, driver data.Driver) float64
+}
+
+// NearestDriverScorer scores drivers by their straight-line distance in
+// kilometres to the pickup point.
+type NearestDriverScorer struct{}
+
+func (NearestDriverScorer) Score(pickupLat, pickupLng float64, driver data.Driver) float64 {
+	return utils.HaversineKm(pickupLat, pickupLng, *driver.Latitude, *driver.Longitude)
+}
+
+// rankDrivers orders drivers from best to worst score. Equal scores are
+// broken by user ID so the same input always yields the same order.
+func rankDrivers(pickupLat, pickupLng float64, drivers []data.Driver, scorer DriverScorer) []data.Driver {
+	type scored struct {
+		driver data.Driver
+		score  float64
+	}
+
+	candidates := make([]scored, 0, len(drivers))
+	for _, driver := range drivers {
+		if driver.Latitude == nil || driver.Longitude == nil {
+			continue
+		}
+		candidates = append(candidates, scored{driver, scorer.Score(pickupLat, pickupLng, driver)})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].driver.UserID.String() < candidates[j].driver.UserID.String()
+	})
+
+	ranked := make([]data.Driver, len(candidates))
+	for i, c := range candidates {
+		ranked[i] = c.driver
+	}
+	return ranked
+}
+
+// availableDrivers and offerQueue are what the dispatcher needs from
+// DriverDB and OrderAssignmentDB.
+type availableDrivers interface {
+	ListAvailable(orderID uuid.UUID, locatedSince, passedSince time.Time) ([]data.Driver, error)
+}
+
+type offerQueue interface {
+	ExpireOffers(now time.Time) (int64, error)
+	ListAwaitingDispatch() ([]data.DispatchOrder, error)
+	Offer(assignment *data.OrderAssignment) error
+}
+
+// Dispatcher offers ready orders to the best available driver. Offers that
+// expire or are rejected put the order back in the queue, and later passes
+// skip every driver who passed on it until reofferAfter has gone by, so an
+// order nobody took is offered around again rather than left waiting.
+type Dispatcher struct {
+	drivers     availableDrivers
+	assignments offerQueue
+	bus         *events.Bus
+	scorer      DriverScorer
+	log         *log.Logger
+
+	// interval is how often the queue is scanned when nothing triggers it,
+	// offerTTL how long a driver has to answer, reofferAfter how long a
+	// driver who passed on an order is left out before it may come back to
+	// them, and locationMaxAge how old a driver's last position may be before
+	// they are ignored.
+	interval       time.Duration
+	offerTTL       time.Duration
+	reofferAfter   time.Duration
+	locationMaxAge time.Duration
+	now            func() time.Time
+
+	trigger chan struct{}
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewDispatcher(model *data.Model, bus *events.Bus, scorer DriverScorer, interval, offerTTL, reofferAfter time.Duration, logger *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		drivers:        &model.DriverDB,
+		assignments:    &model.OrderAssignmentDB,
+		bus:            bus,
+		scorer:         scorer,
+		log:            logger,
+		interval:       interval,
+		offerTTL:       offerTTL,
+		reofferAfter:   reofferAfter,
+		locationMaxAge: 10 * time.Minute,
+		now:            time.Now,
+		trigger:        make(chan struct{}, 1),
+		quit:           make(chan struct{}),
+	}
+}
+
+// Start runs the dispatch loop in the background until Stop is called. A
+// pass also runs as soon as any order becomes ready.
+func (d *Dispatcher) Start() {
+	d.wg.Add(2)
+	go d.watchReadyOrders()
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			d.runOnce()
+
+			select {
+			case <-ticker.C:
+			case <-d.trigger:
+			case <-d.quit:
+				return
+			}
+		}
+	}()
+}
+
+// watchReadyOrders triggers a pass whenever the bus reports an order moving
+// to ready.
+func (d *Dispatcher) watchReadyOrders() {
+	defer d.wg.Done()
+
+	filter := events.Filter{Types: []string{events.OrderStatusChanged}}
+	for {
+		sub := d.bus.Subscribe(filter)
+		if d.forwardReady(sub) {
+			d.bus.Unsubscribe(sub)
+			return
+		}
+
+		// The subscription closed: either the bus is shutting down or we
+		// fell behind, in which case the periodic pass covers the gap.
+		select {
+		case <-d.bus.Done():
+			return
+		case <-d.quit:
+			return
+		default:
+		}
+	}
+}
+
+// forwardReady triggers a pass for every ready order seen on sub until the
+// subscription closes or the dispatcher stops, and reports which it was.
+func (d *Dispatcher) forwardReady(sub *events.Subscription) (stopped bool) {
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			var payload data.OrderEventPayload
+			if json.Unmarshal(event.Payload, &payload) == nil && payload.Order != nil &&
+				payload.Order.Status == data.OrderStatusReady {
+				d.Trigger()
+			}
+		case <-d.quit:
+			return true
+		}
+	}
+}
+
+// Stop ends the loop and waits for the current pass to finish.
+func (d *Dispatcher) Stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
+
+// Trigger asks for a dispatch pass without waiting for the next tick. It
+// never blocks; triggers that arrive during a pass are coalesced.
+func (d *Dispatcher) Trigger() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// runOnce expires stale offers and then offers every waiting order to its
+// best candidate.
+func (d *Dispatcher) runOnce() {
+	now := d.now()
+
+	expired, err := d.assignments.ExpireOffers(now)
+	if err != nil {
+		d.log.Printf("dispatcher: expiring offers: %v", err)
+		return
+	}
+	if expired > 0 {
+		d.log.Printf("dispatcher: %d offer(s) expired", expired)
+	}
+
+	orders, err := d.assignments.ListAwaitingDispatch()
+	if err != nil {
+		d.log.Printf("dispatcher: listing orders: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		err := d.dispatch(order, now)
+		if err != nil {
+			d.log.Printf("dispatcher: order %s: %v", order.OrderID, err)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(order data.DispatchOrder, now time.Time) error {
+	if order.StoreLatitude == nil || order.StoreLongitude == nil {
+		return errors.New("store has no coordinates")
+	}
+
+	drivers, err := d.drivers.ListAvailable(order.OrderID, now.Add(-d.locationMaxAge), now.Add(-d.reofferAfter))
+	if err != nil {
+		return err
+	}
+
+	ranked := rankDrivers(*order.StoreLatitude, *order.StoreLongitude, drivers, d.scorer)
+	if len(ranked) == 0 {
+		return nil
+	}
+
+	expiresAt := now.Add(d.offerTTL)
+	assignment := &data.OrderAssignment{
+		OrderID:   order.OrderID,
+		DriverID:  ranked[0].UserID,
+		ExpiresAt: &expiresAt,
+	}
+	err = d.assignments.Offer(assignment)
+	if errors.Is(err, data.ErrOrderAlreadyAssigned) {
+		// Assigned by hand or by another instance since we listed it.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"project/internal/data"
+
+	"github.com/google/uuid"
+)
+
+// pickup is the store the tests dispatch from.
+var pickup = struct{ lat, lng float64 }{32.8872, 13.1913}
+
+// testDriver is an online driver dlat degrees north of the pickup point, last
+// located at locatedAt.
+func testDriver(id string, dlat float64, locatedAt time.Time) data.Driver {
+	lat, lng := pickup.lat+dlat, pickup.lng
+	return data.Driver{
+		UserID:            uuid.MustParse(id),
+		IsOnline:          true,
+		Latitude:          &lat,
+		Longitude:         &lng,
+		LocationUpdatedAt: &locatedAt,
+	}
+}
+
+const (
+	driverA = "00000000-0000-0000-0000-00000000000a"
+	driverB = "00000000-0000-0000-0000-00000000000b"
+	driverC = "00000000-0000-0000-0000-00000000000c"
+	driverD = "00000000-0000-0000-0000-00000000000d"
+)
+
+type constantScorer float64
+
+func (s constantScorer) Score(float64, float64, data.Driver) float64 { return float64(s) }
+
+func TestRankDrivers(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	unlocated := data.Driver{UserID: uuid.MustParse(driverD), IsOnline: true}
+
+	tests := []struct {
+		name    string
+		drivers []data.Driver
+		scorer  DriverScorer
+		want    []string
+	}{
+		{
+			name:    "nearest first",
+			drivers: []data.Driver{testDriver(driverA, 0.03, now), testDriver(driverB, 0.01, now), testDriver(driverC, 0.02, now)},
+			scorer:  NearestDriverScorer{},
+			want:    []string{driverB, driverC, driverA},
+		},
+		{
+			name:    "equal distance broken by user ID",
+			drivers: []data.Driver{testDriver(driverC, 0.01, now), testDriver(driverA, 0.01, now), testDriver(driverB, 0.005, now)},
+			scorer:  NearestDriverScorer{},
+			want:    []string{driverB, driverA, driverC},
+		},
+		{
+			name:    "equal scores keep user ID order whatever the input order",
+			drivers: []data.Driver{testDriver(driverB, 0.01, now), testDriver(driverC, 0.02, now), testDriver(driverA, 0.03, now)},
+			scorer:  constantScorer(1),
+			want:    []string{driverA, driverB, driverC},
+		},
+		{
+			name:    "drivers without a location are left out",
+			drivers: []data.Driver{unlocated, testDriver(driverA, 0.02, now)},
+			scorer:  NearestDriverScorer{},
+			want:    []string{driverA},
+		},
+		{
+			name:   "no drivers",
+			scorer: NearestDriverScorer{},
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked := rankDrivers(pickup.lat, pickup.lng, tt.drivers, tt.scorer)
+			if len(ranked) != len(tt.want) {
+				t.Fatalf("ranked %d drivers, want %d", len(ranked), len(tt.want))
+			}
+			for i, driver := range ranked {
+				if driver.UserID.String() != tt.want[i] {
+					t.Errorf("rank %d: got %s, want %s", i, driver.UserID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// fakeDispatchDB keeps drivers, ready orders and assignments in memory,
+// following the rules of DriverDB.ListAvailable and OrderAssignmentDB.
+type fakeDispatchDB struct {
+	drivers     []data.Driver
+	orders      []data.DispatchOrder
+	assignments []data.OrderAssignment
+}
+
+func isLiveAssignment(status string) bool {
+	return status == data.AssignmentOffered || status == data.AssignmentAccepted || status == data.AssignmentPickedUp
+}
+
+// passedAt is when a driver rejected an offer or let it expire.
+func passedAt(a data.OrderAssignment) time.Time {
+	switch {
+	case a.RespondedAt != nil:
+		return *a.RespondedAt
+	case a.ExpiresAt != nil:
+		return *a.ExpiresAt
+	}
+	return a.OfferedAt
+}
+
+func (f *fakeDispatchDB) ListAvailable(orderID uuid.UUID, locatedSince, passedSince time.Time) ([]data.Driver, error) {
+	var drivers []data.Driver
+	for _, driver := range f.drivers {
+		if !driver.IsOnline || driver.LocationUpdatedAt == nil || driver.LocationUpdatedAt.Before(locatedSince) {
+			continue
+		}
+		busy := false
+		for _, a := range f.assignments {
+			if a.DriverID == driver.UserID && (isLiveAssignment(a.Status) ||
+				a.OrderID == orderID && passedAt(a).After(passedSince)) {
+				busy = true
+			}
+		}
+		if !busy {
+			drivers = append(drivers, driver)
+		}
+	}
+	return drivers, nil
+}
+
+func (f *fakeDispatchDB) ExpireOffers(now time.Time) (int64, error) {
+	var expired int64
+	for i := range f.assignments {
+		a := &f.assignments[i]
+		if a.Status == data.AssignmentOffered && a.ExpiresAt != nil && !a.ExpiresAt.After(now) {
+			a.Status = data.AssignmentExpired
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (f *fakeDispatchDB) ListAwaitingDispatch() ([]data.DispatchOrder, error) {
+	var orders []data.DispatchOrder
+	for _, order := range f.orders {
+		if f.live(order.OrderID) == nil {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (f *fakeDispatchDB) Offer(assignment *data.OrderAssignment) error {
+	if f.live(assignment.OrderID) != nil {
+		return data.ErrOrderAlreadyAssigned
+	}
+	assignment.ID = uuid.New()
+	assignment.Status = data.AssignmentOffered
+	f.assignments = append(f.assignments, *assignment)
+	return nil
+}
+
+// live returns the live assignment of an order, if any.
+func (f *fakeDispatchDB) live(orderID uuid.UUID) *data.OrderAssignment {
+	for i := range f.assignments {
+		if f.assignments[i].OrderID == orderID && isLiveAssignment(f.assignments[i].Status) {
+			return &f.assignments[i]
+		}
+	}
+	return nil
+}
+
+func newTestDispatcher(db *fakeDispatchDB, clock *time.Time) *Dispatcher {
+	return &Dispatcher{
+		drivers:        db,
+		assignments:    db,
+		scorer:         NearestDriverScorer{},
+		log:            log.New(io.Discard, "", 0),
+		offerTTL:       time.Minute,
+		reofferAfter:   5 * time.Minute,
+		locationMaxAge: 10 * time.Minute,
+		now:            func() time.Time { return *clock },
+	}
+}
+
+func readyOrder() data.DispatchOrder {
+	lat, lng := pickup.lat, pickup.lng
+	return data.DispatchOrder{OrderID: uuid.New(), StoreID: uuid.New(), StoreLatitude: &lat, StoreLongitude: &lng}
+}
+
+func TestDispatchSkipsDriversAlreadyOffered(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	rejectedAt := now.Add(-30 * time.Second)
+	order := readyOrder()
+	offline := testDriver(driverD, 0.001, now)
+	offline.IsOnline = false
+
+	db := &fakeDispatchDB{
+		drivers: []data.Driver{
+			testDriver(driverA, 0.01, now),
+			testDriver(driverB, 0.02, now),
+			testDriver(driverC, 0.005, now.Add(-11*time.Minute)),
+			offline,
+		},
+		orders: []data.DispatchOrder{order},
+		assignments: []data.OrderAssignment{
+			// The nearest driver already turned the order down.
+			{OrderID: order.OrderID, DriverID: uuid.MustParse(driverA), Status: data.AssignmentRejected, RespondedAt: &rejectedAt},
+		},
+	}
+	d := newTestDispatcher(db, &now)
+
+	d.runOnce()
+
+	offer := db.live(order.OrderID)
+	if offer == nil {
+		t.Fatal("order was not offered")
+	}
+	// A rejected it, C's location is stale and D is offline.
+	if offer.DriverID.String() != driverB {
+		t.Errorf("offered to %s, want %s", offer.DriverID, driverB)
+	}
+	if offer.ExpiresAt == nil || !offer.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("offer expires at %v, want %v", offer.ExpiresAt, now.Add(time.Minute))
+	}
+}
+
+func TestDispatchSkipsBusyDrivers(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	first, second := readyOrder(), readyOrder()
+	db := &fakeDispatchDB{
+		drivers: []data.Driver{testDriver(driverA, 0.01, now), testDriver(driverB, 0.02, now)},
+		orders:  []data.DispatchOrder{first, second},
+	}
+	d := newTestDispatcher(db, &now)
+
+	d.runOnce()
+
+	if got := db.live(first.OrderID); got == nil || got.DriverID.String() != driverA {
+		t.Errorf("first order offered to %v, want %s", got, driverA)
+	}
+	if got := db.live(second.OrderID); got == nil || got.DriverID.String() != driverB {
+		t.Errorf("second order offered to %v, want %s", got, driverB)
+	}
+}
+
+func TestDispatchReoffersExpiredOffers(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	order := readyOrder()
+	db := &fakeDispatchDB{
+		drivers: []data.Driver{testDriver(driverA, 0.01, start), testDriver(driverB, 0.02, start)},
+		orders:  []data.DispatchOrder{order},
+	}
+	d := newTestDispatcher(db, &now)
+
+	// Both drivers let their offers expire, at 1m and 2m. Each becomes
+	// eligible again five minutes after passing on the order.
+	steps := []struct {
+		at          time.Duration
+		wantDriver  string // "" when the order should have no live offer
+		wantExpires time.Duration
+		wantTotal   int
+	}{
+		{at: 0, wantDriver: driverA, wantExpires: time.Minute, wantTotal: 1},
+		{at: 59 * time.Second, wantDriver: driverA, wantExpires: time.Minute, wantTotal: 1},
+		{at: time.Minute, wantDriver: driverB, wantExpires: 2 * time.Minute, wantTotal: 2},
+		{at: 2 * time.Minute, wantDriver: "", wantTotal: 2},
+		{at: 6*time.Minute - time.Second, wantDriver: "", wantTotal: 2},
+		{at: 6 * time.Minute, wantDriver: driverA, wantExpires: 7 * time.Minute, wantTotal: 3},
+		{at: 7 * time.Minute, wantDriver: driverB, wantExpires: 8 * time.Minute, wantTotal: 4},
+	}
+	for _, step := range steps {
+		now = start.Add(step.at)
+		d.runOnce()
+
+		offer := db.live(order.OrderID)
+		switch {
+		case step.wantDriver == "" && offer != nil:
+			t.Errorf("at %v: order still offered to %s", step.at, offer.DriverID)
+		case step.wantDriver == "":
+		case offer == nil:
+			t.Errorf("at %v: order has no offer, want one to %s", step.at, step.wantDriver)
+		case offer.DriverID.String() != step.wantDriver:
+			t.Errorf("at %v: offered to %s, want %s", step.at, offer.DriverID, step.wantDriver)
+		case !offer.ExpiresAt.Equal(start.Add(step.wantExpires)):
+			t.Errorf("at %v: offer expires at %v, want %v", step.at, offer.ExpiresAt, start.Add(step.wantExpires))
+		}
+		if len(db.assignments) != step.wantTotal {
+			t.Errorf("at %v: %d assignments, want %d", step.at, len(db.assignments), step.wantTotal)
+		}
+	}
+
+	last := len(db.assignments) - 1
+	for i, a := range db.assignments {
+		want := data.AssignmentExpired
+		if i == last {
+			want = data.AssignmentOffered
+		}
+		if a.Status != want {
+			t.Errorf("offer %d to %s is %s, want %s", i, a.DriverID, a.Status, want)
+		}
+	}
+}
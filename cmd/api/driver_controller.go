@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// contextDriverID returns the ID of the authenticated delivery user.
+func (app *application) contextDriverID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userIDStr, ok := r.Context().Value(UserIDKey).(string)
+	if !ok {
+		app.unauthorizedResponse(w, r)
+		return uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المستخدم غير صالح"))
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+func (app *application) UpsertDriverHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	driver := &data.Driver{
+		UserID:      userID,
+		VehicleType: r.FormValue("vehicle_type"),
+	}
+	if plate := r.FormValue("vehicle_plate"); plate != "" {
+		driver.VehiclePlate = &plate
+	}
+
+	v := validator.New()
+	data.ValidateDriver(v, driver)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.Model.DriverDB.Upsert(driver)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم حفظ ملف السائق بنجاح",
+		"driver":  driver,
+	})
+}
+
+func (app *application) GetDriverHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	driver, err := app.Model.DriverDB.Get(userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"driver": driver,
+	})
+}
+
+func (app *application) DriverOnlineHandler(w http.ResponseWriter, r *http.Request) {
+	app.setDriverAvailability(w, r, true)
+}
+
+func (app *application) DriverOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	app.setDriverAvailability(w, r, false)
+}
+
+func (app *application) setDriverAvailability(w http.ResponseWriter, r *http.Request, online bool) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.DriverDB.SetOnline(userID, online)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	message := "أنت الآن غير متصل"
+	if online {
+		message = "أنت الآن متصل وتستقبل الطلبات"
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":   message,
+		"is_online": online,
+	})
+}
+
+func (app *application) UpdateDriverLocationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	latitude, err := strconv.ParseFloat(r.FormValue("latitude"), 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("خط العرض غير صالح"))
+		return
+	}
+	longitude, err := strconv.ParseFloat(r.FormValue("longitude"), 64)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("خط الطول غير صالح"))
+		return
+	}
+
+	v := validator.New()
+	data.ValidateLocation(v, latitude, longitude)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.DriverDB.UpdateLocation(userID, latitude, longitude)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث الموقع بنجاح",
+	})
+}
+
+func (app *application) ListDriverOffersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	offers, err := app.Model.OrderAssignmentDB.ListByDriver(userID, data.AssignmentOffered)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"offers": offers,
+	})
+}
+
+func (app *application) ListDriverAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := app.Model.OrderAssignmentDB.ListByDriver(userID, data.AssignmentAccepted, data.AssignmentPickedUp)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"assignments": assignments,
+	})
+}
+
+func (app *application) AcceptAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.respondToAssignment(w, r, true)
+}
+
+func (app *application) RejectAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.respondToAssignment(w, r, false)
+}
+
+func (app *application) respondToAssignment(w http.ResponseWriter, r *http.Request, accept bool) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف مهمة التوصيل غير صالح"))
+		return
+	}
+
+	assignment, err := app.Model.OrderAssignmentDB.Respond(id, userID, accept)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	message := "تم رفض الطلب"
+	if accept {
+		message = "تم قبول الطلب"
+	} else {
+		// Offer the order to the next driver straight away.
+		app.dispatcher.Trigger()
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":    message,
+		"assignment": assignment,
+	})
+}
+
+func (app *application) PickUpAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف مهمة التوصيل غير صالح"))
+		return
+	}
+
+	assignment, order, err := app.Model.OrderAssignmentDB.MarkPickedUp(id, userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":    "تم استلام الطلب من المتجر",
+		"assignment": assignment,
+		"order":      order,
+	})
+}
+
+func (app *application) DeliverAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := app.contextDriverID(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف مهمة التوصيل غير صالح"))
+		return
+	}
+
+	assignment, order, err := app.Model.OrderAssignmentDB.MarkDelivered(id, userID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":    "تم توصيل الطلب بنجاح",
+		"assignment": assignment,
+		"order":      order,
+	})
+}
@@ -69,6 +69,105 @@ func (app *application) handleRetrievalError(w http.ResponseWriter, r *http.Requ
 		app.errorResponse(w, r, http.StatusNotFound, "نوع الاشتراك غير موجود")
 	case errors.Is(err, data.ErrPhoneAlreadyInserted):
 		app.errorResponse(w, r, http.StatusConflict, "رقم الهاتف مسجل مسبقاً")
+	case errors.Is(err, data.ErrInvalidStatusTransition):
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن نقل الطلب إلى هذه الحالة")
+	case errors.Is(err, data.ErrStatusTransitionForbidden):
+		app.errorResponse(w, r, http.StatusForbidden, "غير مسموح لك بنقل الطلب إلى هذه الحالة")
+	case errors.Is(err, data.ErrOrderNotCancellable):
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن إلغاء الطلب في حالته الحالية")
+	case errors.Is(err, data.ErrCancellationReasonRequired):
+		app.errorResponse(w, r, http.StatusBadRequest, "يجب ذكر سبب الإلغاء")
+	case errors.Is(err, data.ErrOrderNotEditable):
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن تعديل تفاصيل التوصيل بعد بدء تجهيز الطلب")
+	case errors.Is(err, data.ErrDriverNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "ملف السائق غير موجود")
+	case errors.Is(err, data.ErrDriverOffline):
+		app.errorResponse(w, r, http.StatusConflict, "السائق غير متصل حالياً")
+	case errors.Is(err, data.ErrAssignmentNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "مهمة التوصيل غير موجودة")
+	case errors.Is(err, data.ErrOrderAlreadyAssigned):
+		app.errorResponse(w, r, http.StatusConflict, "الطلب مسند إلى سائق بالفعل")
+	case errors.Is(err, data.ErrInvalidAssignmentState):
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن تنفيذ هذا الإجراء على مهمة التوصيل في حالتها الحالية")
+	case errors.Is(err, data.ErrOfferExpired):
+		app.errorResponse(w, r, http.StatusGone, "انتهت صلاحية عرض التوصيل")
+	case errors.Is(err, data.ErrWebhookNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "الـ webhook غير موجود")
+	case errors.Is(err, data.ErrWebhookDeliveryNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "سجل الإرسال غير موجود")
+	case errors.Is(err, data.ErrIdempotencyKeyMismatch):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "مفتاح Idempotency-Key مستخدم مع طلب مختلف")
+	case errors.Is(err, data.ErrIdempotencyKeyInUse):
+		app.errorResponse(w, r, http.StatusConflict, "طلب آخر بنفس مفتاح Idempotency-Key قيد التنفيذ")
+	case errors.Is(err, data.ErrCouponNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "الكوبون غير موجود")
+	case errors.Is(err, data.ErrDuplicateCouponCode):
+		app.errorResponse(w, r, http.StatusConflict, "رمز الكوبون مستخدم بالفعل")
+	case errors.Is(err, data.ErrCouponNotActive):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "الكوبون غير فعال حالياً")
+	case errors.Is(err, data.ErrCouponNotApplicable):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "الكوبون لا ينطبق على هذا المتجر")
+	case errors.Is(err, data.ErrCouponMinBasket):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "قيمة السلة أقل من الحد الأدنى للكوبون")
+	case errors.Is(err, data.ErrCouponUsageLimit):
+		app.errorResponse(w, r, http.StatusConflict, "تم استنفاد عدد مرات استخدام الكوبون")
+	case errors.Is(err, data.ErrCouponUserLimit):
+		app.errorResponse(w, r, http.StatusConflict, "لقد استخدمت هذا الكوبون الحد الأقصى من المرات")
+	case errors.Is(err, data.ErrOutsideDeliveryRadius):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "عنوان التوصيل خارج نطاق توصيل المتجر")
+	case errors.Is(err, data.ErrBelowMinimumOrder):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "قيمة الطلب أقل من الحد الأدنى للمتجر")
+	case errors.Is(err, data.ErrDeliveryLocationRequired):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "يجب تحديد موقع التوصيل لحساب رسوم التوصيل")
+	case errors.Is(err, data.ErrOutsideDeliveryZone):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "عنوان التوصيل خارج مناطق توصيل المتجر")
+	case errors.Is(err, data.ErrDeliveryZoneNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "منطقة التوصيل غير موجودة")
+	case errors.Is(err, data.ErrDuplicateDeliveryZone):
+		app.errorResponse(w, r, http.StatusConflict, "اسم منطقة التوصيل مستخدم بالفعل")
+	case errors.Is(err, data.ErrStoreClosed):
+		// The message says when the store opens again.
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, data.ErrStoreHolidayNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "العطلة غير موجودة")
+	case errors.Is(err, data.ErrDuplicateStoreHoliday):
+		app.errorResponse(w, r, http.StatusConflict, "توجد عطلة مسجلة في هذا التاريخ بالفعل")
+	case errors.Is(err, data.ErrDeliverySlotNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "فترة التوصيل غير موجودة")
+	case errors.Is(err, data.ErrDeliverySlotUnavailable):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "فترة التوصيل غير متاحة في هذا التاريخ")
+	case errors.Is(err, data.ErrDeliverySlotFull):
+		app.errorResponse(w, r, http.StatusConflict, "فترة التوصيل ممتلئة")
+	case errors.Is(err, data.ErrProductCategoryNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "التصنيف غير موجود")
+	case errors.Is(err, data.ErrDuplicateProductCategory):
+		app.errorResponse(w, r, http.StatusConflict, "يوجد تصنيف بنفس الاسم في هذا المستوى")
+	case errors.Is(err, data.ErrInvalidCategoryParent):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "لا يمكن نقل التصنيف إلى أحد تصنيفاته الفرعية")
+	case errors.Is(err, data.ErrOptionGroupNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "مجموعة الخيارات غير موجودة")
+	case errors.Is(err, data.ErrProductOptionNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "الخيار غير موجود")
+	case errors.Is(err, data.ErrProductOptionUnavailable):
+		app.errorResponse(w, r, http.StatusConflict, "أحد الخيارات المختارة غير متوفر بالكمية المطلوبة")
+	case errors.Is(err, data.ErrInvalidOptionChoice):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "الخيارات المختارة لأحد المنتجات لم تعد صالحة")
+	case errors.Is(err, data.ErrDuplicateSKU):
+		app.errorResponse(w, r, http.StatusConflict, "يوجد منتج بنفس الرمز في هذا المتجر")
+	case errors.Is(err, data.ErrReviewNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "التقييم غير موجود")
+	case errors.Is(err, data.ErrReviewNotAllowed):
+		app.errorResponse(w, r, http.StatusForbidden, "يمكنك تقييم المنتجات التي استلمتها فقط")
+	case errors.Is(err, data.ErrDuplicateReview):
+		app.errorResponse(w, r, http.StatusConflict, "لقد قمت بتقييم هذا المنتج لهذا الطلب بالفعل")
+	case errors.Is(err, data.ErrOrderRatingNotFound):
+		app.errorResponse(w, r, http.StatusNotFound, "لم يتم تقييم الطلب بعد")
+	case errors.Is(err, data.ErrOrderNotDelivered):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "لا يمكن تقييم الطلب قبل توصيله")
+	case errors.Is(err, data.ErrDuplicateOrderRating):
+		app.errorResponse(w, r, http.StatusConflict, "لقد قمت بتقييم هذا الطلب بالفعل")
+	case errors.Is(err, data.ErrNoCourier):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "لم يوصل هذا الطلب مندوب توصيل")
 
 	default:
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait bounds a single write to a subscriber.
+	writeWait = 10 * time.Second
+	// pongWait is how long a subscriber may stay silent before it is dropped.
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so healthy peers always answer in time.
+	pingPeriod = (pongWait * 9) / 10
+	// maxMessageSize caps what a subscriber may send; only location pings are expected.
+	maxMessageSize = 512
+	// sendBuffer is how many events may queue for a subscriber before it is
+	// considered too slow and disconnected.
+	sendBuffer = 16
+)
+
+// OrderEvent is pushed to everyone watching an order.
+type OrderEvent struct {
+	Type      string    `json:"type"`
+	OrderID   uuid.UUID `json:"order_id"`
+	Status    string    `json:"status,omitempty"`
+	Latitude  *float64  `json:"latitude,omitempty"`
+	Longitude *float64  `json:"longitude,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+const (
+	OrderEventStatus   = "status"
+	OrderEventLocation = "location"
+)
+
+// Hub fans order events out to the WebSocket connections watching each order.
+type Hub struct {
+	mu     sync.Mutex
+	rooms  map[uuid.UUID]map[*hubClient]struct{}
+	closed bool
+	wg     sync.WaitGroup
+	log    *log.Logger
+}
+
+type hubClient struct {
+	conn    *websocket.Conn
+	orderID uuid.UUID
+	send    chan []byte
+}
+
+func NewHub(logger *log.Logger) *Hub {
+	return &Hub{
+		rooms: make(map[uuid.UUID]map[*hubClient]struct{}),
+		log:   logger,
+	}
+}
+
+// register adds a connection to its order's room and starts its writer. It
+// returns nil once the hub has been shut down.
+func (h *Hub) register(conn *websocket.Conn, orderID uuid.UUID) *hubClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	client := &hubClient{conn: conn, orderID: orderID, send: make(chan []byte, sendBuffer)}
+	room, ok := h.rooms[orderID]
+	if !ok {
+		room = make(map[*hubClient]struct{})
+		h.rooms[orderID] = room
+	}
+	room[client] = struct{}{}
+
+	h.wg.Add(1)
+	go h.writePump(client)
+
+	return client
+}
+
+// unregister removes a connection. Closing its send channel makes the
+// writer say goodbye and close the socket.
+func (h *Hub) unregister(client *hubClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(client)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(client *hubClient) {
+	room, ok := h.rooms[client.orderID]
+	if !ok {
+		return
+	}
+	if _, ok := room[client]; !ok {
+		return
+	}
+
+	delete(room, client)
+	if len(room) == 0 {
+		delete(h.rooms, client.orderID)
+	}
+	close(client.send)
+}
+
+// Publish sends an event to every connection watching its order.
+// Connections that cannot keep up are dropped rather than blocking the caller.
+func (h *Hub) Publish(event OrderEvent) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		h.log.Printf("hub: encoding event: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.rooms[event.OrderID] {
+		h.deliver(client, message)
+	}
+}
+
+// sendTo sends an event to a single connection.
+func (h *Hub) sendTo(client *hubClient, event OrderEvent) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		h.log.Printf("hub: encoding event: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.rooms[client.orderID][client]; ok {
+		h.deliver(client, message)
+	}
+}
+
+// deliver must be called with h.mu held.
+func (h *Hub) deliver(client *hubClient, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		h.remove(client)
+	}
+}
+
+// Shutdown disconnects every subscriber with a going-away close frame and
+// waits for the writers to finish.
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	h.closed = true
+	for _, room := range h.rooms {
+		for client := range room {
+			h.remove(client)
+		}
+	}
+	h.mu.Unlock()
+
+	h.wg.Wait()
+}
+
+func (h *Hub) writePump(client *hubClient) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+		h.wg.Done()
+	}()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump reads from a connection until it fails or handle returns false,
+// passing every text message to handle. It keeps the read deadline moving on
+// each pong so dead peers are noticed within pongWait.
+func (h *Hub) readPump(client *hubClient, handle func(message []byte) bool) {
+	defer h.unregister(client)
+
+	client.conn.SetReadLimit(maxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.conn.SetPongHandler(func(string) error {
+		client.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		messageType, message, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.log.Printf("hub: order %s: %v", client.orderID, err)
+			}
+			return
+		}
+		if messageType == websocket.TextMessage && handle != nil && !handle(message) {
+			return
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/utils"
+
+	"github.com/google/uuid"
+)
+
+// ProductInventoryHandler lists the movements of a product's stock, newest
+// first, for its store's owner.
+func (app *application) ProductInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنتج غير صالح"))
+		return
+	}
+	product, ok := app.productForOwner(w, r, productID)
+	if !ok {
+		return
+	}
+
+	movements, meta, err := app.Model.InventoryMovementDB.ListByProduct(product.ID, r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"product":   product,
+		"movements": movements,
+		"meta":      meta,
+	})
+}
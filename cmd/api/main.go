@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"project/internal/data"
+	"project/internal/events"
 	"project/utils"
 
 	"github.com/jmoiron/sqlx"
@@ -28,6 +29,15 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	dispatch struct {
+		interval     time.Duration
+		offerTTL     time.Duration
+		reofferAfter time.Duration
+	}
+	slots struct {
+		holdTTL         time.Duration
+		releaseInterval time.Duration
+	}
 }
 
 type application struct {
@@ -35,6 +45,12 @@ type application struct {
 	log     *log.Logger
 	Model   data.Model
 	infoLog *log.Logger
+
+	dispatcher *Dispatcher
+	slots      *SlotReleaser
+	hub        *Hub
+	events     *events.Bus
+	webhooks   *WebhookSender
 }
 
 func main() {
@@ -51,6 +67,11 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.dispatch.interval, "dispatch-interval", 15*time.Second, "How often ready orders are offered to drivers")
+	flag.DurationVar(&cfg.dispatch.offerTTL, "dispatch-offer-ttl", time.Minute, "How long a driver has to answer a delivery offer")
+	flag.DurationVar(&cfg.dispatch.reofferAfter, "dispatch-reoffer-after", 10*time.Minute, "How long before an order a driver passed on may be offered to them again")
+	flag.DurationVar(&cfg.slots.holdTTL, "slot-hold-ttl", 10*time.Minute, "How long a cart holds a delivery slot place before checkout")
+	flag.DurationVar(&cfg.slots.releaseInterval, "slot-release-interval", time.Minute, "How often expired delivery slot holds are released")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -71,6 +92,21 @@ func main() {
 	}
 	utils.SetDB(db)
 
+	app.hub = NewHub(logger)
+	app.events = events.NewBus(db, cfg.db.dsn, logger)
+	if err := app.events.Start(); err != nil {
+		log.Fatal(err)
+	}
+	go app.relayOrderEvents()
+	app.dispatcher = NewDispatcher(&app.Model, app.events, NearestDriverScorer{}, cfg.dispatch.interval, cfg.dispatch.offerTTL, cfg.dispatch.reofferAfter, infoLog)
+	app.dispatcher.Start()
+	app.slots = NewSlotReleaser(&app.Model, cfg.slots.releaseInterval, infoLog)
+	app.slots.Start()
+	app.webhooks = NewWebhookSender(&app.Model, app.events, logger)
+	if err := app.webhooks.Start(); err != nil {
+		log.Fatal(err)
+	}
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
 		Handler:      app.Router(),
@@ -91,12 +127,20 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
+		// Close streaming subscribers first: Shutdown does not wait for
+		// hijacked connections and would wait out open event streams.
+		app.hub.Shutdown()
+		app.webhooks.Stop()
+		app.events.Shutdown()
+
 		// Shutdown server
 		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("Error during shutdown: %v", err)
 		} else {
 			log.Println("Server shutdown completed.")
 		}
+		app.dispatcher.Stop()
+		app.slots.Stop()
 		app.cleanup()
 
 		done <- true
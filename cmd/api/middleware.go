@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"project/internal/data"
 	"project/utils"
 	"strings"
 	"sync"
@@ -39,12 +43,18 @@ func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
 				return
 			}
 
+			if exp, ok := claims["exp"].(float64); !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+				app.jwtErrorResponse(w, r, utils.ErrExpiredToken)
+				return
+			}
+
 			userID, okID := claims["id"].(string)
 			if !okID {
 				app.jwtErrorResponse(w, r, utils.ErrInvalidClaims)
 				return
 			}
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, UserRoleKey, rolesFromClaims(claims))
 			r = r.WithContext(ctx)
 		} else {
 			var tokenString string
@@ -90,19 +100,8 @@ func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
 				return
 			}
 
-			var userRoles []string
-			userRolesInterface, okRoles := claims["user_role"].([]interface{})
-
-			if okRoles && len(userRolesInterface) > 0 {
-				userRoles = make([]string, 0, len(userRolesInterface))
-				for _, role := range userRolesInterface {
-					if roleStr, ok := role.(string); ok && roleStr != "NULL" && roleStr != "" {
-						userRoles = append(userRoles, roleStr)
-					}
-				}
-			}
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
-			ctx = context.WithValue(ctx, UserRoleKey, userRoles)
+			ctx = context.WithValue(ctx, UserRoleKey, rolesFromClaims(claims))
 			r = r.WithContext(ctx)
 		}
 
@@ -110,6 +109,32 @@ func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
 	})
 }
 
+// rolesFromClaims extracts the user's role names from the token claims.
+func rolesFromClaims(claims jwt.MapClaims) []string {
+	var userRoles []string
+	userRolesInterface, okRoles := claims["user_role"].([]interface{})
+
+	if okRoles && len(userRolesInterface) > 0 {
+		userRoles = make([]string, 0, len(userRolesInterface))
+		for _, role := range userRolesInterface {
+			if roleStr, ok := role.(string); ok && roleStr != "NULL" && roleStr != "" {
+				userRoles = append(userRoles, roleStr)
+			}
+		}
+	}
+	return userRoles
+}
+
+// hasRole reports whether the role list taken from the token contains role.
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
@@ -119,7 +144,7 @@ func secureHeaders(next http.Handler) http.Handler {
 		w.Header().Set("X-XSS-Protection", "0")
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID, Idempotency-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
@@ -220,6 +245,158 @@ func (app *application) StoreOwnerOnlyMiddleware(next http.Handler) http.Handler
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) DriverOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userRoles, ok := r.Context().Value(UserRoleKey).([]string)
+		if !ok {
+			app.unauthorizedResponse(w, r)
+			return
+		}
+
+		if !hasRole(userRoles, "delivery") {
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+const (
+	// idempotencyKeyTTL is how long a stored response is replayed for.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long an unfinished request holds its key
+	// before a retry may take it over. It must exceed any handler's run time.
+	idempotencyLockTimeout  = time.Minute
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyRecorder passes a response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// requestFingerprint hashes what makes two requests the same: method, path
+// and form values. Multipart boundaries differ between retries, so the
+// parsed form is hashed rather than the raw body.
+func requestFingerprint(r *http.Request) (string, error) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + r.Form.Encode()))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// IdempotencyMiddleware makes a mutating endpoint safe to retry. Requests
+// carrying an Idempotency-Key header run once per user and key; retries get
+// the stored response, and reusing a key for a different request is
+// rejected. Server errors are not stored so the request can be retried.
+// It must run after AuthMiddleware.
+func (app *application) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, errors.New("مفتاح Idempotency-Key طويل جداً"))
+			return
+		}
+
+		userIDStr, ok := r.Context().Value(UserIDKey).(string)
+		if !ok {
+			app.unauthorizedResponse(w, r)
+			return
+		}
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			app.unauthorizedResponse(w, r)
+			return
+		}
+
+		hash, err := requestFingerprint(r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		existing, err := app.Model.IdempotencyKeyDB.Reserve(&data.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestHash: hash,
+		}, now.Add(-idempotencyKeyTTL), now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != hash:
+				app.handleRetrievalError(w, r, data.ErrIdempotencyKeyMismatch)
+			case existing.StatusCode == nil:
+				app.handleRetrievalError(w, r, data.ErrIdempotencyKeyInUse)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(*existing.StatusCode)
+				w.Write(existing.ResponseBody)
+			}
+			return
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				if err := app.Model.IdempotencyKeyDB.Release(userID, key); err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+
+		err = app.Model.IdempotencyKeyDB.Complete(userID, key, rec.status, rec.body.Bytes())
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
 func (app *application) AdminOrSelfMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract user roles from context
@@ -493,19 +670,12 @@ func (app *application) OrderAccessMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check if user is the order owner
-		if order.UserID == userID {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Check if user is the store owner
-		store, err := app.Model.StoreDB.GetStore(order.StoreID)
+		actor, err := app.canAccessOrder(userID, userRoles, order)
 		if err != nil {
 			app.handleRetrievalError(w, r, err)
+			return
 		}
-
-		if store.OwnerID == userID || isAdmin {
+		if actor != "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -513,3 +683,38 @@ func (app *application) OrderAccessMiddleware(next http.Handler) http.Handler {
 		app.forbiddenResponse(w, r)
 	})
 }
+
+// canAccessOrder returns the capacity in which a user may see and act on an
+// order: admin, owner of the order's store, driver who accepted or picked it
+// up, or the customer who placed it, in that order of precedence. A driver
+// who has only been offered the order gets no access to it. It returns an
+// empty actor when the user has no access.
+func (app *application) canAccessOrder(userID uuid.UUID, userRoles []string, order *data.Order) (data.OrderActor, error) {
+	if hasRole(userRoles, "admin") {
+		return data.ActorAdmin, nil
+	}
+
+	store, err := app.Model.StoreDB.GetStore(order.StoreID)
+	if err != nil {
+		return "", err
+	}
+	if store.OwnerID == userID {
+		return data.ActorStoreOwner, nil
+	}
+
+	if hasRole(userRoles, "delivery") {
+		assignment, err := app.Model.OrderAssignmentDB.GetActiveByOrder(order.ID)
+		if err != nil {
+			return "", err
+		}
+		if assignment != nil && assignment.DriverID == userID && assignment.HoldsOrder() {
+			return data.ActorDelivery, nil
+		}
+	}
+
+	if order.UserID == userID {
+		return data.ActorCustomer, nil
+	}
+
+	return "", nil
+}
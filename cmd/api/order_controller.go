@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"project/internal/data"
+	"project/internal/events"
 	"project/utils"
 	"project/utils/validator"
 	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -42,6 +44,20 @@ func (app *application) CreateOrderFromCartHandler(w http.ResponseWriter, r *htt
 	if notes := r.FormValue("delivery_notes"); notes != "" {
 		deliveryNotes = &notes // Fixed typo: was '¬es'
 	}
+	// A delivery slot schedules the order for later instead of now
+	var slot *data.SlotChoice
+	if slotIDStr := r.FormValue("delivery_slot_id"); slotIDStr != "" {
+		slotID, err := uuid.Parse(slotIDStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف فترة التوصيل غير صالح"))
+			return
+		}
+		slot = &data.SlotChoice{SlotID: slotID, Date: r.FormValue("delivery_date")}
+		if _, err := time.Parse("2006-01-02", slot.Date); err != nil {
+			app.badRequestResponse(w, r, errors.New("تاريخ التوصيل يجب أن يكون بصيغة YYYY-MM-DD"))
+			return
+		}
+	}
 
 	// Get cart and items
 	cart, err := app.Model.CartDB.Get(cartID)
@@ -78,8 +94,8 @@ func (app *application) CreateOrderFromCartHandler(w http.ResponseWriter, r *htt
 	// Create order
 	order := &data.Order{
 		UserID:            cart.UserID,
-		StoreID:           *cart.StoreID, // From cart, as discussed
-		TotalPrice:        0,             // Will be calculated in model
+		StoreID:           *cart.StoreID,    // From cart, as discussed
+		TotalPrice:        data.NewMoney(0), // Will be calculated in model
 		Status:            "pending",
 		DeliveryAddress:   deliveryAddress,
 		DeliveryLatitude:  deliveryLatitude,
@@ -99,9 +115,15 @@ func (app *application) CreateOrderFromCartHandler(w http.ResponseWriter, r *htt
 	}
 
 	// Create order from cart via model
-	err = app.Model.OrderDB.CreateFromCart(order, cartID, items)
+	err = app.Model.OrderDB.CreateFromCart(order, cartID, items, slot)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		var stockErr *data.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			app.errorResponse(w, r, http.StatusConflict,
+				fmt.Sprintf("الكمية المطلوبة من المنتج %s غير متاحة (المتوفر: %d)", stockErr.ProductName, stockErr.Available))
+			return
+		}
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
@@ -133,9 +155,16 @@ func (app *application) GetOrderHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	discounts, err := app.Model.OrderDiscountDB.ListByOrder(order.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
-		"order": order,
-		"items": items,
+		"order":     order,
+		"items":     items,
+		"discounts": discounts,
 	})
 }
 
@@ -153,42 +182,252 @@ func (app *application) UpdateOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if status := r.FormValue("status"); status != "" {
-		order.Status = status
-	}
+	detailsChanged, locationChanged := false, false
 	if deliveryAddress := r.FormValue("delivery_address"); deliveryAddress != "" {
 		order.DeliveryAddress = deliveryAddress
+		detailsChanged = true
 	}
 	if lat := r.FormValue("delivery_latitude"); lat != "" {
 		if val, err := strconv.ParseFloat(lat, 64); err == nil {
 			order.DeliveryLatitude = &val
+			detailsChanged, locationChanged = true, true
+		} else {
+			app.badRequestResponse(w, r, errors.New("خطأ في تنسيق خط العرض"))
+			return
 		}
 	}
 	if lon := r.FormValue("delivery_longitude"); lon != "" {
 		if val, err := strconv.ParseFloat(lon, 64); err == nil {
 			order.DeliveryLongitude = &val
+			detailsChanged, locationChanged = true, true
+		} else {
+			app.badRequestResponse(w, r, errors.New("خطأ في تنسيق خط الطول"))
+			return
 		}
 	}
 	if notes := r.FormValue("delivery_notes"); notes != "" {
 		order.DeliveryNotes = &notes
+		detailsChanged = true
 	}
 
+	status := r.FormValue("status")
 	v := validator.New()
 	data.ValidateOrder(v, order)
+	if locationChanged {
+		var lat, lon float64
+		if order.DeliveryLatitude != nil {
+			lat = *order.DeliveryLatitude
+		}
+		if order.DeliveryLongitude != nil {
+			lon = *order.DeliveryLongitude
+		}
+		data.ValidateLocation(v, lat, lon)
+	}
+	if status != "" {
+		v.Check(validator.In(status, data.OrderStatuses...), "status", "حالة الطلب غير صالحة")
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.Model.OrderDB.Update(order)
+	to := ""
+	if status != "" && status != order.Status {
+		to = status
+	}
+
+	if detailsChanged || to != "" {
+		actorID, actor, err := app.orderActor(r, order)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+		// Drivers move orders through their assignment's pickup and deliver
+		// endpoints, which keep the assignment in step with the order.
+		if actor == data.ActorDelivery {
+			app.handleRetrievalError(w, r, data.ErrStatusTransitionForbidden)
+			return
+		}
+		// Only the customer and the store owner decide where an order goes.
+		if detailsChanged && actor != data.ActorCustomer && actor != data.ActorStoreOwner {
+			app.forbiddenResponse(w, r)
+			return
+		}
+		var reason *string
+		if text := r.FormValue("reason"); text != "" {
+			reason = &text
+		}
+		_, err = app.Model.OrderDB.Edit(order, detailsChanged, to, actorID, actor, reason)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث الطلب بنجاح",
+		"order":   order,
+	})
+}
+
+func (app *application) CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	order, err := app.Model.OrderDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	actorID, actor, err := app.orderActor(r, order)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	var reason *string
+	if text := r.FormValue("reason"); text != "" {
+		reason = &text
+	}
+
+	entry, err := app.Model.OrderDB.Cancel(order, actorID, actor, reason)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":        "تم إلغاء الطلب بنجاح",
+		"order":          order,
+		"status_history": entry,
+	})
+}
+
+func (app *application) GetOrderHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	order, err := app.Model.OrderDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	history, err := app.Model.OrderStatusHistoryDB.ListByOrder(order.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	_, actor, err := app.orderActor(r, order)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
-		"message": "تم تحديث الطلب بنجاح",
-		"order":   order,
+		"order_id":       order.ID,
+		"status":         order.Status,
+		"next_statuses":  data.NextStatuses(order.Status, actor),
+		"status_history": history,
+	})
+}
+
+// orderActor works out in which capacity the current user acts on an order:
+// admins first, then the owner of the order's store, then the customer who
+// placed it. Anyone else is refused with ErrStatusTransitionForbidden.
+func (app *application) orderActor(r *http.Request, order *data.Order) (uuid.UUID, data.OrderActor, error) {
+	userIDStr, _ := r.Context().Value(UserIDKey).(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return uuid.Nil, "", data.ErrStatusTransitionForbidden
+	}
+
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	actor, err := app.canAccessOrder(userID, userRoles, order)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if actor == "" {
+		return uuid.Nil, "", data.ErrStatusTransitionForbidden
+	}
+
+	return userID, actor, nil
+}
+
+// AssignOrderHandler lets the store owner or an admin offer an order to an online driver.
+// The offer expires like the dispatcher's own, so an unanswered one frees the driver.
+func (app *application) AssignOrderHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	driverID, err := uuid.Parse(r.FormValue("driver_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف السائق غير صالح"))
+		return
+	}
+
+	order, err := app.Model.OrderDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	actorID, actor, err := app.orderActor(r, order)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if actor != data.ActorStoreOwner && actor != data.ActorAdmin {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	// A pending order cannot be picked up yet, so only orders the store has
+	// started on can go to a driver.
+	if !validator.In(order.Status, data.OrderStatusProcessing, data.OrderStatusReady) {
+		app.errorResponse(w, r, http.StatusConflict, "لا يمكن إسناد الطلب في حالته الحالية")
+		return
+	}
+
+	driver, err := app.Model.DriverDB.Get(driverID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if !driver.IsOnline {
+		app.handleRetrievalError(w, r, data.ErrDriverOffline)
+		return
+	}
+
+	expiresAt := time.Now().Add(app.cfg.dispatch.offerTTL)
+	assignment := &data.OrderAssignment{
+		OrderID:    order.ID,
+		DriverID:   driver.UserID,
+		AssignedBy: &actorID,
+		ExpiresAt:  &expiresAt,
+	}
+	err = app.Model.OrderAssignmentDB.Offer(assignment)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message":    "تم عرض الطلب على السائق",
+		"assignment": assignment,
 	})
 }
 
@@ -214,6 +453,19 @@ func (app *application) DeleteOrderHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	order, err := app.Model.OrderDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	// Deleting an active order would lose the stock it holds, so it has to be
+	// cancelled (or delivered) first.
+	if order.Status != data.OrderStatusCancelled && order.Status != data.OrderStatusDelivered {
+		app.errorResponse(w, r, http.StatusConflict, "يجب إلغاء الطلب قبل حذفه")
+		return
+	}
+
 	err = app.Model.OrderDB.Delete(id)
 	if err != nil {
 		app.handleRetrievalError(w, r, err)
@@ -230,11 +482,42 @@ func (app *application) ListStoreOrdersHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
 	// Get query parameters for filtering, sorting, and pagination
 	queryParams := r.URL.Query()
 	includeItems := queryParams.Get("include_items") == "true"
 
-	orders, meta, err := app.Model.OrderDB.ListByStore(storeID, queryParams, includeItems)
+	// Scheduled orders can be narrowed down to a slot and a delivery date
+	var schedule data.ScheduleFilter
+	if value := queryParams.Get("scheduled"); value != "" {
+		scheduled, err := strconv.ParseBool(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("قيمة scheduled غير صالحة"))
+			return
+		}
+		schedule.Scheduled = &scheduled
+	}
+	if value := queryParams.Get("delivery_slot_id"); value != "" {
+		slotID, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف فترة التوصيل غير صالح"))
+			return
+		}
+		schedule.SlotID = &slotID
+	}
+	if value := queryParams.Get("scheduled_date"); value != "" {
+		date, err := time.ParseInLocation("2006-01-02", value, data.StoreLocation)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("تاريخ التوصيل يجب أن يكون بصيغة YYYY-MM-DD"))
+			return
+		}
+		schedule.Date = &date
+	}
+
+	orders, meta, err := app.Model.OrderDB.ListByStore(storeID, queryParams, includeItems, schedule)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -245,3 +528,129 @@ func (app *application) ListStoreOrdersHandler(w http.ResponseWriter, r *http.Re
 		"meta":   meta,
 	})
 }
+
+// storeStreamBatch is how many missed events are read at a time when a
+// client resumes.
+const storeStreamBatch = 500
+
+// StoreOrdersStreamHandler streams new orders, status changes and low stock
+// warnings for every store the user owns as Server-Sent Events. Clients
+// resume after a disconnect by sending the last event ID they saw, either in
+// the Last-Event-ID header or the last_event_id query parameter.
+func (app *application) StoreOrdersStreamHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := r.Context().Value(UserIDKey).(string)
+	if !ok {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المستخدم غير صالح"))
+		return
+	}
+
+	storeIDs, err := app.Model.StoreDB.ListIDsByOwner(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(storeIDs) == 0 {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	var resumeFrom *events.Cursor
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		cursor, err := events.ParseCursor(lastEventID)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف الحدث الأخير غير صالح"))
+			return
+		}
+		resumeFrom = &cursor
+	}
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Subscribe before catching up so nothing committed in between is lost;
+	// duplicates are dropped by comparing positions.
+	filter := events.Filter{
+		Types:    []string{events.OrderCreated, events.OrderStatusChanged, events.ProductStockLow},
+		StoreIDs: storeIDs,
+	}
+	sub := app.events.Subscribe(filter)
+	defer app.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	var last events.Cursor
+	if resumeFrom != nil {
+		last = *resumeFrom
+		for {
+			batch, err := app.events.ListAfter(last, filter, storeStreamBatch)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			for _, event := range batch {
+				if writeStoreEvent(w, event) != nil {
+					return
+				}
+				last = event.Cursor()
+			}
+			if len(batch) < storeStreamBatch {
+				break
+			}
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(25 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if resumeFrom != nil && !event.Cursor().After(last) {
+				continue
+			}
+			if writeStoreEvent(w, event) != nil {
+				return
+			}
+			last = event.Cursor()
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeStoreEvent(w http.ResponseWriter, event events.Event) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Cursor(), event.Type, event.Payload)
+	return err
+}
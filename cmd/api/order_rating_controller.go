@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// parseRatingTime reads a date (2006-01-02) in the stores' time zone or an
+// RFC3339 time. A date is the start of that day, or with endOfDay the start
+// of the next one, so that an exclusive bound still covers the whole day.
+func parseRatingTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, data.StoreLocation); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// RateOrderHandler records the customer's rating of their delivered order.
+// courier may only be given when a driver delivered the order.
+func (app *application) RateOrderHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	order, err := app.Model.OrderDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if order.UserID != userID {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	rating := &data.OrderRating{OrderID: order.ID}
+	v := validator.New()
+	for field, dest := range map[string]*int{"speed": &rating.Speed, "packaging": &rating.Packaging} {
+		value := r.FormValue(field)
+		if value == "" {
+			v.AddError(field, "هذا الحقل مطلوب")
+			continue
+		}
+		val, err := strconv.Atoi(value)
+		if err != nil {
+			v.AddError(field, "التقييم يجب أن يكون عددًا صحيحًا من 1 إلى 5")
+			continue
+		}
+		*dest = val
+	}
+	if courier := r.FormValue("courier"); courier != "" {
+		val, err := strconv.Atoi(courier)
+		if err != nil {
+			v.AddError("courier", "التقييم يجب أن يكون عددًا صحيحًا من 1 إلى 5")
+		} else {
+			rating.Courier = &val
+		}
+	}
+	if comment := strings.TrimSpace(r.FormValue("comment")); comment != "" {
+		rating.Comment = &comment
+	}
+	if v.Valid() {
+		data.ValidateOrderRating(v, rating)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.OrderRatingDB.Insert(rating)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تم تقييم الطلب بنجاح",
+		"rating":  rating,
+	})
+}
+
+// GetOrderRatingHandler returns the rating given to an order.
+func (app *application) GetOrderRatingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	rating, err := app.Model.OrderRatingDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rating": rating})
+}
+
+// LowRatedStoresHandler lists the stores whose orders were rated at most
+// max_rating (default 3) overall between from and to, by default over the
+// last 30 days. When to is a plain date, ratings made during that day are
+// included. Stores rated fewer than min_ratings (default 3) times in the
+// period are left out.
+func (app *application) LowRatedStoresHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := data.LowRatedStoresFilter{
+		To:         time.Now(),
+		MaxRating:  3,
+		MinRatings: 3,
+		Limit:      50,
+	}
+
+	v := validator.New()
+	if to := query.Get("to"); to != "" {
+		val, err := parseRatingTime(to, true)
+		v.Check(err == nil, "to", "التاريخ يجب أن يكون بصيغة 2006-01-02 أو RFC3339")
+		filter.To = val
+	}
+	filter.From = filter.To.AddDate(0, 0, -30)
+	if from := query.Get("from"); from != "" {
+		val, err := parseRatingTime(from, false)
+		v.Check(err == nil, "from", "التاريخ يجب أن يكون بصيغة 2006-01-02 أو RFC3339")
+		filter.From = val
+	}
+	if maxRating := query.Get("max_rating"); maxRating != "" {
+		val, err := strconv.ParseFloat(maxRating, 64)
+		v.Check(err == nil && val >= 1 && val <= 5, "max_rating", "يجب أن يكون الحد الأقصى للتقييم من 1 إلى 5")
+		filter.MaxRating = val
+	}
+	if minRatings := query.Get("min_ratings"); minRatings != "" {
+		val, err := strconv.Atoi(minRatings)
+		v.Check(err == nil && val >= 1, "min_ratings", "يجب أن يكون الحد الأدنى لعدد التقييمات عددًا صحيحًا موجبًا")
+		filter.MinRatings = val
+	}
+	if limit := query.Get("limit"); limit != "" {
+		val, err := strconv.Atoi(limit)
+		v.Check(err == nil && val >= 1 && val <= 200, "limit", "يجب أن يكون الحد من 1 إلى 200")
+		filter.Limit = val
+	}
+	v.Check(filter.From.Before(filter.To), "from", "يجب أن يكون تاريخ البداية قبل تاريخ النهاية")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	stores, err := app.Model.OrderRatingDB.LowRatedStores(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"from":   filter.From,
+		"to":     filter.To,
+		"stores": stores,
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// readProductCategoryForm copies the category fields present in the request
+// onto category. An empty parent_id moves the category to the top level.
+func readProductCategoryForm(r *http.Request, category *data.ProductCategory) error {
+	if name := r.FormValue("name"); name != "" {
+		category.Name = name
+	}
+	if sortOrder := r.FormValue("sort_order"); sortOrder != "" {
+		val, err := strconv.Atoi(sortOrder)
+		if err != nil {
+			return errors.New("الترتيب يجب أن يكون عددًا صحيحًا")
+		}
+		category.SortOrder = val
+	}
+	if _, ok := r.Form["parent_id"]; ok {
+		category.ParentID = nil
+		if parentID := r.FormValue("parent_id"); parentID != "" {
+			val, err := uuid.Parse(parentID)
+			if err != nil {
+				return errors.New("معرف التصنيف الأب غير صالح")
+			}
+			category.ParentID = &val
+		}
+	}
+	return nil
+}
+
+// categoryForOwner loads a category from the path and checks that the user
+// owns its store or is an admin.
+func (app *application) categoryForOwner(w http.ResponseWriter, r *http.Request) (*data.ProductCategory, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف التصنيف غير صالح"))
+		return nil, false
+	}
+
+	category, err := app.Model.ProductCategoryDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if _, ok := app.storeForOwner(w, r, category.StoreID); !ok {
+		return nil, false
+	}
+
+	return category, true
+}
+
+// ListStoreCategoriesHandler lists all of a store's categories in menu
+// order. Subcategories refer to their parent with parent_id.
+func (app *application) ListStoreCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	_, err = app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	categories, err := app.Model.ProductCategoryDB.ListByStore(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"categories": categories})
+}
+
+func (app *application) CreateProductCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	category := &data.ProductCategory{StoreID: storeID}
+	err = readProductCategoryForm(r, category)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateProductCategory(v, category)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductCategoryDB.Insert(category)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message":  "تم إنشاء التصنيف بنجاح",
+		"category": category,
+	})
+}
+
+func (app *application) UpdateProductCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := readProductCategoryForm(r, category)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateProductCategory(v, category)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductCategoryDB.Update(category)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":  "تم تحديث التصنيف بنجاح",
+		"category": category,
+	})
+}
+
+// DeleteProductCategoryHandler deletes a category with its subcategories.
+// Their products stay in the store, uncategorized.
+func (app *application) DeleteProductCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	category, ok := app.categoryForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.ProductCategoryDB.Delete(category.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم حذف التصنيف بنجاح"})
+}
+
+// GetStoreMenuHandler returns the store's available products grouped by
+// category, as its storefront shows them.
+func (app *application) GetStoreMenuHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	_, err = app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	menu, err := app.Model.ProductCategoryDB.Menu(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"menu": menu})
+}
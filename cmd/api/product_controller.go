@@ -14,7 +14,80 @@ import (
 	"github.com/google/uuid"
 )
 
+// readProductGrouping reads the product's category and tags from the
+// request. An empty category_id leaves the product uncategorized. tags is a
+// comma-separated list replacing the product's tags; the returned tags are
+// nil when it is not sent.
+func readProductGrouping(r *http.Request, product *data.Product) ([]string, error) {
+	if _, ok := r.Form["category_id"]; ok {
+		product.CategoryID = nil
+		if categoryID := r.FormValue("category_id"); categoryID != "" {
+			val, err := uuid.Parse(categoryID)
+			if err != nil {
+				return nil, errors.New("معرف التصنيف غير صالح")
+			}
+			product.CategoryID = &val
+		}
+	}
+
+	if _, ok := r.Form["tags"]; !ok {
+		return nil, nil
+	}
+	return data.NormalizeTags(strings.Split(r.FormValue("tags"), ",")), nil
+}
+
+// readProductSKU reads the product's SKU from the request when it is sent.
+// An empty sku removes it.
+func readProductSKU(r *http.Request, product *data.Product) {
+	if _, ok := r.Form["sku"]; ok {
+		product.SKU = nil
+		if sku := strings.TrimSpace(r.FormValue("sku")); sku != "" {
+			product.SKU = &sku
+		}
+	}
+}
+
+// readLowStockThreshold reads low_stock_threshold from the request into
+// product when it is sent.
+func readLowStockThreshold(r *http.Request, product *data.Product) error {
+	threshold := r.FormValue("low_stock_threshold")
+	if threshold == "" {
+		return nil
+	}
+	val, err := strconv.Atoi(threshold)
+	if err != nil {
+		return errors.New("حد المخزون المنخفض يجب أن يكون عددًا صحيحًا صالحًا (مثال: 5)")
+	}
+	product.LowStockThreshold = val
+	return nil
+}
+
+// checkProductGrouping validates the tags read by readProductGrouping and
+// checks that the product's category is one of its store's.
+func (app *application) checkProductGrouping(w http.ResponseWriter, r *http.Request, product *data.Product, tags []string) bool {
+	v := validator.New()
+	data.ValidateTags(v, tags)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if product.CategoryID != nil {
+		err := app.Model.ProductCategoryDB.CheckStore(*product.CategoryID, product.StoreID)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return false
+		}
+	}
+	return true
+}
+
 func (app *application) CreateProductHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
 
 	storeID, err := uuid.Parse(r.FormValue("store_id"))
 	if err != nil {
@@ -27,25 +100,25 @@ func (app *application) CreateProductHandler(w http.ResponseWriter, r *http.Requ
 		app.badRequestResponse(w, r, errors.New("يجب إدخال السعر"))
 		return
 	}
-	price, err := strconv.ParseFloat(priceStr, 64)
+	price, err := data.ParseMoney(priceStr)
 	if err != nil {
 		app.badRequestResponse(w, r, errors.New("السعر يجب أن يكون رقمًا صالحًا (مثال: 30 أو 40.50)"))
 		return
 	}
-	if price < 0 {
+	if price.IsNegative() {
 		app.badRequestResponse(w, r, errors.New("السعر يجب أن يكون غير سالب"))
 		return
 	}
 
 	discountStr := r.FormValue("discount")
-	discount := 0.0
+	discount := data.NewMoney(0)
 	if discountStr != "" {
-		discount, err = strconv.ParseFloat(discountStr, 64)
+		discount, err = data.ParseMoney(discountStr)
 		if err != nil {
 			app.badRequestResponse(w, r, errors.New("الخصم يجب أن يكون رقمًا صالحًا (مثال: 0 أو 5.50)"))
 			return
 		}
-		if discount < 0 {
+		if discount.IsNegative() {
 			app.badRequestResponse(w, r, errors.New("الخصم يجب أن يكون غير سالب"))
 			return
 		}
@@ -71,13 +144,29 @@ func (app *application) CreateProductHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	product := &data.Product{
-		StoreID:       storeID,
-		Name:          r.FormValue("name"),
-		Description:   utils.StringPointer(r.FormValue("description")),
-		Price:         price,
-		Discount:      discount,
-		StockQuantity: stockQuantity,
-		IsAvailable:   isAvailable,
+		StoreID:           storeID,
+		Name:              r.FormValue("name"),
+		Description:       utils.StringPointer(r.FormValue("description")),
+		Price:             price,
+		Discount:          discount,
+		StockQuantity:     stockQuantity,
+		LowStockThreshold: data.DefaultLowStockThreshold,
+		IsAvailable:       isAvailable,
+	}
+	readProductSKU(r, product)
+	err = readLowStockThreshold(r, product)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tags, err := readProductGrouping(r, product)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.checkProductGrouping(w, r, product, tags) {
+		return
 	}
 
 	if file, fileHeader, err := r.FormFile("image"); err == nil {
@@ -97,14 +186,14 @@ func (app *application) CreateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.Model.ProductDB.Insert(product)
+	err = app.Model.ProductDB.Insert(product, tags, userID)
 	if err != nil {
 		if product.Image != nil {
 			if err := utils.DeleteFile(*product.Image); err != nil {
 				log.Printf("Failed to delete image %s: %v", *product.Image, err)
 			}
 		}
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
@@ -128,9 +217,28 @@ func (app *application) GetProductHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"product": product})
+	groups, err := app.Model.ProductOptionDB.ListByProduct(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"product":       product,
+		"option_groups": groups,
+	})
 }
+
+// UpdateProductHandler updates a product. Sending stock_quantity sets the
+// stock by hand, recorded in the product's inventory as an adjustment with
+// the optional stock_note.
 func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+
 	idStr := r.PathValue("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
@@ -157,29 +265,30 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.Description = &description
 	}
 	if price := r.FormValue("price"); price != "" {
-		val, err := strconv.ParseFloat(price, 64)
+		val, err := data.ParseMoney(price)
 		if err != nil {
 			app.badRequestResponse(w, r, errors.New("السعر يجب أن يكون رقمًا صالحًا (مثال: 30 أو 40.50)"))
 			return
 		}
-		if val < 0 {
+		if val.IsNegative() {
 			app.badRequestResponse(w, r, errors.New("السعر يجب أن يكون غير سالب"))
 			return
 		}
 		product.Price = val
 	}
 	if discount := r.FormValue("discount"); discount != "" {
-		val, err := strconv.ParseFloat(discount, 64)
+		val, err := data.ParseMoney(discount)
 		if err != nil {
 			app.badRequestResponse(w, r, errors.New("الخصم يجب أن يكون رقمًا صالحًا (مثال: 0 أو 5.50)"))
 			return
 		}
-		if val < 0 {
+		if val.IsNegative() {
 			app.badRequestResponse(w, r, errors.New("الخصم يجب أن يكون غير سالب"))
 			return
 		}
 		product.Discount = val
 	}
+	var stockChange *data.StockChange
 	if stock := r.FormValue("stock_quantity"); stock != "" {
 		val, err := strconv.Atoi(stock)
 		if err != nil {
@@ -191,6 +300,16 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 		product.StockQuantity = val
+		stockChange = &data.StockChange{Quantity: val, ActorID: userID}
+		if note := r.FormValue("stock_note"); note != "" {
+			stockChange.Note = &note
+		}
+	}
+	readProductSKU(r, product)
+	err = readLowStockThreshold(r, product)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 	if available := r.FormValue("is_available"); available != "" {
 		product.IsAvailable = available == "true"
@@ -198,6 +317,15 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 		product.IsAvailable = true
 	}
 
+	tags, err := readProductGrouping(r, product)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !app.checkProductGrouping(w, r, product, tags) {
+		return
+	}
+
 	var newImageName string
 	removeImage := r.FormValue("remove_image") == "true"
 	file, fileHeader, err := r.FormFile("image")
@@ -238,7 +366,7 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = app.Model.ProductDB.Update(product)
+	err = app.Model.ProductDB.Update(product, tags, stockChange)
 	if err != nil {
 		if newImageName != "" {
 			if err := utils.DeleteFile(newImageName); err != nil {
@@ -246,7 +374,7 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 			}
 		}
 		product.Image = oldImage
-		app.serverErrorResponse(w, r, err)
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
@@ -255,10 +383,27 @@ func (app *application) UpdateProductHandler(w http.ResponseWriter, r *http.Requ
 		"product": product,
 	})
 }
+
+// ListProductsHandler lists products. category_id limits the list to a
+// category and its subcategories, and tags to products having every one of
+// the comma-separated tags.
 func (app *application) ListProductsHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 
-	products, meta, err := app.Model.ProductDB.List(queryParams)
+	var filter data.ProductFilter
+	if value := queryParams.Get("category_id"); value != "" {
+		categoryID, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف التصنيف غير صالح"))
+			return
+		}
+		filter.CategoryID = &categoryID
+	}
+	if value := queryParams.Get("tags"); value != "" {
+		filter.Tags = data.NormalizeTags(strings.Split(value, ","))
+	}
+
+	products, meta, err := app.Model.ProductDB.List(queryParams, filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
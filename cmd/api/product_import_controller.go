@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"sort"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// ImportProductsHandler creates the store's products from the CSV file sent
+// as file. Valid rows are written and the others reported by line. With
+// upsert=true rows whose SKU the store already uses update that product;
+// with dry_run=true nothing is written.
+func (app *application) ImportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	opts := data.ProductImportOptions{ActorID: userID}
+	for _, flag := range []struct {
+		name string
+		dest *bool
+	}{
+		{"upsert", &opts.Upsert},
+		{"dry_run", &opts.DryRun},
+	} {
+		value := r.FormValue(flag.name)
+		if value == "" {
+			continue
+		}
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("قيمة %s غير صالحة", flag.name))
+			return
+		}
+		*flag.dest = val
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("يجب إرفاق ملف CSV"))
+		return
+	}
+	defer file.Close()
+
+	rows, rowErrors, err := data.ReadProductCSV(file, storeID)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	result, err := app.Model.ProductDB.Import(storeID, rows, opts)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	result.Errors = append(result.Errors, rowErrors...)
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Line < result.Errors[j].Line
+	})
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"import": result})
+}
+
+// ExportProductsHandler streams the store's products as a CSV file in the
+// format ImportProductsHandler reads.
+func (app *application) ExportProductsHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.csv"`, storeID))
+
+	// The response has started by the time rows are read, so a failure
+	// can only cut the file short.
+	err = app.Model.ProductDB.ExportCSV(storeID, w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
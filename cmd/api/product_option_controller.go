@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// readOptionGroupForm copies the group fields present in the request onto
+// group.
+func readOptionGroupForm(r *http.Request, group *data.ProductOptionGroup) error {
+	if name := r.FormValue("name"); name != "" {
+		group.Name = name
+	}
+	if selectionType := r.FormValue("selection_type"); selectionType != "" {
+		group.SelectionType = selectionType
+	}
+	if isRequired := r.FormValue("is_required"); isRequired != "" {
+		val, err := strconv.ParseBool(isRequired)
+		if err != nil {
+			return errors.New("قيمة is_required غير صالحة")
+		}
+		group.IsRequired = val
+	}
+	intFields := []struct {
+		name    string
+		dest    *int
+		message string
+	}{
+		{"min_select", &group.MinSelect, "الحد الأدنى للاختيارات يجب أن يكون عددًا صحيحًا"},
+		{"max_select", &group.MaxSelect, "الحد الأقصى للاختيارات يجب أن يكون عددًا صحيحًا"},
+		{"sort_order", &group.SortOrder, "الترتيب يجب أن يكون عددًا صحيحًا"},
+	}
+	for _, field := range intFields {
+		value := r.FormValue(field.name)
+		if value == "" {
+			continue
+		}
+		val, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New(field.message)
+		}
+		*field.dest = val
+	}
+	return nil
+}
+
+// readProductOptionForm copies the option fields present in the request onto
+// option. An empty stock_quantity stops tracking the option's stock.
+func readProductOptionForm(r *http.Request, option *data.ProductOption) error {
+	if name := r.FormValue("name"); name != "" {
+		option.Name = name
+	}
+	if priceDelta := r.FormValue("price_delta"); priceDelta != "" {
+		val, err := data.ParseMoney(priceDelta)
+		if err != nil {
+			return errors.New("فرق السعر يجب أن يكون رقمًا صالحًا (مثال: 2 أو 1.50)")
+		}
+		option.PriceDelta = val
+	}
+	if _, ok := r.Form["stock_quantity"]; ok {
+		option.StockQuantity = nil
+		if stock := r.FormValue("stock_quantity"); stock != "" {
+			val, err := strconv.Atoi(stock)
+			if err != nil {
+				return errors.New("كمية المخزون يجب أن تكون عددًا صحيحًا صالحًا (مثال: 0 أو 100)")
+			}
+			option.StockQuantity = &val
+		}
+	}
+	if isAvailable := r.FormValue("is_available"); isAvailable != "" {
+		val, err := strconv.ParseBool(isAvailable)
+		if err != nil {
+			return errors.New("قيمة is_available غير صالحة")
+		}
+		option.IsAvailable = val
+	}
+	if sortOrder := r.FormValue("sort_order"); sortOrder != "" {
+		val, err := strconv.Atoi(sortOrder)
+		if err != nil {
+			return errors.New("الترتيب يجب أن يكون عددًا صحيحًا")
+		}
+		option.SortOrder = val
+	}
+	return nil
+}
+
+// productForOwner loads a product and checks that the user owns its store or
+// is an admin.
+func (app *application) productForOwner(w http.ResponseWriter, r *http.Request, productID uuid.UUID) (*data.Product, bool) {
+	product, err := app.Model.ProductDB.Get(productID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if _, ok := app.storeForOwner(w, r, product.StoreID); !ok {
+		return nil, false
+	}
+	return product, true
+}
+
+// optionGroupForOwner loads a group from the path and checks that the user
+// owns its product's store or is an admin.
+func (app *application) optionGroupForOwner(w http.ResponseWriter, r *http.Request) (*data.ProductOptionGroup, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف مجموعة الخيارات غير صالح"))
+		return nil, false
+	}
+
+	group, err := app.Model.ProductOptionDB.GetGroup(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if _, ok := app.productForOwner(w, r, group.ProductID); !ok {
+		return nil, false
+	}
+
+	return group, true
+}
+
+// productOptionForOwner loads an option from the path and checks that the
+// user owns its product's store or is an admin.
+func (app *application) productOptionForOwner(w http.ResponseWriter, r *http.Request) (*data.ProductOption, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الخيار غير صالح"))
+		return nil, false
+	}
+
+	option, err := app.Model.ProductOptionDB.GetOption(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	group, err := app.Model.ProductOptionDB.GetGroup(option.GroupID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if _, ok := app.productForOwner(w, r, group.ProductID); !ok {
+		return nil, false
+	}
+
+	return option, true
+}
+
+// ListProductOptionsHandler lists a product's option groups with their
+// options.
+func (app *application) ListProductOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنتج غير صالح"))
+		return
+	}
+
+	_, err = app.Model.ProductDB.Get(productID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	groups, err := app.Model.ProductOptionDB.ListByProduct(productID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"option_groups": groups})
+}
+
+func (app *application) CreateOptionGroupHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنتج غير صالح"))
+		return
+	}
+	if _, ok := app.productForOwner(w, r, productID); !ok {
+		return
+	}
+
+	group := &data.ProductOptionGroup{
+		ProductID:     productID,
+		SelectionType: data.OptionSelectSingle,
+		MaxSelect:     1,
+	}
+	err = readOptionGroupForm(r, group)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateOptionGroup(v, group)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductOptionDB.InsertGroup(group)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message":      "تم إنشاء مجموعة الخيارات بنجاح",
+		"option_group": group,
+	})
+}
+
+func (app *application) UpdateOptionGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.optionGroupForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := readOptionGroupForm(r, group)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateOptionGroup(v, group)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductOptionDB.UpdateGroup(group)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":      "تم تحديث مجموعة الخيارات بنجاح",
+		"option_group": group,
+	})
+}
+
+func (app *application) DeleteOptionGroupHandler(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.optionGroupForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.ProductOptionDB.DeleteGroup(group.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم حذف مجموعة الخيارات بنجاح"})
+}
+
+func (app *application) CreateProductOptionHandler(w http.ResponseWriter, r *http.Request) {
+	group, ok := app.optionGroupForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	option := &data.ProductOption{
+		GroupID:     group.ID,
+		PriceDelta:  data.NewMoney(0),
+		IsAvailable: true,
+	}
+	err := readProductOptionForm(r, option)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateProductOption(v, option)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductOptionDB.InsertOption(option)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تم إنشاء الخيار بنجاح",
+		"option":  option,
+	})
+}
+
+func (app *application) UpdateProductOptionHandler(w http.ResponseWriter, r *http.Request) {
+	option, ok := app.productOptionForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := readProductOptionForm(r, option)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateProductOption(v, option)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.ProductOptionDB.UpdateOption(option)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث الخيار بنجاح",
+		"option":  option,
+	})
+}
+
+func (app *application) DeleteProductOptionHandler(w http.ResponseWriter, r *http.Request) {
+	option, ok := app.productOptionForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.ProductOptionDB.DeleteOption(option.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم حذف الخيار بنجاح"})
+}
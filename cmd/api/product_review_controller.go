@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// readReviewForm copies the review fields present in the request onto
+// review. An empty body removes the review's text.
+func readReviewForm(r *http.Request, review *data.ProductReview) error {
+	if rating := r.FormValue("rating"); rating != "" {
+		val, err := strconv.Atoi(rating)
+		if err != nil {
+			return errors.New("التقييم يجب أن يكون عددًا صحيحًا من 1 إلى 5")
+		}
+		review.Rating = val
+	}
+	if _, ok := r.Form["body"]; ok {
+		review.Body = nil
+		if body := strings.TrimSpace(r.FormValue("body")); body != "" {
+			review.Body = &body
+		}
+	}
+	return nil
+}
+
+// reviewFromPath loads the review named in the path.
+func (app *application) reviewFromPath(w http.ResponseWriter, r *http.Request) (*data.ProductReview, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف التقييم غير صالح"))
+		return nil, false
+	}
+
+	review, err := app.Model.ProductReviewDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	return review, true
+}
+
+// reviewForAuthor loads the review named in the path and checks that the
+// user wrote it, or is an admin when allowAdmin is set.
+func (app *application) reviewForAuthor(w http.ResponseWriter, r *http.Request, allowAdmin bool) (*data.ProductReview, bool) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return nil, false
+	}
+
+	review, ok := app.reviewFromPath(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	if review.UserID != userID && !(allowAdmin && hasRole(userRoles, "admin")) {
+		app.forbiddenResponse(w, r)
+		return nil, false
+	}
+	return review, true
+}
+
+// ListProductReviewsHandler lists a product's reviews, newest first.
+func (app *application) ListProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنتج غير صالح"))
+		return
+	}
+
+	product, err := app.Model.ProductDB.Get(productID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	reviews, meta, err := app.Model.ProductReviewDB.ListByProduct(productID, r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"rating_avg":   product.RatingAvg,
+		"rating_count": product.RatingCount,
+		"reviews":      reviews,
+		"meta":         meta,
+	})
+}
+
+// ListStoreReviewsHandler lists the reviews of all of a store's products,
+// newest first.
+func (app *application) ListStoreReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	store, err := app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	reviews, meta, err := app.Model.ProductReviewDB.ListByStore(storeID, r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"rating_avg":   store.RatingAvg,
+		"rating_count": store.RatingCount,
+		"reviews":      reviews,
+		"meta":         meta,
+	})
+}
+
+// CreateProductReviewHandler adds the user's review of a product from one
+// of their delivered orders. order_item_id picks the order item reviewed;
+// without it the latest one not reviewed yet is used.
+func (app *application) CreateProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	productID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المنتج غير صالح"))
+		return
+	}
+
+	_, err = app.Model.ProductDB.Get(productID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	review := &data.ProductReview{ProductID: productID, UserID: userID}
+	if orderItemID := r.FormValue("order_item_id"); orderItemID != "" {
+		review.OrderItemID, err = uuid.Parse(orderItemID)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("معرف عنصر الطلب غير صالح"))
+			return
+		}
+	}
+	err = readReviewForm(r, review)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateReview(v, review)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if file, fileHeader, err := r.FormFile("image"); err == nil {
+		defer file.Close()
+		imageName, err := utils.SaveFile(file, "reviews", fileHeader.Filename)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "صورة غير صالحة")
+			return
+		}
+		review.Image = &imageName
+	}
+
+	err = app.Model.ProductReviewDB.Insert(review)
+	if err != nil {
+		if review.Image != nil {
+			if err := utils.DeleteFile(*review.Image); err != nil {
+				log.Printf("Failed to delete image %s: %v", *review.Image, err)
+			}
+		}
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	review, err = app.Model.ProductReviewDB.Get(review.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تم إضافة التقييم بنجاح",
+		"review":  review,
+	})
+}
+
+// UpdateProductReviewHandler lets the author change their review. A new
+// image replaces the photo and remove_image=true removes it.
+func (app *application) UpdateProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewForAuthor(w, r, false)
+	if !ok {
+		return
+	}
+
+	oldImage := review.Image
+	if oldImage != nil {
+		*oldImage = strings.TrimPrefix(*oldImage, data.Domain+"/")
+	}
+
+	err := readReviewForm(r, review)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateReview(v, review)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var newImageName string
+	file, fileHeader, err := r.FormFile("image")
+	if err == nil {
+		defer file.Close()
+		newImageName, err = utils.SaveFile(file, "reviews", fileHeader.Filename)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "صورة غير صالحة")
+			return
+		}
+		review.Image = &newImageName
+	} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+		app.errorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("خطأ في معالجة ملف الصورة: %v", err))
+		return
+	} else if r.FormValue("remove_image") == "true" {
+		review.Image = nil
+	}
+
+	err = app.Model.ProductReviewDB.Update(review)
+	if err != nil {
+		if newImageName != "" {
+			if err := utils.DeleteFile(newImageName); err != nil {
+				log.Printf("Failed to delete new image %s: %v", newImageName, err)
+			}
+		}
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if oldImage != nil && (review.Image == nil || *review.Image != *oldImage) {
+		if err := utils.DeleteFile(*oldImage); err != nil {
+			log.Printf("Failed to delete old image %s: %v", *oldImage, err)
+		}
+	}
+
+	review, err = app.Model.ProductReviewDB.Get(review.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث التقييم بنجاح",
+		"review":  review,
+	})
+}
+
+// DeleteProductReviewHandler deletes a review, by its author or an admin.
+func (app *application) DeleteProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewForAuthor(w, r, true)
+	if !ok {
+		return
+	}
+
+	err := app.Model.ProductReviewDB.Delete(review)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if review.Image != nil {
+		image := strings.TrimPrefix(*review.Image, data.Domain+"/")
+		if err := utils.DeleteFile(image); err != nil {
+			log.Printf("Failed to delete image %s for review %s: %v", image, review.ID, err)
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم حذف التقييم بنجاح"})
+}
+
+// ReplyProductReviewHandler sets the store owner's reply to a review of one
+// of their products, replacing any earlier reply.
+func (app *application) ReplyProductReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.reviewFromPath(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, review.StoreID); !ok {
+		return
+	}
+
+	reply := strings.TrimSpace(r.FormValue("reply"))
+	v := validator.New()
+	data.ValidateReviewReply(v, reply)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.Model.ProductReviewDB.Reply(review, reply)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم إضافة الرد بنجاح",
+		"review":  review,
+	})
+}
@@ -59,6 +59,42 @@ func (app *application) Router() *michi.Router {
 		sub.HandleFunc("PUT stores/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateStoreHandler))))
 		sub.HandleFunc("DELETE stores/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteStoreHandler))))
 		sub.HandleFunc("GET stores", (http.HandlerFunc(app.ListStoresHandler)))
+		sub.HandleFunc("GET stores/low-rated", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.LowRatedStoresHandler))))
+
+		// Delivery settings endpoints
+		sub.HandleFunc("GET stores/{id}/delivery-settings", http.HandlerFunc(app.GetStoreDeliverySettingsHandler))
+		sub.HandleFunc("PUT stores/{id}/delivery-settings", app.AuthMiddleware(http.HandlerFunc(app.UpdateStoreDeliverySettingsHandler)))
+		sub.HandleFunc("GET stores/{id}/delivery-zones", http.HandlerFunc(app.ListStoreDeliveryZonesHandler))
+		sub.HandleFunc("PUT stores/{id}/delivery-zones", app.AuthMiddleware(http.HandlerFunc(app.SetStoreDeliveryZonesHandler)))
+
+		// Opening hours endpoints
+		sub.HandleFunc("GET stores/{id}/hours", http.HandlerFunc(app.GetStoreHoursHandler))
+		sub.HandleFunc("PUT stores/{id}/hours", app.AuthMiddleware(http.HandlerFunc(app.UpdateStoreHoursHandler)))
+		sub.HandleFunc("POST stores/{id}/holidays", app.AuthMiddleware(http.HandlerFunc(app.CreateStoreHolidayHandler)))
+		sub.HandleFunc("DELETE stores/{id}/holidays/{holiday_id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteStoreHolidayHandler)))
+		sub.HandleFunc("POST stores/{id}/pause", app.AuthMiddleware(http.HandlerFunc(app.PauseStoreHandler)))
+		sub.HandleFunc("DELETE stores/{id}/pause", app.AuthMiddleware(http.HandlerFunc(app.ResumeStoreHandler)))
+
+		// Delivery slot endpoints
+		sub.HandleFunc("GET stores/{id}/delivery-slots", http.HandlerFunc(app.ListStoreDeliverySlotsHandler))
+		sub.HandleFunc("POST stores/{id}/delivery-slots", app.AuthMiddleware(http.HandlerFunc(app.CreateDeliverySlotHandler)))
+		sub.HandleFunc("PUT delivery-slots/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateDeliverySlotHandler)))
+		sub.HandleFunc("DELETE delivery-slots/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteDeliverySlotHandler)))
+
+		// Delivery zone endpoints
+		sub.HandleFunc("POST delivery-zones", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateDeliveryZoneHandler))))
+		sub.HandleFunc("GET delivery-zones/{id}", http.HandlerFunc(app.GetDeliveryZoneHandler))
+		sub.HandleFunc("PUT delivery-zones/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateDeliveryZoneHandler))))
+		sub.HandleFunc("DELETE delivery-zones/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteDeliveryZoneHandler))))
+		sub.HandleFunc("GET delivery-zones", http.HandlerFunc(app.ListDeliveryZonesHandler))
+
+		// Webhook endpoints
+		sub.HandleFunc("POST stores/{id}/webhooks", app.AuthMiddleware(http.HandlerFunc(app.CreateWebhookHandler)))
+		sub.HandleFunc("GET stores/{id}/webhooks", app.AuthMiddleware(http.HandlerFunc(app.ListWebhooksHandler)))
+		sub.HandleFunc("PUT webhooks/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateWebhookHandler)))
+		sub.HandleFunc("DELETE webhooks/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteWebhookHandler)))
+		sub.HandleFunc("GET webhooks/{id}/deliveries", app.AuthMiddleware(http.HandlerFunc(app.ListWebhookDeliveriesHandler)))
+		sub.HandleFunc("POST webhook-deliveries/{id}/redeliver", app.AuthMiddleware(http.HandlerFunc(app.RedeliverWebhookHandler)))
 
 		// StoreType endpoints
 		sub.HandleFunc("POST store-types", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateStoreTypeHandler))))
@@ -73,26 +109,88 @@ func (app *application) Router() *michi.Router {
 		sub.HandleFunc("PUT products/{id}", app.AuthMiddleware(app.StoreOwnerOnlyMiddleware(http.HandlerFunc(app.UpdateProductHandler))))
 		sub.HandleFunc("DELETE products/{id}", app.AuthMiddleware(app.StoreOwnerOnlyMiddleware(http.HandlerFunc(app.DeleteProductHandler))))
 		sub.HandleFunc("GET products", app.AuthMiddleware(http.HandlerFunc(app.ListProductsHandler)))
+		sub.HandleFunc("GET products/{id}/inventory", app.AuthMiddleware(http.HandlerFunc(app.ProductInventoryHandler)))
+		sub.HandleFunc("POST stores/{id}/products/import", app.AuthMiddleware(http.HandlerFunc(app.ImportProductsHandler)))
+		sub.HandleFunc("GET stores/{id}/products/export", app.AuthMiddleware(http.HandlerFunc(app.ExportProductsHandler)))
+
+		// Category endpoints
+		sub.HandleFunc("GET stores/{id}/categories", http.HandlerFunc(app.ListStoreCategoriesHandler))
+		sub.HandleFunc("POST stores/{id}/categories", app.AuthMiddleware(http.HandlerFunc(app.CreateProductCategoryHandler)))
+		sub.HandleFunc("PUT categories/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateProductCategoryHandler)))
+		sub.HandleFunc("DELETE categories/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteProductCategoryHandler)))
+		sub.HandleFunc("GET stores/{id}/menu", http.HandlerFunc(app.GetStoreMenuHandler))
+
+		// Product option endpoints
+		sub.HandleFunc("GET products/{id}/options", http.HandlerFunc(app.ListProductOptionsHandler))
+		sub.HandleFunc("POST products/{id}/option-groups", app.AuthMiddleware(http.HandlerFunc(app.CreateOptionGroupHandler)))
+		sub.HandleFunc("PUT option-groups/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateOptionGroupHandler)))
+		sub.HandleFunc("DELETE option-groups/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteOptionGroupHandler)))
+		sub.HandleFunc("POST option-groups/{id}/options", app.AuthMiddleware(http.HandlerFunc(app.CreateProductOptionHandler)))
+		sub.HandleFunc("PUT product-options/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateProductOptionHandler)))
+		sub.HandleFunc("DELETE product-options/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteProductOptionHandler)))
+
+		// Review endpoints
+		sub.HandleFunc("GET products/{id}/reviews", http.HandlerFunc(app.ListProductReviewsHandler))
+		sub.HandleFunc("POST products/{id}/reviews", app.AuthMiddleware(http.HandlerFunc(app.CreateProductReviewHandler)))
+		sub.HandleFunc("GET stores/{id}/reviews", http.HandlerFunc(app.ListStoreReviewsHandler))
+		sub.HandleFunc("PUT reviews/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateProductReviewHandler)))
+		sub.HandleFunc("DELETE reviews/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteProductReviewHandler)))
+		sub.HandleFunc("POST reviews/{id}/reply", app.AuthMiddleware(http.HandlerFunc(app.ReplyProductReviewHandler)))
+
+		// Search endpoints
+		sub.HandleFunc("GET search/suggest", http.HandlerFunc(app.SearchSuggestHandler))
 
 		// Cart endpoints
 		sub.HandleFunc("POST carts", app.AuthMiddleware(http.HandlerFunc(app.CreateCartHandler)))
 		sub.HandleFunc("GET carts/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.GetCartHandler))))
 		sub.HandleFunc("GET usercarts", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.GetUserCartHandler))))
 		sub.HandleFunc("DELETE carts/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.DeleteCartHandler))))
+		sub.HandleFunc("GET carts/{id}/quote", app.AuthMiddleware(http.HandlerFunc(app.QuoteCartHandler)))
+		sub.HandleFunc("POST carts/{id}/coupon", app.AuthMiddleware(http.HandlerFunc(app.ApplyCartCouponHandler)))
+		sub.HandleFunc("DELETE carts/{id}/coupon", app.AuthMiddleware(http.HandlerFunc(app.RemoveCartCouponHandler)))
+		sub.HandleFunc("POST carts/{id}/delivery-slot", app.AuthMiddleware(http.HandlerFunc(app.HoldCartDeliverySlotHandler)))
+		sub.HandleFunc("DELETE carts/{id}/delivery-slot", app.AuthMiddleware(http.HandlerFunc(app.ReleaseCartDeliverySlotHandler)))
+
+		// Coupon endpoints
+		sub.HandleFunc("POST coupons", app.AuthMiddleware(http.HandlerFunc(app.CreateCouponHandler)))
+		sub.HandleFunc("GET coupons/{id}", app.AuthMiddleware(http.HandlerFunc(app.GetCouponHandler)))
+		sub.HandleFunc("PUT coupons/{id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateCouponHandler)))
+		sub.HandleFunc("DELETE coupons/{id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteCouponHandler)))
+		sub.HandleFunc("GET coupons", app.AuthMiddleware(http.HandlerFunc(app.ListCouponsHandler)))
 
 		// CartItem endpoints
-		sub.HandleFunc("POST cart-items", app.AuthMiddleware(http.HandlerFunc(app.AddCartItemHandler)))
+		sub.HandleFunc("POST cart-items", app.AuthMiddleware(app.IdempotencyMiddleware(http.HandlerFunc(app.AddCartItemHandler))))
 		sub.HandleFunc("PUT cart-items/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.UpdateCartItemHandler))))
 		sub.HandleFunc("DELETE cart-items/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.DeleteCartItemHandler))))
 
 		// Order endpoints
-		sub.HandleFunc("POST orders", app.AuthMiddleware(http.HandlerFunc(app.CreateOrderFromCartHandler)))
+		sub.HandleFunc("POST orders", app.AuthMiddleware(app.IdempotencyMiddleware(http.HandlerFunc(app.CreateOrderFromCartHandler))))
 		sub.HandleFunc("GET orders/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.GetOrderHandler))))
-		sub.HandleFunc("PUT orders/{id}", app.AuthMiddleware(app.StoreOwnerOnlyMiddleware(http.HandlerFunc(app.UpdateOrderHandler))))
+		sub.HandleFunc("PUT orders/{id}", app.AuthMiddleware(app.OrderAccessMiddleware(http.HandlerFunc(app.UpdateOrderHandler))))
+		sub.HandleFunc("POST orders/{id}/cancel", app.AuthMiddleware(app.OrderAccessMiddleware(http.HandlerFunc(app.CancelOrderHandler))))
+		sub.HandleFunc("GET orders/{id}/history", app.AuthMiddleware(app.OrderAccessMiddleware(http.HandlerFunc(app.GetOrderHistoryHandler))))
+		sub.HandleFunc("POST orders/{id}/rating", app.AuthMiddleware(http.HandlerFunc(app.RateOrderHandler)))
+		sub.HandleFunc("GET orders/{id}/rating", app.AuthMiddleware(app.OrderAccessMiddleware(http.HandlerFunc(app.GetOrderRatingHandler))))
+		sub.HandleFunc("GET ws/orders/{id}", app.AuthMiddleware(http.HandlerFunc(app.OrderTrackingHandler)))
+		sub.HandleFunc("POST orders/{id}/assign", app.AuthMiddleware(app.OrderAccessMiddleware(http.HandlerFunc(app.AssignOrderHandler))))
 		sub.HandleFunc("DELETE orders/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.DeleteOrderHandler))))
 		sub.HandleFunc("GET orders", app.AuthMiddleware(http.HandlerFunc(app.ListOrdersHandler)))
+		sub.HandleFunc("GET storeorders/stream", app.AuthMiddleware(http.HandlerFunc(app.StoreOrdersStreamHandler)))
 		sub.HandleFunc("GET storeorders/{store_id}", app.AuthMiddleware(http.HandlerFunc(app.ListStoreOrdersHandler)))
 
+		// Driver endpoints
+		sub.HandleFunc("POST drivers", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.UpsertDriverHandler))))
+		sub.HandleFunc("GET drivers/me", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.GetDriverHandler))))
+		sub.HandleFunc("POST drivers/online", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.DriverOnlineHandler))))
+		sub.HandleFunc("POST drivers/offline", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.DriverOfflineHandler))))
+		sub.HandleFunc("PUT drivers/location", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.UpdateDriverLocationHandler))))
+		sub.HandleFunc("GET drivers/offers", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.ListDriverOffersHandler))))
+		sub.HandleFunc("GET drivers/assignments", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.ListDriverAssignmentsHandler))))
+		sub.HandleFunc("POST assignments/{id}/accept", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.AcceptAssignmentHandler))))
+		sub.HandleFunc("POST assignments/{id}/reject", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.RejectAssignmentHandler))))
+		sub.HandleFunc("POST assignments/{id}/pickup", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.PickUpAssignmentHandler))))
+		sub.HandleFunc("POST assignments/{id}/deliver", app.AuthMiddleware(app.DriverOnlyMiddleware(http.HandlerFunc(app.DeliverAssignmentHandler))))
+
 		// OrderItem endpoints
 		sub.HandleFunc("GET order-items/{id}", app.AuthMiddleware(app.AdminOrSelfMiddleware(http.HandlerFunc(app.GetOrderItemHandler))))
 		sub.HandleFunc("GET order-items", app.AuthMiddleware(http.HandlerFunc(app.ListOrderItemsHandler)))
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"project/utils"
+)
+
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+)
+
+// SearchSuggestHandler suggests products and stores as the user types q,
+// matching words that start with each term.
+func (app *application) SearchSuggestHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	limit := defaultSuggestLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		val, err := strconv.Atoi(value)
+		if err != nil || val < 1 || val > maxSuggestLimit {
+			app.badRequestResponse(w, r, errors.New("عدد الاقتراحات يجب أن يكون بين 1 و 20"))
+			return
+		}
+		limit = val
+	}
+
+	suggestions, err := app.Model.SearchDB.Suggest(q, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"suggestions": suggestions})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"project/internal/data"
+)
+
+// SlotReleaser periodically frees the delivery slot places that carts held
+// but never turned into orders.
+type SlotReleaser struct {
+	slots    *data.DeliverySlotDB
+	log      *log.Logger
+	interval time.Duration
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewSlotReleaser(model *data.Model, interval time.Duration, logger *log.Logger) *SlotReleaser {
+	return &SlotReleaser{
+		slots:    &model.DeliverySlotDB,
+		log:      logger,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+}
+
+// Start runs the release loop in the background until Stop is called.
+func (s *SlotReleaser) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.runOnce()
+
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the loop and waits for the current pass to finish.
+func (s *SlotReleaser) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+func (s *SlotReleaser) runOnce() {
+	released, err := s.slots.ReleaseExpired(time.Now())
+	if err != nil {
+		s.log.Printf("slot releaser: %v", err)
+		return
+	}
+	if released > 0 {
+		s.log.Printf("slot releaser: %d expired hold(s) released", released)
+	}
+}
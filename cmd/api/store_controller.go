@@ -10,10 +10,40 @@ import (
 	"project/utils/validator"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	defaultStoreSearchRadiusKm = 10
+	maxStoreSearchRadiusKm     = 200
+)
+
+// storeForOwner loads a store and checks that the authenticated user owns it
+// or is an admin. It writes the error response itself when they do not.
+func (app *application) storeForOwner(w http.ResponseWriter, r *http.Request, storeID uuid.UUID) (*data.Store, bool) {
+	userID, err := uuid.Parse(fmt.Sprint(r.Context().Value(UserIDKey)))
+	if err != nil {
+		app.unauthorizedResponse(w, r)
+		return nil, false
+	}
+
+	store, err := app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	if store.OwnerID != userID && !hasRole(userRoles, "admin") {
+		app.forbiddenResponse(w, r)
+		return nil, false
+	}
+
+	return store, true
+}
+
 // Store Handlers
 func (app *application) CreateStoreHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -106,7 +136,14 @@ func (app *application) GetStoreHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"store": store})
+	stores := []data.Store{*store}
+	err = app.Model.StoreDB.FillOpenStatus(stores, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"store": stores[0]})
 }
 func (app *application) UpdateStoreHandler(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
@@ -273,10 +310,67 @@ func (app *application) DeleteStoreHandler(w http.ResponseWriter, r *http.Reques
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "تم حذف المتجر بنجاح"})
 }
 
+// ListStoresHandler lists stores with whether each is open now.
+// delivers_to=latitude,longitude limits the list to stores that deliver to
+// that point. lat and lng list the stores within radius_km of a point, by
+// default nearest first, each with its distance_km.
+// q searches store names, descriptions and addresses, best matches first.
 func (app *application) ListStoresHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 
-	stores, meta, err := app.Model.StoreDB.ListStores(queryParams)
+	var filter data.StoreFilter
+	if value := queryParams.Get("delivers_to"); value != "" {
+		latStr, lngStr, _ := strings.Cut(value, ",")
+		latitude, errLat := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+		longitude, errLng := strconv.ParseFloat(strings.TrimSpace(lngStr), 64)
+		if errLat != nil || errLng != nil {
+			app.badRequestResponse(w, r, errors.New("قيمة delivers_to يجب أن تكون بصيغة خط العرض,خط الطول"))
+			return
+		}
+		v := validator.New()
+		data.ValidateLocation(v, latitude, longitude)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		filter.DeliversTo = &data.DeliveryPoint{Latitude: latitude, Longitude: longitude}
+	}
+
+	if queryParams.Get("lat") != "" || queryParams.Get("lng") != "" {
+		latitude, errLat := strconv.ParseFloat(queryParams.Get("lat"), 64)
+		longitude, errLng := strconv.ParseFloat(queryParams.Get("lng"), 64)
+		if errLat != nil || errLng != nil {
+			app.badRequestResponse(w, r, errors.New("يجب إدخال خط العرض وخط الطول كأرقام صالحة"))
+			return
+		}
+		radiusKm := float64(defaultStoreSearchRadiusKm)
+		if value := queryParams.Get("radius_km"); value != "" {
+			val, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New("نطاق البحث يجب أن يكون رقمًا صالحًا"))
+				return
+			}
+			radiusKm = val
+		}
+
+		v := validator.New()
+		data.ValidateLocation(v, latitude, longitude)
+		v.Check(radiusKm > 0 && radiusKm <= maxStoreSearchRadiusKm, "radius_km", "نطاق البحث يجب أن يكون بين 0 و 200 كم")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		filter.Near = &data.DeliveryPoint{Latitude: latitude, Longitude: longitude}
+		filter.RadiusKm = radiusKm
+	}
+
+	stores, meta, err := app.Model.StoreDB.ListStores(queryParams, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.Model.StoreDB.FillOpenStatus(stores, time.Now())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxPauseMinutes is the longest a store can pause orders for in one go.
+const maxPauseMinutes = 24 * 60
+
+// GetStoreHoursHandler returns the store's weekly hours, upcoming holidays
+// and whether it is open right now.
+func (app *application) GetStoreHoursHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+
+	store, err := app.Model.StoreDB.GetStore(storeID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	schedules, err := app.Model.StoreHoursDB.Schedules([]data.Store{*store}, now)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	schedule := schedules[store.ID]
+
+	hours := schedule.Hours
+	if hours == nil {
+		hours = []data.OpeningHours{}
+	}
+	holidays, err := app.Model.StoreHoursDB.ListHolidays(now, store.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"opening_hours":   hours,
+		"holidays":        holidays,
+		"paused_until":    store.PausedUntil,
+		"is_open_now":     schedule.IsOpen(now),
+		"next_opening_at": schedule.NextOpening(now),
+	})
+}
+
+// UpdateStoreHoursHandler replaces the store's weekly hours with the JSON
+// array in the hours field, e.g.
+// [{"day_of_week":5,"opens_at":"18:00","closes_at":"02:00"}]. An empty array
+// keeps the store open around the clock.
+func (app *application) UpdateStoreHoursHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	hours := []data.OpeningHours{}
+	if value := r.FormValue("hours"); value != "" {
+		err = json.Unmarshal([]byte(value), &hours)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("أوقات العمل يجب أن تكون مصفوفة JSON صالحة"))
+			return
+		}
+	}
+
+	v := validator.New()
+	data.ValidateOpeningHours(v, hours)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.StoreHoursDB.ReplaceHours(storeID, hours)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":       "تم تحديث أوقات العمل بنجاح",
+		"opening_hours": hours,
+	})
+}
+
+func (app *application) CreateStoreHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	holiday := &data.StoreHoliday{
+		StoreID: storeID,
+		Date:    r.FormValue("date"),
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		holiday.Reason = &reason
+	}
+
+	v := validator.New()
+	data.ValidateStoreHoliday(v, holiday)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.StoreHoursDB.InsertHoliday(holiday)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تمت إضافة العطلة بنجاح",
+		"holiday": holiday,
+	})
+}
+
+func (app *application) DeleteStoreHolidayHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	holidayID, err := uuid.Parse(r.PathValue("holiday_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف العطلة غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	err = app.Model.StoreHoursDB.DeleteHoliday(storeID, holidayID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم حذف العطلة بنجاح",
+	})
+}
+
+// PauseStoreHandler stops the store taking orders for the given number of
+// minutes.
+func (app *application) PauseStoreHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	minutes, err := strconv.Atoi(r.FormValue("minutes"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("عدد الدقائق يجب أن يكون عددًا صحيحًا"))
+		return
+	}
+	v := validator.New()
+	v.Check(minutes > 0 && minutes <= maxPauseMinutes, "minutes", "مدة الإيقاف يجب أن تكون بين 1 و 1440 دقيقة")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	pausedUntil := time.Now().UTC().Add(time.Duration(minutes) * time.Minute)
+	err = app.Model.StoreHoursDB.SetPausedUntil(storeID, &pausedUntil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":      "تم إيقاف استقبال الطلبات مؤقتاً",
+		"paused_until": pausedUntil,
+	})
+}
+
+// ResumeStoreHandler lets a paused store take orders again.
+func (app *application) ResumeStoreHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	err = app.Model.StoreHoursDB.SetPausedUntil(storeID, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم استئناف استقبال الطلبات",
+	})
+}
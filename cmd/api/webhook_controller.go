@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// parseEventTypes reads a comma-separated list of event types.
+func parseEventTypes(value string) pq.StringArray {
+	eventTypes := pq.StringArray{}
+	for _, eventType := range strings.Split(value, ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	return eventTypes
+}
+
+// webhookForOwner loads a webhook from the path and checks that the user
+// owns its store.
+func (app *application) webhookForOwner(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الـ webhook غير صالح"))
+		return nil, false
+	}
+
+	webhook, err := app.Model.WebhookDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+
+	if _, ok := app.storeForOwner(w, r, webhook.StoreID); !ok {
+		return nil, false
+	}
+
+	return webhook, true
+}
+
+func (app *application) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	webhook := &data.Webhook{
+		StoreID:    storeID,
+		URL:        r.FormValue("url"),
+		EventTypes: parseEventTypes(r.FormValue("event_types")),
+		IsActive:   true,
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, webhook)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.WebhookDB.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The secret is returned only here; it is needed to verify signatures.
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"message": "تم إنشاء الـ webhook بنجاح",
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+func (app *application) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	storeID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المتجر غير صالح"))
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, storeID); !ok {
+		return
+	}
+
+	webhooks, err := app.Model.WebhookDB.ListByStore(storeID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"webhooks": webhooks,
+	})
+}
+
+func (app *application) UpdateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	if url := r.FormValue("url"); url != "" {
+		webhook.URL = url
+	}
+	if _, ok := r.Form["event_types"]; ok {
+		webhook.EventTypes = parseEventTypes(r.FormValue("event_types"))
+	}
+	if isActive := r.FormValue("is_active"); isActive != "" {
+		active, err := strconv.ParseBool(isActive)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("قيمة is_active غير صالحة"))
+			return
+		}
+		webhook.IsActive = active
+	}
+
+	v := validator.New()
+	data.ValidateWebhook(v, webhook)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.Model.WebhookDB.Update(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم تحديث الـ webhook بنجاح",
+		"webhook": webhook,
+	})
+}
+
+func (app *application) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.Model.WebhookDB.Delete(webhook.ID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "تم حذف الـ webhook بنجاح",
+	})
+}
+
+func (app *application) ListWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookForOwner(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, meta, err := app.Model.WebhookDeliveryDB.ListByWebhook(webhook.ID, r.URL.Query())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"deliveries": deliveries,
+		"meta":       meta,
+	})
+}
+
+func (app *application) RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف سجل الإرسال غير صالح"))
+		return
+	}
+
+	delivery, err := app.Model.WebhookDeliveryDB.Get(id)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	webhook, err := app.Model.WebhookDB.Get(delivery.WebhookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if _, ok := app.storeForOwner(w, r, webhook.StoreID); !ok {
+		return
+	}
+
+	err = app.Model.WebhookDeliveryDB.Redeliver(delivery)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.webhooks.Wake()
+
+	utils.SendJSONResponse(w, http.StatusAccepted, utils.Envelope{
+		"message":  "تمت جدولة إعادة الإرسال",
+		"delivery": delivery,
+	})
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"project/internal/data"
+	"project/internal/events"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// webhookMaxAttempts is how many times a delivery is tried before it is
+	// marked failed; the owner can still redeliver it by hand.
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	// webhookLease keeps a claimed delivery away from other workers while it
+	// is being sent. It must exceed the client timeout.
+	webhookLease   = time.Minute
+	webhookTimeout = 10 * time.Second
+	webhookBatch   = 20
+)
+
+// webhookEnvelope is the body POSTed to webhook endpoints. Data carries the
+// event payload unchanged, i.e. the order in the same shape the API returns.
+type webhookEnvelope struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	StoreID   string          `json:"store_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of "timestamp.body" under
+// the webhook's secret. Receivers recompute it from the X-Webhook-Timestamp
+// header and the raw body and compare it with X-Webhook-Signature.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookDialer refuses to connect to the addresses ValidateWebhook refuses.
+// It checks the address actually dialled, after DNS resolution, so a host
+// that has since been pointed at an internal address is still stopped.
+var webhookDialer = &net.Dialer{
+	Timeout: webhookTimeout,
+	Control: func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !data.IsPublicAddress(ip) {
+			return fmt.Errorf("webhook address %s is not allowed", host)
+		}
+		return nil
+	},
+}
+
+// newWebhookClient returns the client deliveries are sent with. It dials
+// through webhookDialer and never uses a proxy.
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         webhookDialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// webhookBackoff is the wait before the next attempt after the given number
+// of failed ones: 30s, 1m, 2m, ... capped at webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// webhookStore and deliveryStore are what the sender needs from WebhookDB
+// and WebhookDeliveryDB.
+type webhookStore interface {
+	Get(id uuid.UUID) (*data.Webhook, error)
+	ListSubscribed(storeID uuid.UUID, eventType string) ([]data.Webhook, error)
+}
+
+type deliveryStore interface {
+	Enqueue(webhookID uuid.UUID, eventID int64, eventType string, payload []byte) error
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]data.WebhookDelivery, error)
+	RecordAttempt(delivery *data.WebhookDelivery, statusCode *int, attemptErr *string, nextAttempt *time.Time) error
+}
+
+// WebhookSender turns store events into webhook deliveries and sends them.
+type WebhookSender struct {
+	webhooks   webhookStore
+	deliveries deliveryStore
+	bus        *events.Bus
+	client     *http.Client
+	log        *log.Logger
+	interval   time.Duration
+	now        func() time.Time
+
+	wake chan struct{}
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewWebhookSender(model *data.Model, bus *events.Bus, logger *log.Logger) *WebhookSender {
+	return &WebhookSender{
+		webhooks:   &model.WebhookDB,
+		deliveries: &model.WebhookDeliveryDB,
+		bus:        bus,
+		client:     newWebhookClient(),
+		log:        logger,
+		interval:   5 * time.Second,
+		now:        time.Now,
+		wake:       make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Start consumes store events from the bus and begins sending deliveries.
+func (s *WebhookSender) Start() error {
+	err := s.bus.Consume("webhooks", events.Filter{Types: data.WebhookEventTypes}, s.enqueue)
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.wake:
+			case <-s.quit:
+				return
+			}
+			s.sendDue()
+		}
+	}()
+
+	return nil
+}
+
+func (s *WebhookSender) Stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// Wake asks for due deliveries to be sent now. It never blocks.
+func (s *WebhookSender) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue records a delivery of event for every webhook of its store that
+// wants it. Returning an error makes the bus hand the event over again.
+func (s *WebhookSender) enqueue(event events.Event) error {
+	if event.StoreID == nil {
+		return nil
+	}
+
+	webhooks, err := s.webhooks.ListSubscribed(*event.StoreID, event.Type)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(webhookEnvelope{
+		ID:        event.ID,
+		Type:      event.Type,
+		StoreID:   event.StoreID.String(),
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		err = s.deliveries.Enqueue(webhook.ID, event.ID, event.Type, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.Wake()
+	return nil
+}
+
+func (s *WebhookSender) sendDue() {
+	for {
+		deliveries, err := s.deliveries.ClaimDue(s.now(), webhookLease, webhookBatch)
+		if err != nil {
+			s.log.Printf("webhooks: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			wg.Add(1)
+			go func(delivery *data.WebhookDelivery) {
+				defer wg.Done()
+				s.send(delivery)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookBatch {
+			return
+		}
+	}
+}
+
+// send makes one attempt at a delivery and records the outcome.
+func (s *WebhookSender) send(delivery *data.WebhookDelivery) {
+	webhook, err := s.webhooks.Get(delivery.WebhookID)
+	if err != nil {
+		s.log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+		return
+	}
+
+	var statusCode *int
+	var attemptErr *string
+	if !webhook.IsActive {
+		message := "webhook is disabled"
+		attemptErr = &message
+	} else {
+		code, err := s.post(webhook, delivery)
+		if code != 0 {
+			statusCode = &code
+		}
+		if err != nil {
+			message := err.Error()
+			attemptErr = &message
+		}
+	}
+
+	var nextAttempt *time.Time
+	if attemptErr != nil && webhook.IsActive && delivery.Attempts+1 < webhookMaxAttempts {
+		next := s.now().Add(webhookBackoff(delivery.Attempts + 1))
+		nextAttempt = &next
+	}
+
+	err = s.deliveries.RecordAttempt(delivery, statusCode, attemptErr, nextAttempt)
+	if err != nil {
+		s.log.Printf("webhooks: delivery %s: %v", delivery.ID, err)
+	}
+}
+
+func (s *WebhookSender) post(webhook *data.Webhook, delivery *data.WebhookDelivery) (int, error) {
+	timestamp := s.now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Vendor-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", webhook.ID.String())
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.String())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"project/internal/data"
+
+	"github.com/google/uuid"
+)
+
+// fakeWebhookDB holds one webhook and its deliveries in memory, claiming and
+// recording them the way WebhookDeliveryDB does.
+type fakeWebhookDB struct {
+	mu         sync.Mutex
+	webhook    data.Webhook
+	deliveries map[uuid.UUID]data.WebhookDelivery
+}
+
+func (f *fakeWebhookDB) Get(id uuid.UUID) (*data.Webhook, error) {
+	if id != f.webhook.ID {
+		return nil, data.ErrWebhookNotFound
+	}
+	webhook := f.webhook
+	return &webhook, nil
+}
+
+func (f *fakeWebhookDB) ListSubscribed(storeID uuid.UUID, eventType string) ([]data.Webhook, error) {
+	return []data.Webhook{f.webhook}, nil
+}
+
+func (f *fakeWebhookDB) Enqueue(webhookID uuid.UUID, eventID int64, eventType string, payload []byte) error {
+	return nil
+}
+
+func (f *fakeWebhookDB) ClaimDue(now time.Time, lease time.Duration, limit int) ([]data.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	due := []data.WebhookDelivery{}
+	for id, delivery := range f.deliveries {
+		if delivery.Status != data.DeliveryPending || delivery.NextAttemptAt.After(now) || len(due) == limit {
+			continue
+		}
+		due = append(due, delivery)
+		delivery.NextAttemptAt = now.Add(lease)
+		f.deliveries[id] = delivery
+	}
+	return due, nil
+}
+
+func (f *fakeWebhookDB) RecordAttempt(delivery *data.WebhookDelivery, statusCode *int, attemptErr *string, nextAttempt *time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delivery.ApplyAttempt(statusCode, attemptErr, nextAttempt, time.Now())
+	f.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+func (f *fakeWebhookDB) delivery(id uuid.UUID) data.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deliveries[id]
+}
+
+// newTestWebhookSender sends to srv with the clock at *now. It uses the test
+// server's client, since the sender's own refuses loopback addresses.
+func newTestWebhookSender(srv *httptest.Server, now *time.Time) (*WebhookSender, *fakeWebhookDB, uuid.UUID) {
+	db := &fakeWebhookDB{
+		webhook: data.Webhook{
+			ID:       uuid.New(),
+			StoreID:  uuid.New(),
+			URL:      srv.URL,
+			Secret:   "test-secret",
+			IsActive: true,
+		},
+		deliveries: map[uuid.UUID]data.WebhookDelivery{},
+	}
+	delivery := data.WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     db.webhook.ID,
+		EventID:       42,
+		EventType:     "order.created",
+		Payload:       []byte(`{"id":42,"type":"order.created","data":{"total_price":12.50}}`),
+		Status:        data.DeliveryPending,
+		NextAttemptAt: *now,
+	}
+	db.deliveries[delivery.ID] = delivery
+
+	sender := &WebhookSender{
+		webhooks:   db,
+		deliveries: db,
+		client:     srv.Client(),
+		log:        log.New(io.Discard, "", 0),
+		now:        func() time.Time { return *now },
+	}
+	return sender, db, delivery.ID
+}
+
+func TestWebhookSignatureVerifiedByReceiver(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	const secret = "test-secret"
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		timestamp := r.Header.Get("X-Webhook-Timestamp")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+		if got := r.Header.Get("X-Webhook-Signature"); !hmac.Equal([]byte(got), []byte(want)) {
+			t.Errorf("signature %q, want %q", got, want)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if timestamp != strconv.FormatInt(now.Unix(), 10) {
+			t.Errorf("timestamp %q, want the sender's clock", timestamp)
+		}
+		if got := r.Header.Get("X-Webhook-Event"); got != "order.created" {
+			t.Errorf("event header %q, want order.created", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("content type %q, want application/json", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sender, db, deliveryID := newTestWebhookSender(srv, &now)
+	sender.sendDue()
+
+	if requests != 1 {
+		t.Fatalf("receiver got %d requests, want 1", requests)
+	}
+	delivery := db.delivery(deliveryID)
+	if delivery.Status != data.DeliverySucceeded || delivery.Attempts != 1 {
+		t.Errorf("delivery is %s after %d attempts, want %s after 1", delivery.Status, delivery.Attempts, data.DeliverySucceeded)
+	}
+	if delivery.LastStatusCode == nil || *delivery.LastStatusCode != http.StatusNoContent {
+		t.Errorf("last status code %v, want %d", delivery.LastStatusCode, http.StatusNoContent)
+	}
+}
+
+func TestWebhookRetriedWithBackoffThenFailed(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sender, db, deliveryID := newTestWebhookSender(srv, &now)
+
+	// The waits after each failed attempt; the last attempt has none.
+	backoffs := []time.Duration{
+		30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute,
+		8 * time.Minute, 16 * time.Minute, 32 * time.Minute,
+	}
+	if len(backoffs) != webhookMaxAttempts-1 {
+		t.Fatalf("test expects %d attempts, sender makes %d", len(backoffs)+1, webhookMaxAttempts)
+	}
+
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		sender.sendDue()
+
+		delivery := db.delivery(deliveryID)
+		if requests != attempt {
+			t.Fatalf("attempt %d: receiver got %d requests", attempt, requests)
+		}
+		if delivery.Attempts != attempt {
+			t.Fatalf("attempt %d: delivery records %d attempts", attempt, delivery.Attempts)
+		}
+		if delivery.LastStatusCode == nil || *delivery.LastStatusCode != http.StatusServiceUnavailable {
+			t.Errorf("attempt %d: last status code %v, want %d", attempt, delivery.LastStatusCode, http.StatusServiceUnavailable)
+		}
+		if attempt == webhookMaxAttempts {
+			if delivery.Status != data.DeliveryFailed {
+				t.Errorf("after the last attempt the delivery is %s, want %s", delivery.Status, data.DeliveryFailed)
+			}
+			break
+		}
+
+		if delivery.Status != data.DeliveryPending {
+			t.Fatalf("attempt %d: delivery is %s, want %s", attempt, delivery.Status, data.DeliveryPending)
+		}
+		wantNext := now.Add(backoffs[attempt-1])
+		if !delivery.NextAttemptAt.Equal(wantNext) {
+			t.Errorf("attempt %d: next attempt at %v, want %v", attempt, delivery.NextAttemptAt, wantNext)
+		}
+
+		// Nothing is sent again before the backoff has passed.
+		now = delivery.NextAttemptAt.Add(-time.Second)
+		sender.sendDue()
+		if requests != attempt {
+			t.Fatalf("attempt %d: retried before its backoff", attempt)
+		}
+		now = delivery.NextAttemptAt
+	}
+
+	// A failed delivery is not picked up again.
+	now = now.Add(24 * time.Hour)
+	sender.sendDue()
+	if requests != webhookMaxAttempts {
+		t.Errorf("receiver got %d requests after the delivery failed, want %d", requests, webhookMaxAttempts)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"project/internal/data"
+	"project/internal/events"
+	"project/utils/validator"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || origin == "http://localhost:5173" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	},
+}
+
+// locationPing is what a driver sends over the order channel.
+type locationPing struct {
+	Type      string  `json:"type"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// OrderTrackingHandler upgrades to a WebSocket that streams status changes and
+// driver locations for one order. The driver holding the order may send
+// location pings on the same connection; it is closed once they no longer
+// hold it.
+func (app *application) OrderTrackingHandler(w http.ResponseWriter, r *http.Request) {
+	userIDStr, ok := r.Context().Value(UserIDKey).(string)
+	if !ok {
+		app.unauthorizedResponse(w, r)
+		return
+	}
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف المستخدم غير صالح"))
+		return
+	}
+
+	orderID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("معرف الطلب غير صالح"))
+		return
+	}
+
+	order, err := app.Model.OrderDB.Get(orderID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+	actor, err := app.canAccessOrder(userID, userRoles, order)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if actor == "" {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response.
+		app.logError(r, err)
+		return
+	}
+
+	client := app.hub.register(conn, order.ID)
+	if client == nil {
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		conn.Close()
+		return
+	}
+
+	// Send the current status so the client does not have to fetch it separately.
+	app.hub.sendTo(client, OrderEvent{
+		Type:    OrderEventStatus,
+		OrderID: order.ID,
+		Status:  order.Status,
+		At:      order.UpdatedAt,
+	})
+
+	var handle func(message []byte) bool
+	if actor == data.ActorDelivery {
+		handle = func(message []byte) bool {
+			return app.handleDriverPing(r, userID, order.ID, message)
+		}
+	}
+	app.hub.readPump(client, handle)
+}
+
+// handleDriverPing stores a driver's position and broadcasts it to the
+// order's subscribers on every instance. It reports false once the driver no
+// longer holds the order, e.g. after the assignment was cancelled.
+func (app *application) handleDriverPing(r *http.Request, driverID, orderID uuid.UUID, message []byte) bool {
+	var ping locationPing
+	if err := json.Unmarshal(message, &ping); err != nil || ping.Type != OrderEventLocation {
+		return true
+	}
+
+	v := validator.New()
+	data.ValidateLocation(v, ping.Latitude, ping.Longitude)
+	if !v.Valid() {
+		return true
+	}
+
+	err := app.Model.DriverDB.ReportOrderLocation(driverID, orderID, ping.Latitude, ping.Longitude)
+	if err != nil {
+		if errors.Is(err, data.ErrAssignmentNotFound) {
+			return false
+		}
+		app.logError(r, err)
+	}
+	return true
+}
+
+// relayOrderEvents forwards status changes and driver locations from the
+// event bus to the WebSocket subscribers of each order, whichever instance
+// received them.
+func (app *application) relayOrderEvents() {
+	filter := events.Filter{Types: []string{events.OrderStatusChanged, events.OrderDriverLocation}}
+	for {
+		sub := app.events.Subscribe(filter)
+		for event := range sub.C {
+			if event.Type == events.OrderDriverLocation {
+				app.relayDriverLocation(event)
+				continue
+			}
+			var payload data.OrderEventPayload
+			if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.Order == nil {
+				app.log.Printf("relay: decoding event %d: %v", event.ID, err)
+				continue
+			}
+			app.hub.Publish(OrderEvent{
+				Type:    OrderEventStatus,
+				OrderID: payload.Order.ID,
+				Status:  payload.Order.Status,
+				At:      payload.Order.UpdatedAt,
+			})
+		}
+
+		// The subscription closes on shutdown, or when this relay fell
+		// behind; in that case pick up again from live events.
+		select {
+		case <-app.events.Done():
+			return
+		default:
+		}
+	}
+}
+
+// relayDriverLocation publishes a broadcast driver position to the order's
+// subscribers on this instance.
+func (app *application) relayDriverLocation(event events.Event) {
+	var payload data.DriverLocationPayload
+	if err := json.Unmarshal(event.Payload, &payload); err != nil || event.AggregateID == nil {
+		app.log.Printf("relay: decoding driver location: %v", err)
+		return
+	}
+	app.hub.Publish(OrderEvent{
+		Type:      OrderEventLocation,
+		OrderID:   *event.AggregateID,
+		Latitude:  &payload.Latitude,
+		Longitude: &payload.Longitude,
+		At:        event.CreatedAt,
+	})
+}
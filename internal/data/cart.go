@@ -13,6 +13,7 @@ type Cart struct {
 	ID        uuid.UUID  `db:"id" json:"id"`
 	UserID    uuid.UUID  `db:"user_id" json:"user_id"`
 	StoreID   *uuid.UUID `db:"store_id" json:"store_id,omitempty"`
+	CouponID  *uuid.UUID `db:"coupon_id" json:"coupon_id,omitempty"`
 	CreatedAt time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
 }
@@ -102,3 +103,25 @@ func (c *CartDB) Update(cart *Cart) error {
 
 	return c.db.QueryRow(query, args...).Scan(&cart.StoreID, &cart.UpdatedAt)
 }
+
+// SetCoupon attaches a coupon to the cart, or detaches it when couponID is nil.
+func (c *CartDB) SetCoupon(cart *Cart, couponID *uuid.UUID) error {
+	cart.CouponID = couponID
+	cart.UpdatedAt = time.Now()
+
+	query, args, err := QB.Update("carts").
+		Set("coupon_id", cart.CouponID).
+		Set("updated_at", cart.UpdatedAt).
+		Where(squirrel.Eq{"id": cart.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating cart coupon: %v", err)
+	}
+
+	return nil
+}
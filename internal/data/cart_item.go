@@ -9,6 +9,7 @@ import (
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 type CartItem struct {
@@ -18,6 +19,9 @@ type CartItem struct {
 	Quantity  int       `db:"quantity" json:"quantity"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+
+	// The options picked for the item, set when it is fetched.
+	OptionIDs []uuid.UUID `db:"-" json:"option_ids"`
 }
 
 type CartItemDB struct {
@@ -37,6 +41,16 @@ func (ci *CartItemDB) Insert(item *CartItem) error {
 	item.CreatedAt = time.Now()
 	item.UpdatedAt = time.Now()
 
+	if item.OptionIDs == nil {
+		item.OptionIDs = []uuid.UUID{}
+	}
+
+	tx, err := ci.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
 	query, args, err := QB.Insert("cart_items").
 		Columns(cartItemColumns...).
 		Values(item.ID, item.CartID, item.ProductID, item.Quantity, item.CreatedAt, item.UpdatedAt).
@@ -46,7 +60,31 @@ func (ci *CartItemDB) Insert(item *CartItem) error {
 		return fmt.Errorf("error creating query: %v", err)
 	}
 
-	return ci.db.QueryRow(query, args...).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+	err = tx.QueryRow(query, args...).Scan(&item.ID, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting cart item: %v", err)
+	}
+
+	if len(item.OptionIDs) > 0 {
+		insert := QB.Insert("cart_item_options").Columns("cart_item_id", "option_id")
+		for _, optionID := range item.OptionIDs {
+			insert = insert.Values(item.ID, optionID)
+		}
+		query, args, err = insert.ToSql()
+		if err != nil {
+			return fmt.Errorf("error creating query: %v", err)
+		}
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("error inserting cart item options: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
 }
 
 func (ci *CartItemDB) Get(id uuid.UUID) (*CartItem, error) {
@@ -64,7 +102,13 @@ func (ci *CartItemDB) Get(id uuid.UUID) (*CartItem, error) {
 		return nil, fmt.Errorf("error getting cart item: %v", err)
 	}
 
-	return &item, nil
+	items := []CartItem{item}
+	err = fillCartItemOptions(ci.db, items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &items[0], nil
 }
 
 func (ci *CartItemDB) Update(item *CartItem) error {
@@ -115,5 +159,49 @@ func (ci *CartItemDB) ListByCart(cartID uuid.UUID) ([]CartItem, error) {
 		return nil, fmt.Errorf("error getting cart items: %v", err)
 	}
 
+	err = fillCartItemOptions(ci.db, items)
+	if err != nil {
+		return nil, err
+	}
+
 	return items, nil
 }
+
+// fillCartItemOptions sets the OptionIDs of each item.
+func fillCartItemOptions(db DBInterface, items []CartItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	var rows []struct {
+		CartItemID uuid.UUID `db:"cart_item_id"`
+		OptionID   uuid.UUID `db:"option_id"`
+	}
+	query, args, err := QB.Select("cart_item_id", "option_id").
+		From("cart_item_options").
+		Where(squirrel.Eq{"cart_item_id": ids}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	err = db.Select(&rows, query, args...)
+	if err != nil {
+		return fmt.Errorf("error getting cart item options: %v", err)
+	}
+
+	byItem := make(map[uuid.UUID][]uuid.UUID, len(items))
+	for _, row := range rows {
+		byItem[row.CartItemID] = append(byItem[row.CartItemID], row.OptionID)
+	}
+	for i := range items {
+		items[i].OptionIDs = byItem[items[i].ID]
+		if items[i].OptionIDs == nil {
+			items[i].OptionIDs = []uuid.UUID{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"project/utils"
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+// Coupon is a promo code. It takes either a percentage or a fixed amount off
+// the basket and may be limited in time, in number of uses overall and per
+// customer, and to one store or to every store of one type. A store's codes
+// only need to be unique within the store; coupons not tied to a store share
+// one set of codes.
+type Coupon struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	Code         string     `db:"code" json:"code"`
+	Description  *string    `db:"description" json:"description,omitempty"`
+	DiscountType string     `db:"discount_type" json:"discount_type"`
+	PercentOff   *int       `db:"percent_off" json:"percent_off,omitempty"`
+	AmountOff    *Money     `db:"amount_off" json:"amount_off,omitempty"`
+	MinBasket    Money      `db:"min_basket" json:"min_basket"`
+	UsageLimit   *int       `db:"usage_limit" json:"usage_limit,omitempty"`
+	PerUserLimit *int       `db:"per_user_limit" json:"per_user_limit,omitempty"`
+	StartsAt     time.Time  `db:"starts_at" json:"starts_at"`
+	EndsAt       *time.Time `db:"ends_at" json:"ends_at,omitempty"`
+	StoreID      *uuid.UUID `db:"store_id" json:"store_id,omitempty"`
+	StoreTypeID  *int       `db:"store_type_id" json:"store_type_id,omitempty"`
+	CreatedBy    uuid.UUID  `db:"created_by" json:"created_by"`
+	IsActive     bool       `db:"is_active" json:"is_active"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// CouponBasket is what a coupon is checked against.
+type CouponBasket struct {
+	UserID      uuid.UUID
+	StoreID     uuid.UUID
+	StoreTypeID int
+	Subtotal    Money
+}
+
+type CouponDB struct {
+	db DBInterface
+}
+
+var couponColumns = []string{
+	"id", "code", "description", "discount_type", "percent_off", "amount_off", "min_basket",
+	"usage_limit", "per_user_limit", "starts_at", "ends_at", "store_id", "store_type_id",
+	"created_by", "is_active", "created_at", "updated_at",
+}
+
+// NormalizeCouponCode is applied to codes before they are stored or looked
+// up, so customers may type them in any case.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func ValidateCoupon(v *validator.Validator, coupon *Coupon) {
+	v.Check(coupon.Code != "", "code", "يجب إدخال رمز الكوبون")
+	v.Check(len(coupon.Code) <= 50, "code", "يجب ألا يزيد الرمز عن 50 حرف")
+	v.Check(validator.In(coupon.DiscountType, CouponPercentage, CouponFixed), "discount_type", "نوع الخصم غير صالح")
+	switch coupon.DiscountType {
+	case CouponPercentage:
+		v.Check(coupon.PercentOff != nil && *coupon.PercentOff >= 1 && *coupon.PercentOff <= 100, "percent_off", "يجب أن تكون النسبة بين 1 و 100")
+		v.Check(coupon.AmountOff == nil, "amount_off", "لا يمكن تحديد مبلغ مع خصم بالنسبة")
+	case CouponFixed:
+		v.Check(coupon.AmountOff != nil && coupon.AmountOff.Amount > 0, "amount_off", "يجب أن يكون مبلغ الخصم أكبر من 0")
+		v.Check(coupon.PercentOff == nil, "percent_off", "لا يمكن تحديد نسبة مع خصم بمبلغ ثابت")
+	}
+	v.Check(!coupon.MinBasket.IsNegative(), "min_basket", "يجب أن يكون الحد الأدنى قيمة غير سالبة")
+	v.Check(coupon.UsageLimit == nil || *coupon.UsageLimit > 0, "usage_limit", "يجب أن يكون حد الاستخدام أكبر من 0")
+	v.Check(coupon.PerUserLimit == nil || *coupon.PerUserLimit > 0, "per_user_limit", "يجب أن يكون حد الاستخدام أكبر من 0")
+	v.Check(coupon.EndsAt == nil || coupon.EndsAt.After(coupon.StartsAt), "ends_at", "يجب أن يكون تاريخ الانتهاء بعد تاريخ البدء")
+	v.Check(coupon.StoreID == nil || coupon.StoreTypeID == nil, "store_type_id", "لا يمكن تقييد الكوبون بمتجر ونوع متجر معاً")
+}
+
+// Discount is what the coupon takes off a basket worth subtotal. It never
+// exceeds the subtotal.
+func (c *Coupon) Discount(subtotal Money) Money {
+	var discount Money
+	switch c.DiscountType {
+	case CouponPercentage:
+		discount = subtotal.MulFraction(int64(*c.PercentOff), 100)
+	case CouponFixed:
+		discount = *c.AmountOff
+	}
+	return discount.Min(subtotal)
+}
+
+// checkRules checks everything about a coupon except its usage limits.
+func (c *Coupon) checkRules(basket CouponBasket, now time.Time) error {
+	if !c.IsActive || now.Before(c.StartsAt) || (c.EndsAt != nil && !now.Before(*c.EndsAt)) {
+		return ErrCouponNotActive
+	}
+	if c.StoreID != nil && *c.StoreID != basket.StoreID {
+		return ErrCouponNotApplicable
+	}
+	if c.StoreTypeID != nil && *c.StoreTypeID != basket.StoreTypeID {
+		return ErrCouponNotApplicable
+	}
+	if basket.Subtotal.Cmp(c.MinBasket) < 0 {
+		return ErrCouponMinBasket
+	}
+	return nil
+}
+
+// Check reports whether the coupon can be used on the basket at the given
+// time. Redemptions of cancelled orders do not count towards the limits.
+func (c *CouponDB) Check(coupon *Coupon, basket CouponBasket, now time.Time) error {
+	err := coupon.checkRules(basket, now)
+	if err != nil {
+		return err
+	}
+
+	if coupon.UsageLimit == nil && coupon.PerUserLimit == nil {
+		return nil
+	}
+
+	query, args, err := QB.Select("COUNT(*)").
+		Column(squirrel.Expr("COUNT(*) FILTER (WHERE cr.user_id = ?)", basket.UserID)).
+		From("coupon_redemptions cr").
+		Join("orders o ON o.id = cr.order_id").
+		Where(squirrel.Eq{"cr.coupon_id": coupon.ID}).
+		Where(squirrel.NotEq{"o.status": OrderStatusCancelled}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	var total, byUser int
+	err = c.db.QueryRow(query, args...).Scan(&total, &byUser)
+	if err != nil {
+		return fmt.Errorf("error counting coupon redemptions: %v", err)
+	}
+
+	if coupon.UsageLimit != nil && total >= *coupon.UsageLimit {
+		return ErrCouponUsageLimit
+	}
+	if coupon.PerUserLimit != nil && byUser >= *coupon.PerUserLimit {
+		return ErrCouponUserLimit
+	}
+	return nil
+}
+
+func (c *CouponDB) Insert(coupon *Coupon) error {
+	coupon.ID = uuid.New()
+	coupon.Code = NormalizeCouponCode(coupon.Code)
+	coupon.CreatedAt = time.Now()
+	coupon.UpdatedAt = coupon.CreatedAt
+
+	query, args, err := QB.Insert("coupons").
+		Columns(couponColumns...).
+		Values(coupon.ID, coupon.Code, coupon.Description, coupon.DiscountType, coupon.PercentOff, coupon.AmountOff,
+			coupon.MinBasket, coupon.UsageLimit, coupon.PerUserLimit, coupon.StartsAt, coupon.EndsAt,
+			coupon.StoreID, coupon.StoreTypeID, coupon.CreatedBy, coupon.IsActive, coupon.CreatedAt, coupon.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateCouponCode
+		}
+		return fmt.Errorf("error inserting coupon: %v", err)
+	}
+
+	return nil
+}
+
+func (c *CouponDB) Get(id uuid.UUID) (*Coupon, error) {
+	return c.getWhere(squirrel.Eq{"id": id}, "")
+}
+
+// GetByCode finds the coupon a customer of the store means by code: the
+// store's own coupon with that code, or else the one not tied to any store.
+func (c *CouponDB) GetByCode(code string, storeID uuid.UUID) (*Coupon, error) {
+	var coupon Coupon
+	query, args, err := QB.Select(couponColumns...).From("coupons").
+		Where(squirrel.Eq{"code": NormalizeCouponCode(code)}).
+		Where(squirrel.Or{squirrel.Eq{"store_id": storeID}, squirrel.Eq{"store_id": nil}}).
+		OrderBy("store_id IS NULL").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = c.db.Get(&coupon, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("error getting coupon: %v", err)
+	}
+
+	return &coupon, nil
+}
+
+// getForUpdate locks the coupon until the end of the transaction, so that
+// concurrent checkouts cannot both take its last use.
+func (c *CouponDB) getForUpdate(id uuid.UUID) (*Coupon, error) {
+	return c.getWhere(squirrel.Eq{"id": id}, "FOR UPDATE")
+}
+
+func (c *CouponDB) getWhere(where squirrel.Eq, suffix string) (*Coupon, error) {
+	var coupon Coupon
+	query, args, err := QB.Select(couponColumns...).From("coupons").Where(where).Suffix(suffix).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = c.db.Get(&coupon, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("error getting coupon: %v", err)
+	}
+
+	return &coupon, nil
+}
+
+// Update saves a coupon's terms. Its code, scope and creator are fixed.
+func (c *CouponDB) Update(coupon *Coupon) error {
+	coupon.UpdatedAt = time.Now()
+	query, args, err := QB.Update("coupons").
+		SetMap(map[string]interface{}{
+			"description":    coupon.Description,
+			"discount_type":  coupon.DiscountType,
+			"percent_off":    coupon.PercentOff,
+			"amount_off":     coupon.AmountOff,
+			"min_basket":     coupon.MinBasket,
+			"usage_limit":    coupon.UsageLimit,
+			"per_user_limit": coupon.PerUserLimit,
+			"starts_at":      coupon.StartsAt,
+			"ends_at":        coupon.EndsAt,
+			"is_active":      coupon.IsActive,
+			"updated_at":     coupon.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": coupon.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating coupon: %v", err)
+	}
+
+	return nil
+}
+
+// Deactivate turns a coupon off for good instead of deleting it, so its
+// redemptions stay on record and its code, with the limits counted against
+// it, cannot be created afresh. Update can turn it back on.
+func (c *CouponDB) Deactivate(id uuid.UUID) error {
+	query, args, err := QB.Update("coupons").
+		SetMap(map[string]interface{}{
+			"is_active":  false,
+			"updated_at": time.Now(),
+		}).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deactivating coupon: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrCouponNotFound
+	}
+
+	return nil
+}
+
+// List returns coupons. When storeIDs is not nil only coupons restricted to
+// those stores are included.
+func (c *CouponDB) List(queryParams url.Values, storeIDs []uuid.UUID) ([]Coupon, *utils.Meta, error) {
+	additionalFilters := []string{}
+	if storeIDs != nil {
+		if len(storeIDs) == 0 {
+			additionalFilters = append(additionalFilters, "FALSE")
+		} else {
+			ids := make([]string, len(storeIDs))
+			for i, id := range storeIDs {
+				ids[i] = fmt.Sprintf("'%s'", id)
+			}
+			additionalFilters = append(additionalFilters, fmt.Sprintf("store_id IN (%s)", strings.Join(ids, ", ")))
+		}
+	}
+
+	coupons := []Coupon{}
+	meta, err := utils.BuildQuery(&coupons, "coupons", nil, couponColumns, []string{"code", "description"}, queryParams, additionalFilters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list coupons: %v", err)
+	}
+	return coupons, meta, nil
+}
+
+// redeem records that an order used the coupon.
+func (c *CouponDB) redeem(coupon *Coupon, userID, orderID uuid.UUID, amount Money) error {
+	query, args, err := QB.Insert("coupon_redemptions").
+		Columns("coupon_id", "user_id", "order_id", "amount", "created_at").
+		Values(coupon.ID, userID, orderID, amount, time.Now()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error recording coupon redemption: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// StoreDeliverySettings decide how far a store delivers and what delivery
+// costs. Stores without settings deliver anywhere for free.
+type StoreDeliverySettings struct {
+	StoreID               uuid.UUID `db:"store_id" json:"store_id"`
+	RadiusKm              *float64  `db:"radius_km" json:"radius_km"`
+	BaseFee               Money     `db:"base_fee" json:"base_fee"`
+	PerKmFee              Money     `db:"per_km_fee" json:"per_km_fee"`
+	FreeDeliveryThreshold *Money    `db:"free_delivery_threshold" json:"free_delivery_threshold"`
+	MinOrderValue         Money     `db:"min_order_value" json:"min_order_value"`
+	CreatedAt             time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt             time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type StoreDeliverySettingsDB struct {
+	db DBInterface
+}
+
+var storeDeliverySettingsColumns = []string{
+	"store_id", "radius_km", "base_fee", "per_km_fee", "free_delivery_threshold", "min_order_value",
+	"created_at", "updated_at",
+}
+
+// DefaultDeliverySettings are used for stores that never saved any.
+func DefaultDeliverySettings(storeID uuid.UUID) *StoreDeliverySettings {
+	return &StoreDeliverySettings{
+		StoreID:       storeID,
+		BaseFee:       NewMoney(0),
+		PerKmFee:      NewMoney(0),
+		MinOrderValue: NewMoney(0),
+	}
+}
+
+func ValidateStoreDeliverySettings(v *validator.Validator, settings *StoreDeliverySettings) {
+	v.Check(settings.RadiusKm == nil || (*settings.RadiusKm > 0 && *settings.RadiusKm < 10000), "radius_km", "يجب أن يكون نطاق التوصيل بين 0 و 10000 كم")
+	v.Check(!settings.BaseFee.IsNegative(), "base_fee", "يجب أن تكون رسوم التوصيل قيمة غير سالبة")
+	v.Check(!settings.PerKmFee.IsNegative(), "per_km_fee", "يجب أن تكون رسوم الكيلومتر قيمة غير سالبة")
+	v.Check(settings.FreeDeliveryThreshold == nil || !settings.FreeDeliveryThreshold.IsNegative(), "free_delivery_threshold", "يجب أن يكون حد التوصيل المجاني قيمة غير سالبة")
+	v.Check(!settings.MinOrderValue.IsNegative(), "min_order_value", "يجب أن يكون الحد الأدنى للطلب قيمة غير سالبة")
+}
+
+// needsDistance reports whether the fee or the radius depend on where the
+// order goes.
+func (s *StoreDeliverySettings) needsDistance() bool {
+	return s.RadiusKm != nil || !s.PerKmFee.IsZero()
+}
+
+// Fee is the delivery fee for items worth itemsTotal delivered distanceKm
+// away. distanceKm is ignored unless needsDistance.
+func (s *StoreDeliverySettings) Fee(itemsTotal Money, distanceKm float64) (Money, error) {
+	if itemsTotal.Cmp(s.MinOrderValue) < 0 {
+		return Money{}, ErrBelowMinimumOrder
+	}
+	if s.RadiusKm != nil && distanceKm > *s.RadiusKm {
+		return Money{}, ErrOutsideDeliveryRadius
+	}
+	if s.FreeDeliveryThreshold != nil && itemsTotal.Cmp(*s.FreeDeliveryThreshold) >= 0 {
+		return NewMoney(0), nil
+	}
+
+	metres := int64(math.Round(distanceKm * 1000))
+	return s.BaseFee.Add(s.PerKmFee.MulFraction(metres, 1000)), nil
+}
+
+// Get returns the store's settings, or the defaults if it has none.
+func (d *StoreDeliverySettingsDB) Get(storeID uuid.UUID) (*StoreDeliverySettings, error) {
+	var settings StoreDeliverySettings
+	query, args, err := QB.Select(storeDeliverySettingsColumns...).
+		From("store_delivery_settings").
+		Where(squirrel.Eq{"store_id": storeID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&settings, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return DefaultDeliverySettings(storeID), nil
+		}
+		return nil, fmt.Errorf("error getting delivery settings: %v", err)
+	}
+
+	return &settings, nil
+}
+
+func (d *StoreDeliverySettingsDB) Upsert(settings *StoreDeliverySettings) error {
+	now := time.Now()
+
+	query, args, err := QB.Insert("store_delivery_settings").
+		Columns(storeDeliverySettingsColumns...).
+		Values(settings.StoreID, settings.RadiusKm, settings.BaseFee, settings.PerKmFee,
+			settings.FreeDeliveryThreshold, settings.MinOrderValue, now, now).
+		Suffix(`ON CONFLICT (store_id) DO UPDATE SET
+			radius_km = EXCLUDED.radius_km,
+			base_fee = EXCLUDED.base_fee,
+			per_km_fee = EXCLUDED.per_km_fee,
+			free_delivery_threshold = EXCLUDED.free_delivery_threshold,
+			min_order_value = EXCLUDED.min_order_value,
+			updated_at = EXCLUDED.updated_at
+			RETURNING created_at, updated_at`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	return d.db.QueryRow(query, args...).Scan(&settings.CreatedAt, &settings.UpdatedAt)
+}
@@ -0,0 +1,469 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// MaxScheduleDays is how many days ahead orders can be scheduled.
+const MaxScheduleDays = 14
+
+// DeliverySlot is a weekly delivery window of a store, taking up to Capacity
+// orders on each date it falls on.
+type DeliverySlot struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	StoreID   uuid.UUID `db:"store_id" json:"store_id"`
+	DayOfWeek int       `db:"day_of_week" json:"day_of_week"`
+	StartsAt  string    `db:"starts_at" json:"starts_at"`
+	EndsAt    string    `db:"ends_at" json:"ends_at"`
+	Capacity  int       `db:"capacity" json:"capacity"`
+	IsActive  bool      `db:"is_active" json:"is_active"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// SlotAvailability is a slot on a given date with the places left in it.
+type SlotAvailability struct {
+	DeliverySlot
+	Date      string    `json:"date"`
+	StartTime time.Time `json:"start_time"`
+	Remaining int       `json:"remaining"`
+}
+
+// SlotChoice is the slot and date a customer picks for an order.
+type SlotChoice struct {
+	SlotID uuid.UUID `db:"slot_id" json:"slot_id"`
+	Date   string    `db:"slot_date" json:"date"`
+}
+
+// SlotReservation is a place held in a slot for a cart, until ExpiresAt.
+type SlotReservation struct {
+	ID        uuid.UUID  `db:"id" json:"id"`
+	SlotID    uuid.UUID  `db:"slot_id" json:"slot_id"`
+	Date      string     `db:"slot_date" json:"date"`
+	CartID    *uuid.UUID `db:"cart_id" json:"cart_id,omitempty"`
+	OrderID   *uuid.UUID `db:"order_id" json:"order_id,omitempty"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+type DeliverySlotDB struct {
+	db DBInterface
+}
+
+var deliverySlotColumns = []string{
+	"id", "store_id", "day_of_week",
+	"to_char(starts_at, 'HH24:MI') AS starts_at", "to_char(ends_at, 'HH24:MI') AS ends_at",
+	"capacity", "is_active", "created_at", "updated_at",
+}
+
+func ValidateDeliverySlot(v *validator.Validator, slot *DeliverySlot) {
+	v.Check(slot.DayOfWeek >= 0 && slot.DayOfWeek <= 6, "day_of_week", "يوم الأسبوع يجب أن يكون بين 0 (الأحد) و 6 (السبت)")
+	starts, errStarts := time.Parse("15:04", slot.StartsAt)
+	ends, errEnds := time.Parse("15:04", slot.EndsAt)
+	v.Check(errStarts == nil, "starts_at", "وقت البداية يجب أن يكون بصيغة HH:MM")
+	v.Check(errEnds == nil, "ends_at", "وقت النهاية يجب أن يكون بصيغة HH:MM")
+	if errStarts == nil && errEnds == nil {
+		v.Check(ends.After(starts), "ends_at", "وقت النهاية يجب أن يكون بعد وقت البداية")
+	}
+	v.Check(slot.Capacity > 0, "capacity", "السعة يجب أن تكون أكبر من صفر")
+}
+
+// StartTime returns when the slot starts on date, given as YYYY-MM-DD. It
+// returns ErrDeliverySlotUnavailable if the slot does not fall on that date.
+func (s *DeliverySlot) StartTime(date string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, StoreLocation)
+	if err != nil || int(day.Weekday()) != s.DayOfWeek {
+		return time.Time{}, ErrDeliverySlotUnavailable
+	}
+	starts, err := time.Parse("15:04", s.StartsAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid slot start %q: %v", s.StartsAt, err)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), starts.Hour(), starts.Minute(), 0, 0, StoreLocation), nil
+}
+
+// bookable checks that the slot can still be booked at now for the given
+// date, and returns when it starts.
+func (s *DeliverySlot) bookable(date string, now time.Time) (time.Time, error) {
+	if !s.IsActive {
+		return time.Time{}, ErrDeliverySlotUnavailable
+	}
+	start, err := s.StartTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !start.After(now) || start.After(now.AddDate(0, 0, MaxScheduleDays)) {
+		return time.Time{}, ErrDeliverySlotUnavailable
+	}
+	return start, nil
+}
+
+func (d *DeliverySlotDB) Insert(slot *DeliverySlot) error {
+	slot.ID = uuid.New()
+	slot.CreatedAt = time.Now()
+	slot.UpdatedAt = slot.CreatedAt
+
+	query, args, err := QB.Insert("delivery_slots").
+		Columns("id", "store_id", "day_of_week", "starts_at", "ends_at", "capacity", "is_active", "created_at", "updated_at").
+		Values(slot.ID, slot.StoreID, slot.DayOfWeek, slot.StartsAt, slot.EndsAt, slot.Capacity, slot.IsActive,
+			slot.CreatedAt, slot.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting delivery slot: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DeliverySlotDB) Get(id uuid.UUID) (*DeliverySlot, error) {
+	return d.get(id, "")
+}
+
+func (d *DeliverySlotDB) get(id uuid.UUID, suffix string) (*DeliverySlot, error) {
+	var slot DeliverySlot
+	query, args, err := QB.Select(deliverySlotColumns...).
+		From("delivery_slots").
+		Where(squirrel.Eq{"id": id}).
+		Suffix(suffix).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&slot, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDeliverySlotNotFound
+		}
+		return nil, fmt.Errorf("error getting delivery slot: %v", err)
+	}
+
+	return &slot, nil
+}
+
+// Update changes the slot's window and capacity. Places already reserved
+// are kept even if the new capacity is lower.
+func (d *DeliverySlotDB) Update(slot *DeliverySlot) error {
+	slot.UpdatedAt = time.Now()
+	query, args, err := QB.Update("delivery_slots").
+		SetMap(map[string]interface{}{
+			"day_of_week": slot.DayOfWeek,
+			"starts_at":   slot.StartsAt,
+			"ends_at":     slot.EndsAt,
+			"capacity":    slot.Capacity,
+			"is_active":   slot.IsActive,
+			"updated_at":  slot.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": slot.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating delivery slot: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DeliverySlotDB) Delete(id uuid.UUID) error {
+	query, args, err := QB.Delete("delivery_slots").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting delivery slot: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrDeliverySlotNotFound
+	}
+
+	return nil
+}
+
+// ListByStore returns the store's slots ordered by day and time.
+func (d *DeliverySlotDB) ListByStore(storeID uuid.UUID) ([]DeliverySlot, error) {
+	slots := []DeliverySlot{}
+	query, args, err := QB.Select(deliverySlotColumns...).
+		From("delivery_slots").
+		Where(squirrel.Eq{"store_id": storeID}).
+		OrderBy("day_of_week", "delivery_slots.starts_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&slots, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing delivery slots: %v", err)
+	}
+
+	return slots, nil
+}
+
+// Availability returns the store's slots that can still be booked on date,
+// with the places left in each.
+func (d *DeliverySlotDB) Availability(storeID uuid.UUID, date string, now time.Time) ([]SlotAvailability, error) {
+	slots, err := d.ListByStore(storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []SlotAvailability{}
+	for _, slot := range slots {
+		start, err := slot.bookable(date, now)
+		if err != nil {
+			continue
+		}
+		reserved, err := countReservations(d.db, slot.ID, date, nil, now)
+		if err != nil {
+			return nil, err
+		}
+		available = append(available, SlotAvailability{
+			DeliverySlot: slot,
+			Date:         date,
+			StartTime:    start,
+			Remaining:    max(slot.Capacity-reserved, 0),
+		})
+	}
+	return available, nil
+}
+
+// countReservations counts the live places taken in the slot on date: those
+// of orders and holds that have not expired. The hold of exceptCart, if
+// given, is left out.
+func countReservations(db DBInterface, slotID uuid.UUID, date string, exceptCart *uuid.UUID, now time.Time) (int, error) {
+	builder := QB.Select("COUNT(*)").
+		From("delivery_slot_reservations").
+		Where(squirrel.Eq{"slot_id": slotID, "slot_date": date}).
+		Where(squirrel.Or{
+			squirrel.NotEq{"order_id": nil},
+			squirrel.Gt{"expires_at": now},
+		})
+	if exceptCart != nil {
+		builder = builder.Where("cart_id IS DISTINCT FROM ?", *exceptCart)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("error creating query: %v", err)
+	}
+
+	var count int
+	err = db.Get(&count, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error counting slot reservations: %v", err)
+	}
+	return count, nil
+}
+
+// reserveSlot checks that the store's slot can be booked on the chosen date
+// and has a place left, not counting the cart's own hold. The slot row is
+// locked so concurrent reservations are counted one at a time; tx must be a
+// transaction.
+func reserveSlot(tx DBInterface, storeID, cartID uuid.UUID, choice SlotChoice, now time.Time) (*DeliverySlot, time.Time, error) {
+	slotDB := &DeliverySlotDB{db: tx}
+	slot, err := slotDB.get(choice.SlotID, "FOR UPDATE")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if slot.StoreID != storeID {
+		return nil, time.Time{}, ErrDeliverySlotNotFound
+	}
+
+	start, err := slot.bookable(choice.Date, now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	reserved, err := countReservations(tx, slot.ID, choice.Date, &cartID, now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if reserved >= slot.Capacity {
+		return nil, time.Time{}, ErrDeliverySlotFull
+	}
+
+	return slot, start, nil
+}
+
+func deleteCartHold(tx DBInterface, cartID uuid.UUID) error {
+	query, args, err := QB.Delete("delivery_slot_reservations").Where(squirrel.Eq{"cart_id": cartID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error releasing slot hold: %v", err)
+	}
+	return nil
+}
+
+// Hold keeps a place in the chosen slot for the cart until now+ttl, replacing
+// any place the cart held before.
+func (d *DeliverySlotDB) Hold(cart *Cart, choice SlotChoice, now time.Time, ttl time.Duration) (*SlotReservation, error) {
+	if cart.StoreID == nil {
+		return nil, ErrDeliverySlotNotFound
+	}
+
+	tx, err := d.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, _, err = reserveSlot(tx, *cart.StoreID, cart.ID, choice, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteCartHold(tx, cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(ttl)
+	reservation := &SlotReservation{
+		ID:        uuid.New(),
+		SlotID:    choice.SlotID,
+		Date:      choice.Date,
+		CartID:    &cart.ID,
+		ExpiresAt: &expiresAt,
+		CreatedAt: now,
+	}
+	query, args, err := QB.Insert("delivery_slot_reservations").
+		Columns("id", "slot_id", "slot_date", "cart_id", "expires_at", "created_at").
+		Values(reservation.ID, reservation.SlotID, reservation.Date, reservation.CartID, reservation.ExpiresAt, reservation.CreatedAt).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error holding delivery slot: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return reservation, nil
+}
+
+// ReleaseHold gives up the place the cart holds, if any.
+func (d *DeliverySlotDB) ReleaseHold(cartID uuid.UUID) error {
+	return deleteCartHold(d.db, cartID)
+}
+
+// HeldSlot returns the slot the cart holds a live place in, or nil.
+func (d *DeliverySlotDB) HeldSlot(cartID uuid.UUID, now time.Time) (*SlotChoice, error) {
+	var choice SlotChoice
+	query, args, err := QB.Select("slot_id", "to_char(slot_date, 'YYYY-MM-DD') AS slot_date").
+		From("delivery_slot_reservations").
+		Where(squirrel.Eq{"cart_id": cartID}).
+		Where(squirrel.Gt{"expires_at": now}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&choice, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting slot hold: %v", err)
+	}
+
+	return &choice, nil
+}
+
+// OpenCheckTime returns when the store must be open for the cart to be
+// ordered: the start of the slot it holds, or now when it holds none. This is
+// the time CreateFromCart checks opening hours at.
+func (d *DeliverySlotDB) OpenCheckTime(cartID uuid.UUID, now time.Time) (time.Time, error) {
+	choice, err := d.HeldSlot(cartID, now)
+	if err != nil || choice == nil {
+		return now, err
+	}
+
+	slot, err := d.get(choice.SlotID, "")
+	if err != nil {
+		return now, err
+	}
+	return slot.StartTime(choice.Date)
+}
+
+// ReleaseExpired deletes holds that expired before now and returns how many
+// there were.
+func (d *DeliverySlotDB) ReleaseExpired(now time.Time) (int64, error) {
+	query, args, err := QB.Delete("delivery_slot_reservations").
+		Where(squirrel.Lt{"expires_at": now}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error releasing expired slot holds: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// bookOrderSlot replaces the cart's hold with the order's reservation. It
+// must run in the checkout transaction, after reserveSlot and after the order
+// is inserted.
+func bookOrderSlot(tx DBInterface, order *Order, cartID uuid.UUID, choice SlotChoice) error {
+	err := deleteCartHold(tx, cartID)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := QB.Insert("delivery_slot_reservations").
+		Columns("id", "slot_id", "slot_date", "order_id", "created_at").
+		Values(uuid.New(), choice.SlotID, choice.Date, order.ID, time.Now()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error booking delivery slot: %v", err)
+	}
+	return nil
+}
+
+// releaseOrderSlot gives back the place a cancelled order took in its slot.
+func releaseOrderSlot(tx DBInterface, orderID uuid.UUID) error {
+	query, args, err := QB.Delete("delivery_slot_reservations").Where(squirrel.Eq{"order_id": orderID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error releasing delivery slot: %v", err)
+	}
+	return nil
+}
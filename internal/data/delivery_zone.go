@@ -0,0 +1,413 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"project/utils"
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DeliveryZone is a named area that stores can choose to deliver to. Stores
+// that serve at least one active zone deliver only inside their active zones,
+// instead of within their radius. Deactivating a zone therefore works like
+// deleting it until it is turned back on.
+type DeliveryZone struct {
+	ID       uuid.UUID       `db:"id" json:"id"`
+	Name     string          `db:"name" json:"name"`
+	Geometry json.RawMessage `db:"geometry" json:"geometry"`
+	// The bounding box of Geometry.
+	MinLatitude  float64   `db:"min_latitude" json:"-"`
+	MinLongitude float64   `db:"min_longitude" json:"-"`
+	MaxLatitude  float64   `db:"max_latitude" json:"-"`
+	MaxLongitude float64   `db:"max_longitude" json:"-"`
+	BaseFee      *Money    `db:"base_fee" json:"base_fee"`
+	PerKmFee     *Money    `db:"per_km_fee" json:"per_km_fee"`
+	IsActive     bool      `db:"is_active" json:"is_active"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type DeliveryZoneDB struct {
+	db DBInterface
+}
+
+var deliveryZoneColumns = []string{
+	"id", "name", "geometry", "min_latitude", "min_longitude", "max_latitude", "max_longitude",
+	"base_fee", "per_km_fee", "is_active", "created_at", "updated_at",
+}
+
+// geoJSONGeometry is the part of a GeoJSON object zones are read from. A
+// Feature carries its shape in Geometry; Polygon and MultiPolygon objects in
+// Coordinates.
+type geoJSONGeometry struct {
+	Type        string           `json:"type"`
+	Coordinates json.RawMessage  `json:"coordinates,omitempty"`
+	Geometry    *geoJSONGeometry `json:"geometry,omitempty"`
+}
+
+// ParseZoneGeometry reads a GeoJSON Polygon or MultiPolygon, or a Feature
+// holding one, into polygons. Rings that are not closed are closed.
+func ParseZoneGeometry(raw []byte) ([]utils.Polygon, error) {
+	var geometry geoJSONGeometry
+	if err := json.Unmarshal(raw, &geometry); err != nil {
+		return nil, errors.New("المنطقة يجب أن تكون كائن GeoJSON صالحًا")
+	}
+	if geometry.Type == "Feature" {
+		if geometry.Geometry == nil {
+			return nil, errors.New("المنطقة يجب أن تحتوي على شكل هندسي")
+		}
+		geometry = *geometry.Geometry
+	}
+
+	var polygons []utils.Polygon
+	switch geometry.Type {
+	case "Polygon":
+		var polygon utils.Polygon
+		if err := json.Unmarshal(geometry.Coordinates, &polygon); err != nil {
+			return nil, errors.New("إحداثيات المضلع غير صالحة")
+		}
+		polygons = []utils.Polygon{polygon}
+	case "MultiPolygon":
+		if err := json.Unmarshal(geometry.Coordinates, &polygons); err != nil {
+			return nil, errors.New("إحداثيات المضلعات غير صالحة")
+		}
+	default:
+		return nil, errors.New("المنطقة يجب أن تكون من نوع Polygon أو MultiPolygon")
+	}
+
+	if len(polygons) == 0 {
+		return nil, errors.New("المنطقة يجب أن تحتوي على مضلع واحد على الأقل")
+	}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			return nil, errors.New("كل مضلع يجب أن يحتوي على حلقة خارجية")
+		}
+		for i, ring := range polygon {
+			if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+				ring = append(ring, ring[0])
+				polygon[i] = ring
+			}
+			if len(ring) < 4 {
+				return nil, errors.New("كل حلقة يجب أن تحتوي على ثلاث نقاط مختلفة على الأقل")
+			}
+			for _, position := range ring {
+				if position[1] < -90 || position[1] > 90 || position[0] < -180 || position[0] > 180 {
+					return nil, errors.New("إحداثيات المنطقة خارج النطاق المسموح")
+				}
+			}
+		}
+	}
+
+	return polygons, nil
+}
+
+// SetGeometry parses raw as zone geometry and stores it on the zone as a
+// MultiPolygon, along with its bounding box.
+func (z *DeliveryZone) SetGeometry(raw []byte) error {
+	polygons, err := ParseZoneGeometry(raw)
+	if err != nil {
+		return err
+	}
+
+	geometry, err := json.Marshal(map[string]interface{}{
+		"type":        "MultiPolygon",
+		"coordinates": polygons,
+	})
+	if err != nil {
+		return err
+	}
+	z.Geometry = geometry
+
+	z.MinLatitude, z.MinLongitude, z.MaxLatitude, z.MaxLongitude = polygons[0].Bounds()
+	for _, polygon := range polygons[1:] {
+		minLat, minLng, maxLat, maxLng := polygon.Bounds()
+		z.MinLatitude = min(z.MinLatitude, minLat)
+		z.MinLongitude = min(z.MinLongitude, minLng)
+		z.MaxLatitude = max(z.MaxLatitude, maxLat)
+		z.MaxLongitude = max(z.MaxLongitude, maxLng)
+	}
+	return nil
+}
+
+// Contains reports whether the point lies inside the zone.
+func (z *DeliveryZone) Contains(lat, lng float64) bool {
+	if lat < z.MinLatitude || lat > z.MaxLatitude || lng < z.MinLongitude || lng > z.MaxLongitude {
+		return false
+	}
+
+	polygons, err := ParseZoneGeometry(z.Geometry)
+	if err != nil {
+		return false
+	}
+	for _, polygon := range polygons {
+		if polygon.Contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTo replaces the fees of the store's settings with the zone's, where
+// the zone sets them. Zones take the place of the store's radius.
+func (z *DeliveryZone) applyTo(settings *StoreDeliverySettings) {
+	settings.RadiusKm = nil
+	if z.BaseFee != nil {
+		settings.BaseFee = *z.BaseFee
+	}
+	if z.PerKmFee != nil {
+		settings.PerKmFee = *z.PerKmFee
+	}
+}
+
+func ValidateDeliveryZone(v *validator.Validator, zone *DeliveryZone) {
+	v.Check(zone.Name != "", "name", "اسم المنطقة مطلوب")
+	v.Check(len(zone.Name) <= 100, "name", "يجب ألا يزيد اسم المنطقة عن 100 حرف")
+	v.Check(len(zone.Geometry) > 0, "geometry", "حدود المنطقة مطلوبة")
+	v.Check(zone.BaseFee == nil || !zone.BaseFee.IsNegative(), "base_fee", "يجب أن تكون رسوم التوصيل قيمة غير سالبة")
+	v.Check(zone.PerKmFee == nil || !zone.PerKmFee.IsNegative(), "per_km_fee", "يجب أن تكون رسوم الكيلومتر قيمة غير سالبة")
+}
+
+func (d *DeliveryZoneDB) Insert(zone *DeliveryZone) error {
+	zone.ID = uuid.New()
+	zone.CreatedAt = time.Now()
+	zone.UpdatedAt = zone.CreatedAt
+
+	query, args, err := QB.Insert("delivery_zones").
+		Columns(deliveryZoneColumns...).
+		Values(zone.ID, zone.Name, string(zone.Geometry), zone.MinLatitude, zone.MinLongitude,
+			zone.MaxLatitude, zone.MaxLongitude, zone.BaseFee, zone.PerKmFee, zone.IsActive,
+			zone.CreatedAt, zone.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateDeliveryZone
+		}
+		return fmt.Errorf("error inserting delivery zone: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DeliveryZoneDB) Get(id uuid.UUID) (*DeliveryZone, error) {
+	var zone DeliveryZone
+	query, args, err := QB.Select(deliveryZoneColumns...).
+		From("delivery_zones").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&zone, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDeliveryZoneNotFound
+		}
+		return nil, fmt.Errorf("error getting delivery zone: %v", err)
+	}
+
+	return &zone, nil
+}
+
+func (d *DeliveryZoneDB) Update(zone *DeliveryZone) error {
+	zone.UpdatedAt = time.Now()
+	query, args, err := QB.Update("delivery_zones").
+		SetMap(map[string]interface{}{
+			"name":          zone.Name,
+			"geometry":      string(zone.Geometry),
+			"min_latitude":  zone.MinLatitude,
+			"min_longitude": zone.MinLongitude,
+			"max_latitude":  zone.MaxLatitude,
+			"max_longitude": zone.MaxLongitude,
+			"base_fee":      zone.BaseFee,
+			"per_km_fee":    zone.PerKmFee,
+			"is_active":     zone.IsActive,
+			"updated_at":    zone.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": zone.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateDeliveryZone
+		}
+		return fmt.Errorf("error updating delivery zone: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the zone. Stores that served only this zone go back to
+// delivering within their radius.
+func (d *DeliveryZoneDB) Delete(id uuid.UUID) error {
+	query, args, err := QB.Delete("delivery_zones").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting delivery zone: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrDeliveryZoneNotFound
+	}
+
+	return nil
+}
+
+func (d *DeliveryZoneDB) List(queryParams url.Values) ([]DeliveryZone, *utils.Meta, error) {
+	zones := []DeliveryZone{}
+	meta, err := utils.BuildQuery(&zones, "delivery_zones", nil, deliveryZoneColumns, []string{"name"}, queryParams, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list delivery zones: %v", err)
+	}
+	return zones, meta, nil
+}
+
+// ListByStore returns the zones the store serves, ordered by name, inactive
+// ones included.
+func (d *DeliveryZoneDB) ListByStore(storeID uuid.UUID) ([]DeliveryZone, error) {
+	return d.listByStore(storeID, false)
+}
+
+// ListActiveByStore returns the active zones the store serves, ordered by
+// name. The store delivers within its radius when there are none.
+func (d *DeliveryZoneDB) ListActiveByStore(storeID uuid.UUID) ([]DeliveryZone, error) {
+	return d.listByStore(storeID, true)
+}
+
+func (d *DeliveryZoneDB) listByStore(storeID uuid.UUID, activeOnly bool) ([]DeliveryZone, error) {
+	columns := make([]string, len(deliveryZoneColumns))
+	for i, column := range deliveryZoneColumns {
+		columns[i] = "delivery_zones." + column
+	}
+
+	builder := QB.Select(columns...).
+		From("delivery_zones").
+		Join("store_delivery_zones ON store_delivery_zones.zone_id = delivery_zones.id").
+		Where(squirrel.Eq{"store_delivery_zones.store_id": storeID}).
+		OrderBy("delivery_zones.name")
+	if activeOnly {
+		builder = builder.Where(squirrel.Eq{"delivery_zones.is_active": true})
+	}
+
+	zones := []DeliveryZone{}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&zones, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing store delivery zones: %v", err)
+	}
+
+	return zones, nil
+}
+
+// SetStoreZones replaces the zones the store serves. An empty list makes the
+// store deliver within its radius again.
+func (d *DeliveryZoneDB) SetStoreZones(storeID uuid.UUID, zoneIDs []uuid.UUID) error {
+	tx, err := d.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Delete("store_delivery_zones").Where(squirrel.Eq{"store_id": storeID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error clearing store delivery zones: %v", err)
+	}
+
+	if len(zoneIDs) > 0 {
+		now := time.Now()
+		insert := QB.Insert("store_delivery_zones").
+			Columns("store_id", "zone_id", "created_at").
+			Suffix("ON CONFLICT DO NOTHING")
+		for _, zoneID := range zoneIDs {
+			insert = insert.Values(storeID, zoneID, now)
+		}
+		query, args, err = insert.ToSql()
+		if err != nil {
+			return fmt.Errorf("error creating query: %v", err)
+		}
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return ErrDeliveryZoneNotFound
+			}
+			return fmt.Errorf("error adding store delivery zones: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ContainingIDs returns the IDs of the active zones that contain the point.
+func (d *DeliveryZoneDB) ContainingIDs(point DeliveryPoint) ([]uuid.UUID, error) {
+	var candidates []DeliveryZone
+	query, args, err := QB.Select(deliveryZoneColumns...).
+		From("delivery_zones").
+		Where(squirrel.Eq{"is_active": true}).
+		Where(squirrel.LtOrEq{"min_latitude": point.Latitude, "min_longitude": point.Longitude}).
+		Where(squirrel.GtOrEq{"max_latitude": point.Latitude, "max_longitude": point.Longitude}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&candidates, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error finding delivery zones: %v", err)
+	}
+
+	ids := []uuid.UUID{}
+	for i := range candidates {
+		if candidates[i].Contains(point.Latitude, point.Longitude) {
+			ids = append(ids, candidates[i].ID)
+		}
+	}
+	return ids, nil
+}
+
+// zoneFor returns the first active zone, in name order, that contains the
+// point, or nil if there is none.
+func zoneFor(zones []DeliveryZone, point DeliveryPoint) *DeliveryZone {
+	for i := range zones {
+		if zones[i].IsActive && zones[i].Contains(point.Latitude, point.Longitude) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"project/internal/events"
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Driver is the delivery profile of a user holding the "delivery" role.
+type Driver struct {
+	UserID             uuid.UUID  `db:"user_id" json:"user_id"`
+	VehicleType        string     `db:"vehicle_type" json:"vehicle_type"`
+	VehiclePlate       *string    `db:"vehicle_plate" json:"vehicle_plate,omitempty"`
+	IsOnline           bool       `db:"is_online" json:"is_online"`
+	Latitude           *float64   `db:"latitude" json:"latitude,omitempty"`
+	Longitude          *float64   `db:"longitude" json:"longitude,omitempty"`
+	LocationUpdatedAt  *time.Time `db:"location_updated_at" json:"location_updated_at,omitempty"`
+	CourierRatingAvg   float64    `db:"courier_rating_avg" json:"courier_rating_avg"`
+	CourierRatingCount int        `db:"courier_rating_count" json:"courier_rating_count"`
+	CreatedAt          time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+type DriverDB struct {
+	db DBInterface
+}
+
+// DriverLocationPayload is the body of OrderDriverLocation events.
+type DriverLocationPayload struct {
+	DriverID  uuid.UUID `json:"driver_id"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+}
+
+var driverColumns = []string{
+	"user_id", "vehicle_type", "vehicle_plate", "is_online",
+	"latitude", "longitude", "location_updated_at", "courier_rating_avg", "courier_rating_count",
+	"created_at", "updated_at",
+}
+
+func ValidateDriver(v *validator.Validator, driver *Driver) {
+	v.Check(driver.UserID != uuid.Nil, "user_id", "يجب إدخال معرف المستخدم")
+	v.Check(validator.In(driver.VehicleType, "motorcycle", "car", "bicycle", "van"), "vehicle_type", "نوع المركبة غير صالح")
+	if driver.VehiclePlate != nil {
+		v.Check(len(*driver.VehiclePlate) <= 20, "vehicle_plate", "يجب ألا يزيد رقم اللوحة عن 20 حرف")
+	}
+}
+
+func ValidateLocation(v *validator.Validator, latitude, longitude float64) {
+	v.Check(latitude >= -90 && latitude <= 90, "latitude", "خط العرض يجب أن يكون بين -90 و 90")
+	v.Check(longitude >= -180 && longitude <= 180, "longitude", "خط الطول يجب أن يكون بين -180 و 180")
+}
+
+// Upsert creates the driver profile or updates the vehicle details of an existing one.
+func (d *DriverDB) Upsert(driver *Driver) error {
+	now := time.Now()
+
+	query, args, err := QB.Insert("drivers").
+		Columns("user_id", "vehicle_type", "vehicle_plate", "created_at", "updated_at").
+		Values(driver.UserID, driver.VehicleType, driver.VehiclePlate, now, now).
+		Suffix(`ON CONFLICT (user_id) DO UPDATE SET
+			vehicle_type = EXCLUDED.vehicle_type,
+			vehicle_plate = EXCLUDED.vehicle_plate,
+			updated_at = EXCLUDED.updated_at
+			RETURNING is_online, latitude, longitude, location_updated_at, courier_rating_avg, courier_rating_count,
+			created_at, updated_at`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	return d.db.QueryRow(query, args...).Scan(&driver.IsOnline, &driver.Latitude, &driver.Longitude,
+		&driver.LocationUpdatedAt, &driver.CourierRatingAvg, &driver.CourierRatingCount, &driver.CreatedAt, &driver.UpdatedAt)
+}
+
+func (d *DriverDB) Get(userID uuid.UUID) (*Driver, error) {
+	var driver Driver
+	query, args, err := QB.Select(driverColumns...).From("drivers").Where(squirrel.Eq{"user_id": userID}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&driver, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDriverNotFound
+		}
+		return nil, fmt.Errorf("error getting driver: %v", err)
+	}
+
+	return &driver, nil
+}
+
+func (d *DriverDB) SetOnline(userID uuid.UUID, online bool) error {
+	query, args, err := QB.Update("drivers").
+		SetMap(map[string]interface{}{
+			"is_online":  online,
+			"updated_at": time.Now(),
+		}).
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating driver status: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrDriverNotFound
+	}
+
+	return nil
+}
+
+func (d *DriverDB) UpdateLocation(userID uuid.UUID, latitude, longitude float64) error {
+	now := time.Now()
+	query, args, err := QB.Update("drivers").
+		SetMap(map[string]interface{}{
+			"latitude":            latitude,
+			"longitude":           longitude,
+			"location_updated_at": now,
+			"updated_at":          now,
+		}).
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating driver location: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrDriverNotFound
+	}
+
+	return nil
+}
+
+// ReportOrderLocation stores the position of the driver delivering an order
+// and broadcasts it to everyone tracking the order, on every API instance.
+// A driver who no longer holds the order gets ErrAssignmentNotFound, and
+// nothing is stored or sent.
+func (d *DriverDB) ReportOrderLocation(driverID, orderID uuid.UUID, latitude, longitude float64) error {
+	tx, err := d.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Select("1").From("order_assignments").
+		Where(squirrel.Eq{
+			"order_id":  orderID,
+			"driver_id": driverID,
+			"status":    []string{AssignmentAccepted, AssignmentPickedUp},
+		}).
+		Prefix("SELECT EXISTS (").
+		Suffix(")").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	var holds bool
+	err = tx.Get(&holds, query, args...)
+	if err != nil {
+		return fmt.Errorf("error checking order assignment: %v", err)
+	}
+	if !holds {
+		return ErrAssignmentNotFound
+	}
+
+	driverDB := &DriverDB{db: tx}
+	err = driverDB.UpdateLocation(driverID, latitude, longitude)
+	if err != nil {
+		return err
+	}
+
+	err = events.Broadcast(tx, events.OrderDriverLocation, orderID, nil, DriverLocationPayload{
+		DriverID:  driverID,
+		Latitude:  latitude,
+		Longitude: longitude,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// ListAvailable returns online drivers with a location reported since
+// locatedSince who hold no live assignment and have not passed on the given
+// order since passedSince. A driver passes on an order by rejecting its offer
+// or letting it expire; one who did so by passedSince may be offered it
+// again.
+func (d *DriverDB) ListAvailable(orderID uuid.UUID, locatedSince, passedSince time.Time) ([]Driver, error) {
+	drivers := []Driver{}
+	query, args, err := QB.Select(driverColumns...).From("drivers d").
+		Where(squirrel.Eq{"d.is_online": true}).
+		Where(squirrel.NotEq{"d.latitude": nil, "d.longitude": nil}).
+		Where(squirrel.GtOrEq{"d.location_updated_at": locatedSince}).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM order_assignments oa
+			WHERE oa.driver_id = d.user_id
+			AND (oa.status IN ('offered', 'accepted', 'picked_up')
+				OR (oa.order_id = ? AND COALESCE(oa.responded_at, oa.expires_at, oa.offered_at) > ?))
+		)`, orderID, passedSince).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&drivers, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing available drivers: %v", err)
+	}
+
+	return drivers, nil
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey records the first request a client made under a key and,
+// once it finished, the response it got, so retries can be answered with it.
+type IdempotencyKey struct {
+	UserID       uuid.UUID  `db:"user_id" json:"user_id"`
+	Key          string     `db:"key" json:"key"`
+	Method       string     `db:"method" json:"method"`
+	Path         string     `db:"path" json:"path"`
+	RequestHash  string     `db:"request_hash" json:"request_hash"`
+	StatusCode   *int       `db:"status_code" json:"status_code"`
+	ResponseBody []byte     `db:"response_body" json:"-"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt  *time.Time `db:"completed_at" json:"completed_at"`
+}
+
+type IdempotencyKeyDB struct {
+	db DBInterface
+}
+
+var idempotencyKeyColumns = []string{
+	"user_id", "key", "method", "path", "request_hash", "status_code", "response_body", "created_at", "completed_at",
+}
+
+// Reserve claims key.Key for the user before the request runs. It returns nil
+// when the claim succeeded, or the record already stored under the key.
+//
+// Keys older than expiredBefore are forgotten. A claim whose request never
+// finished (the server died mid-request) may be taken over by a retry of the
+// same request once it is older than abandonedBefore.
+func (i *IdempotencyKeyDB) Reserve(key *IdempotencyKey, expiredBefore, abandonedBefore time.Time) (*IdempotencyKey, error) {
+	query, args, err := QB.Delete("idempotency_keys").
+		Where(squirrel.Eq{"user_id": key.UserID}).
+		Where(squirrel.Lt{"created_at": expiredBefore}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = i.db.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting expired idempotency keys: %v", err)
+	}
+
+	key.CreatedAt = time.Now()
+	query, args, err = QB.Insert("idempotency_keys").
+		Columns("user_id", "key", "method", "path", "request_hash", "created_at").
+		Values(key.UserID, key.Key, key.Method, key.Path, key.RequestHash, key.CreatedAt).
+		Suffix(`ON CONFLICT (user_id, key) DO UPDATE SET created_at = EXCLUDED.created_at
+			WHERE idempotency_keys.status_code IS NULL
+			AND idempotency_keys.request_hash = EXCLUDED.request_hash
+			AND idempotency_keys.created_at < ?
+			RETURNING user_id`, abandonedBefore).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	var userID uuid.UUID
+	err = i.db.QueryRow(query, args...).Scan(&userID)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error reserving idempotency key: %v", err)
+	}
+
+	var existing IdempotencyKey
+	query, args, err = QB.Select(idempotencyKeyColumns...).
+		From("idempotency_keys").
+		Where(squirrel.Eq{"user_id": key.UserID, "key": key.Key}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = i.db.Get(&existing, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting idempotency key: %v", err)
+	}
+
+	return &existing, nil
+}
+
+// Complete stores the response of the request that reserved the key.
+func (i *IdempotencyKeyDB) Complete(userID uuid.UUID, key string, statusCode int, body []byte) error {
+	query, args, err := QB.Update("idempotency_keys").
+		SetMap(map[string]interface{}{
+			"status_code":   statusCode,
+			"response_body": body,
+			"completed_at":  time.Now(),
+		}).
+		Where(squirrel.Eq{"user_id": userID, "key": key}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = i.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error completing idempotency key: %v", err)
+	}
+
+	return nil
+}
+
+// Release drops an unfinished reservation so the request can be retried.
+func (i *IdempotencyKeyDB) Release(userID uuid.UUID, key string) error {
+	query, args, err := QB.Delete("idempotency_keys").
+		Where(squirrel.Eq{"user_id": userID, "key": key}).
+		Where("status_code IS NULL").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = i.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %v", err)
+	}
+
+	return nil
+}
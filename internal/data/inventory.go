@@ -0,0 +1,101 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"project/internal/events"
+	"project/utils"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of inventory movement.
+const (
+	MovementSale         = "sale"
+	MovementCancellation = "cancellation"
+	MovementAdjustment   = "adjustment"
+	MovementImport       = "import"
+)
+
+// InventoryMovement is an entry of a product's stock ledger. Movements are
+// only ever appended, in the transaction that changes the stock, so the
+// ledger explains every stock level the product went through.
+type InventoryMovement struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	ProductID      uuid.UUID  `db:"product_id" json:"product_id"`
+	Type           string     `db:"type" json:"type"`
+	QuantityChange int        `db:"quantity_change" json:"quantity_change"`
+	QuantityAfter  int        `db:"quantity_after" json:"quantity_after"`
+	OrderID        *uuid.UUID `db:"order_id" json:"order_id,omitempty"`
+	ActorID        *uuid.UUID `db:"actor_id" json:"actor_id,omitempty"`
+	Note           *string    `db:"note" json:"note,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+}
+
+// StockChange is a stock level set by hand, recorded as an adjustment.
+type StockChange struct {
+	Quantity int
+	ActorID  uuid.UUID
+	Note     *string
+}
+
+type InventoryMovementDB struct {
+	db DBInterface
+}
+
+var inventoryMovementColumns = []string{
+	"id", "product_id", "type", "quantity_change", "quantity_after", "order_id", "actor_id", "note", "created_at",
+}
+
+// recordMovement appends a movement to its product's ledger. It must run in
+// the transaction that changed the stock.
+func recordMovement(tx DBInterface, movement *InventoryMovement) error {
+	movement.ID = uuid.New()
+	movement.CreatedAt = time.Now()
+
+	query, args, err := QB.Insert("inventory_movements").
+		Columns(inventoryMovementColumns...).
+		Values(movement.ID, movement.ProductID, movement.Type, movement.QuantityChange, movement.QuantityAfter,
+			movement.OrderID, movement.ActorID, movement.Note, movement.CreatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error recording inventory movement: %v", err)
+	}
+	return nil
+}
+
+// notifyLowStock warns the store owner with a ProductStockLow event when a
+// change took the product's stock from above its threshold to at or below it.
+func notifyLowStock(tx DBInterface, product *Product, before int) error {
+	if product.StockQuantity > product.LowStockThreshold || before <= product.LowStockThreshold {
+		return nil
+	}
+	return events.Write(tx, events.ProductStockLow, product.ID, &product.StoreID, StockLowPayload{
+		Product:   product,
+		Threshold: product.LowStockThreshold,
+	})
+}
+
+// ListByProduct returns the product's movements, newest first unless the
+// query asks for another order.
+func (m *InventoryMovementDB) ListByProduct(productID uuid.UUID, queryParams url.Values) ([]InventoryMovement, *utils.Meta, error) {
+	queryParams = cloneValues(queryParams)
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "-created_at")
+	}
+	additionalFilters := []string{fmt.Sprintf("product_id = '%s'", productID)}
+
+	movements := []InventoryMovement{}
+	meta, err := utils.BuildQuery(&movements, "inventory_movements", nil, inventoryMovementColumns, nil, queryParams, additionalFilters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list inventory movements: %v", err)
+	}
+	return movements, meta, nil
+}
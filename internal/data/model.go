@@ -41,6 +41,55 @@ var (
 	ErrInvalidDiscount             = errors.New("discount must be between 0 and product price")
 	ErrSubscriptionNotFound        = errors.New("نوع الاشتراك غير موجود")
 	ErrPhoneAlreadyInserted        = errors.New("رقم الهاتف مسجل مسبقاً")
+	ErrInvalidStatusTransition     = errors.New("لا يمكن نقل الطلب إلى هذه الحالة")
+	ErrStatusTransitionForbidden   = errors.New("غير مسموح لك بنقل الطلب إلى هذه الحالة")
+	ErrOrderNotCancellable         = errors.New("لا يمكن إلغاء الطلب في حالته الحالية")
+	ErrCancellationReasonRequired  = errors.New("يجب ذكر سبب الإلغاء")
+	ErrOrderNotEditable            = errors.New("لا يمكن تعديل تفاصيل التوصيل بعد بدء تجهيز الطلب")
+	ErrDriverNotFound              = errors.New("ملف السائق غير موجود")
+	ErrDriverOffline               = errors.New("السائق غير متصل حالياً")
+	ErrAssignmentNotFound          = errors.New("مهمة التوصيل غير موجودة")
+	ErrOrderAlreadyAssigned        = errors.New("الطلب مسند إلى سائق بالفعل")
+	ErrInvalidAssignmentState      = errors.New("لا يمكن تنفيذ هذا الإجراء على مهمة التوصيل في حالتها الحالية")
+	ErrOfferExpired                = errors.New("انتهت صلاحية عرض التوصيل")
+	ErrWebhookNotFound             = errors.New("الـ webhook غير موجود")
+	ErrWebhookDeliveryNotFound     = errors.New("سجل الإرسال غير موجود")
+	ErrIdempotencyKeyMismatch      = errors.New("مفتاح Idempotency-Key مستخدم مع طلب مختلف")
+	ErrIdempotencyKeyInUse         = errors.New("طلب آخر بنفس مفتاح Idempotency-Key قيد التنفيذ")
+	ErrCouponNotFound              = errors.New("الكوبون غير موجود")
+	ErrDuplicateCouponCode         = errors.New("رمز الكوبون مستخدم بالفعل")
+	ErrCouponNotActive             = errors.New("الكوبون غير فعال حالياً")
+	ErrCouponNotApplicable         = errors.New("الكوبون لا ينطبق على هذا المتجر")
+	ErrCouponMinBasket             = errors.New("قيمة السلة أقل من الحد الأدنى للكوبون")
+	ErrCouponUsageLimit            = errors.New("تم استنفاد عدد مرات استخدام الكوبون")
+	ErrCouponUserLimit             = errors.New("لقد استخدمت هذا الكوبون الحد الأقصى من المرات")
+	ErrOutsideDeliveryRadius       = errors.New("عنوان التوصيل خارج نطاق توصيل المتجر")
+	ErrBelowMinimumOrder           = errors.New("قيمة الطلب أقل من الحد الأدنى للمتجر")
+	ErrDeliveryLocationRequired    = errors.New("يجب تحديد موقع التوصيل لحساب رسوم التوصيل")
+	ErrOutsideDeliveryZone         = errors.New("عنوان التوصيل خارج مناطق توصيل المتجر")
+	ErrDeliveryZoneNotFound        = errors.New("منطقة التوصيل غير موجودة")
+	ErrDuplicateDeliveryZone       = errors.New("اسم منطقة التوصيل مستخدم بالفعل")
+	ErrStoreClosed                 = errors.New("المتجر مغلق حالياً")
+	ErrStoreHolidayNotFound        = errors.New("العطلة غير موجودة")
+	ErrDuplicateStoreHoliday       = errors.New("توجد عطلة مسجلة في هذا التاريخ بالفعل")
+	ErrDeliverySlotNotFound        = errors.New("فترة التوصيل غير موجودة")
+	ErrDeliverySlotUnavailable     = errors.New("فترة التوصيل غير متاحة في هذا التاريخ")
+	ErrDeliverySlotFull            = errors.New("فترة التوصيل ممتلئة")
+	ErrProductCategoryNotFound     = errors.New("التصنيف غير موجود")
+	ErrDuplicateProductCategory    = errors.New("يوجد تصنيف بنفس الاسم في هذا المستوى")
+	ErrInvalidCategoryParent       = errors.New("لا يمكن نقل التصنيف إلى أحد تصنيفاته الفرعية")
+	ErrOptionGroupNotFound         = errors.New("مجموعة الخيارات غير موجودة")
+	ErrProductOptionNotFound       = errors.New("الخيار غير موجود")
+	ErrProductOptionUnavailable    = errors.New("أحد الخيارات المختارة غير متوفر بالكمية المطلوبة")
+	ErrInvalidOptionChoice         = errors.New("الخيارات المختارة لأحد المنتجات لم تعد صالحة")
+	ErrDuplicateSKU                = errors.New("يوجد منتج بنفس الرمز في هذا المتجر")
+	ErrReviewNotFound              = errors.New("التقييم غير موجود")
+	ErrReviewNotAllowed            = errors.New("يمكنك تقييم المنتجات التي استلمتها فقط")
+	ErrDuplicateReview             = errors.New("لقد قمت بتقييم هذا المنتج لهذا الطلب بالفعل")
+	ErrOrderRatingNotFound         = errors.New("لم يتم تقييم الطلب بعد")
+	ErrOrderNotDelivered           = errors.New("لا يمكن تقييم الطلب قبل توصيله")
+	ErrDuplicateOrderRating        = errors.New("لقد قمت بتقييم هذا الطلب بالفعل")
+	ErrNoCourier                   = errors.New("لم يوصل هذا الطلب مندوب توصيل")
 
 	users_column = []string{
 		"id", "name", "email", "password", "phone_number",
@@ -58,47 +107,93 @@ var (
 	stores_columns = []string{
 		"id", "owner_id", "store_type_id", "name", "description", "contact_phone", "contact_email",
 		fmt.Sprintf("CASE WHEN NULLIF(image, '') IS NOT NULL THEN FORMAT('%s/%%s', image) ELSE NULL END AS image", Domain),
-		"address_text", "latitude", "longitude", "is_active",
+		"address_text", "latitude", "longitude", "is_active", "paused_until",
+		"rating_avg", "rating_count", "speed_rating_avg", "packaging_rating_avg", "courier_rating_avg", "order_rating_count",
 		"created_at", "updated_at",
 	}
 
-	products_columns = []string{
-		"id", "store_id", "name", "description", "price", "discount",
-		fmt.Sprintf("CASE WHEN NULLIF(image, '') IS NOT NULL THEN FORMAT('%s/%%s', image) ELSE NULL END AS image", Domain),
-		"stock_quantity", "is_available", "created_at", "updated_at",
-	}
+	products_columns = imageURLColumns(productTableColumns)
 
-	cartColumns = []string{"id", "user_id", "store_id", "created_at", "updated_at"}
+	cartColumns = []string{"id", "user_id", "store_id", "coupon_id", "created_at", "updated_at"}
 
 	cart_items_columns = []string{
 		"id", "cart_id", "product_id", "quantity", "created_at", "updated_at",
 	}
 )
 
+// imageURLColumns returns columns with the stored image file name replaced by
+// its full URL, under the same name.
+func imageURLColumns(columns []string) []string {
+	withURL := make([]string, len(columns))
+	for i, column := range columns {
+		if column == "image" {
+			column = fmt.Sprintf("CASE WHEN NULLIF(image, '') IS NOT NULL THEN FORMAT('%s/%%s', image) ELSE NULL END AS image", Domain)
+		}
+		withURL[i] = column
+	}
+	return withURL
+}
+
 type Model struct {
-	db          *sqlx.DB
-	UserDB      UserDB
-	UserRoleDB  UserRoleDB
-	StoreTypeDB StoreTypeDB
-	StoreDB     StoreDB
-	ProductDB   ProductDB
-	CartDB      CartDB
-	CartItemDB  CartItemDB
-	OrderDB     OrderDB
-	OrderItemDB OrderItemDB
+	db                      *sqlx.DB
+	UserDB                  UserDB
+	UserRoleDB              UserRoleDB
+	StoreTypeDB             StoreTypeDB
+	StoreDB                 StoreDB
+	ProductDB               ProductDB
+	CartDB                  CartDB
+	CartItemDB              CartItemDB
+	OrderDB                 OrderDB
+	OrderItemDB             OrderItemDB
+	OrderStatusHistoryDB    OrderStatusHistoryDB
+	DriverDB                DriverDB
+	OrderAssignmentDB       OrderAssignmentDB
+	WebhookDB               WebhookDB
+	WebhookDeliveryDB       WebhookDeliveryDB
+	IdempotencyKeyDB        IdempotencyKeyDB
+	CouponDB                CouponDB
+	OrderDiscountDB         OrderDiscountDB
+	StoreDeliverySettingsDB StoreDeliverySettingsDB
+	DeliveryZoneDB          DeliveryZoneDB
+	StoreHoursDB            StoreHoursDB
+	DeliverySlotDB          DeliverySlotDB
+	SearchDB                SearchDB
+	ProductCategoryDB       ProductCategoryDB
+	ProductOptionDB         ProductOptionDB
+	InventoryMovementDB     InventoryMovementDB
+	ProductReviewDB         ProductReviewDB
+	OrderRatingDB           OrderRatingDB
 }
 
 func NewModels(db *sqlx.DB) Model {
 	return Model{
-		db:          db,
-		UserDB:      UserDB{db},
-		UserRoleDB:  UserRoleDB{db},
-		StoreTypeDB: StoreTypeDB{db},
-		StoreDB:     StoreDB{db},
-		ProductDB:   ProductDB{db},
-		CartDB:      CartDB{db},
-		CartItemDB:  CartItemDB{db},
-		OrderDB:     OrderDB{db},
-		OrderItemDB: OrderItemDB{db},
+		db:                      db,
+		UserDB:                  UserDB{db},
+		UserRoleDB:              UserRoleDB{db},
+		StoreTypeDB:             StoreTypeDB{db},
+		StoreDB:                 StoreDB{db},
+		ProductDB:               ProductDB{db},
+		CartDB:                  CartDB{db},
+		CartItemDB:              CartItemDB{db},
+		OrderDB:                 OrderDB{db},
+		OrderItemDB:             OrderItemDB{db},
+		OrderStatusHistoryDB:    OrderStatusHistoryDB{db},
+		DriverDB:                DriverDB{db},
+		OrderAssignmentDB:       OrderAssignmentDB{db},
+		WebhookDB:               WebhookDB{db},
+		WebhookDeliveryDB:       WebhookDeliveryDB{db},
+		IdempotencyKeyDB:        IdempotencyKeyDB{db},
+		CouponDB:                CouponDB{db},
+		OrderDiscountDB:         OrderDiscountDB{db},
+		StoreDeliverySettingsDB: StoreDeliverySettingsDB{db},
+		DeliveryZoneDB:          DeliveryZoneDB{db},
+		StoreHoursDB:            StoreHoursDB{db},
+		DeliverySlotDB:          DeliverySlotDB{db},
+		SearchDB:                SearchDB{db},
+		ProductCategoryDB:       ProductCategoryDB{db},
+		ProductOptionDB:         ProductOptionDB{db},
+		InventoryMovementDB:     InventoryMovementDB{db},
+		ProductReviewDB:         ProductReviewDB{db},
+		OrderRatingDB:           OrderRatingDB{db},
 	}
 }
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of every amount stored by the API.
+const DefaultCurrency = "LYD"
+
+// moneyScale is the number of minor units in one major unit. It follows the
+// NUMERIC(10,2) price columns rather than the currency's own subdivision.
+const moneyScale = 100
+
+var errInvalidMoney = errors.New("invalid money amount")
+
+// Money is an exact amount in minor units (hundredths) of a currency.
+// Arithmetic never goes through floating point; the few operations that can
+// produce fractions of a minor unit round half away from zero, which is also
+// how Postgres rounds into a NUMERIC column.
+//
+// Amounts are stored in NUMERIC columns and encoded in JSON as plain
+// numbers, e.g. 12.50; the currency is implied. Add, Sub, Cmp and Min panic
+// when given amounts of two different currencies, since mixing them is a
+// programming error; the zero-value currency goes with any other.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns an amount of the default currency from minor units.
+func NewMoney(minor int64) Money {
+	return Money{Amount: minor, Currency: DefaultCurrency}
+}
+
+// ParseMoney parses a decimal amount such as "30", "40.5" or "-1.25".
+// Digits beyond the second decimal place are rounded.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, errInvalidMoney
+	}
+	for _, part := range []string{whole, fraction} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return Money{}, errInvalidMoney
+			}
+		}
+	}
+
+	var amount int64
+	if whole != "" {
+		units, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil || units > (1<<63-1)/moneyScale-1 {
+			return Money{}, errInvalidMoney
+		}
+		amount = units * moneyScale
+	}
+
+	fraction += "000"
+	cents, _ := strconv.ParseInt(fraction[:2], 10, 64)
+	amount += cents
+	if fraction[2] >= '5' {
+		amount++
+	}
+
+	if negative {
+		amount = -amount
+	}
+	return NewMoney(amount), nil
+}
+
+// Add returns m + other.
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.sameCurrency(other)}
+}
+
+// Sub returns m - other.
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.sameCurrency(other)}
+}
+
+// Mul returns m multiplied by a quantity.
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.currency()}
+}
+
+// MulFraction returns m * numerator / denominator, rounded to the nearest
+// minor unit. It is meant for percentages, e.g. MulFraction(15, 100).
+func (m Money) MulFraction(numerator, denominator int64) Money {
+	product := m.Amount * numerator
+	quotient, remainder := product/denominator, product%denominator
+	if remainder < 0 {
+		remainder = -remainder
+	}
+	if 2*remainder >= abs64(denominator) {
+		if (product < 0) != (denominator < 0) {
+			quotient--
+		} else {
+			quotient++
+		}
+	}
+	return Money{Amount: quotient, Currency: m.currency()}
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or
+// greater than other.
+func (m Money) Cmp(other Money) int {
+	m.sameCurrency(other)
+	switch {
+	case m.Amount < other.Amount:
+		return -1
+	case m.Amount > other.Amount:
+		return 1
+	}
+	return 0
+}
+
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// Min returns the smaller of m and other.
+func (m Money) Min(other Money) Money {
+	m.sameCurrency(other)
+	if other.Amount < m.Amount {
+		return other
+	}
+	return m
+}
+
+// String formats the amount with two decimals, e.g. "12.50".
+func (m Money) String() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/moneyScale, amount%moneyScale)
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+// sameCurrency returns the currency shared by m and other, panicking when
+// they differ. An empty currency matches any other.
+func (m Money) sameCurrency(other Money) string {
+	switch {
+	case m.Currency == "":
+		if other.Currency == "" {
+			return DefaultCurrency
+		}
+		return other.Currency
+	case other.Currency == "" || other.Currency == m.Currency:
+		return m.Currency
+	}
+	panic(fmt.Sprintf("money: currency mismatch: %s and %s", m.Currency, other.Currency))
+}
+
+// Value stores the amount as a decimal string so NUMERIC columns receive it
+// exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads a NUMERIC column.
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*m = NewMoney(v * moneyScale)
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		*m = NewMoney(0)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %v", s, err)
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a numeric string.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
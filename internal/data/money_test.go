@@ -0,0 +1,143 @@
+package data
+
+import "testing"
+
+func TestParseMoneyRounding(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"30", 3000},
+		{"40.5", 4050},
+		{"0.125", 13},
+		{"0.124", 12},
+		{"0.135", 14},
+		{"2.675", 268},
+		{"0.005", 1},
+		{"0.0049", 0},
+		{"-0.125", -13},
+		{"-0.124", -12},
+		{"-0.005", -1},
+		{"-1.25", -125},
+		{"+1.995", 200},
+		{".5", 50},
+		{"7.", 700},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): unexpected error %v", tt.in, err)
+			continue
+		}
+		if got.Amount != tt.want || got.Currency != DefaultCurrency {
+			t.Errorf("ParseMoney(%q) = %d %s, want %d %s", tt.in, got.Amount, got.Currency, tt.want, DefaultCurrency)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, in := range []string{"", "-", ".", "abc", "1.2.3", "1,5", "1e3", "--1", "99999999999999999999"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", in)
+		}
+	}
+}
+
+func TestMoneyMulFractionRounding(t *testing.T) {
+	tests := []struct {
+		amount      int64
+		num, den    int64
+		want        int64
+		description string
+	}{
+		{1000, 15, 100, 150, "exact"},
+		{1, 1, 2, 1, "half rounds up"},
+		{-1, 1, 2, -1, "negative half rounds away from zero"},
+		{1, -1, 2, -1, "negative numerator half rounds away from zero"},
+		{-1, -1, 2, 1, "two negatives give a positive half"},
+		{3, 1, 2, 2, "1.5 rounds to 2"},
+		{-3, 1, 2, -2, "-1.5 rounds to -2"},
+		{5, 1, 2, 3, "2.5 rounds to 3"},
+		{-5, 1, 2, -3, "-2.5 rounds to -3"},
+		{1, 1, 3, 0, "a third rounds down"},
+		{2, 1, 3, 1, "two thirds round up"},
+		{-2, 1, 3, -1, "minus two thirds round down"},
+		{1999, 15, 100, 300, "299.85 rounds to 300"},
+		{1234, 333, 1000, 411, "410.922 rounds to 411"},
+		{2500, 1, -2, -1250, "negative denominator"},
+		{1, 499, 1000, 0, "just under half"},
+		{1, 501, 1000, 1, "just over half"},
+		{-1, 499, 1000, 0, "just under minus half"},
+		{-1, 501, 1000, -1, "just over minus half"},
+	}
+	for _, tt := range tests {
+		got := NewMoney(tt.amount).MulFraction(tt.num, tt.den)
+		if got.Amount != tt.want {
+			t.Errorf("%s: %d*%d/%d = %d, want %d", tt.description, tt.amount, tt.num, tt.den, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMoneyManyLineTotals(t *testing.T) {
+	price, err := ParseMoney("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.1 added 1000 times drifts away from 100 in float64.
+	total := NewMoney(0)
+	for i := 0; i < 1000; i++ {
+		total = total.Add(price.Mul(1))
+	}
+	if total.Amount != 10000 || total.String() != "100.00" {
+		t.Errorf("sum of 1000 lines of 0.1 = %s (%d), want 100.00", total, total.Amount)
+	}
+
+	if got := price.Mul(1000); got.Amount != 10000 {
+		t.Errorf("0.1 * 1000 = %s, want 100.00", got)
+	}
+
+	// Line totals with a per-unit discount, as pricing builds them.
+	unitPrice, _ := ParseMoney("19.99")
+	unitDiscount, _ := ParseMoney("0.33")
+	total = NewMoney(0)
+	for quantity := 1; quantity <= 500; quantity++ {
+		total = total.Add(unitPrice.Sub(unitDiscount).Mul(quantity))
+	}
+	// (1999-33) * (500*501/2) minor units.
+	if want := int64(1966 * 125250); total.Amount != want {
+		t.Errorf("sum of discounted lines = %d, want %d", total.Amount, want)
+	}
+}
+
+func TestMoneyCurrencyMismatch(t *testing.T) {
+	lyd := NewMoney(100)
+	usd := Money{Amount: 100, Currency: "USD"}
+
+	for name, op := range map[string]func(){
+		"Add": func() { lyd.Add(usd) },
+		"Sub": func() { lyd.Sub(usd) },
+		"Cmp": func() { lyd.Cmp(usd) },
+		"Min": func() { lyd.Min(usd) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of LYD and USD did not panic", name)
+				}
+			}()
+			op()
+		}()
+	}
+
+	zero := Money{Amount: 50}
+	if got := zero.Add(usd); got.Amount != 150 || got.Currency != "USD" {
+		t.Errorf("zero-currency Add = %d %s, want 150 USD", got.Amount, got.Currency)
+	}
+	if got := lyd.Sub(zero); got.Amount != 50 || got.Currency != DefaultCurrency {
+		t.Errorf("Sub of zero currency = %d %s, want 50 %s", got.Amount, got.Currency, DefaultCurrency)
+	}
+	if lyd.Cmp(zero) != 1 {
+		t.Error("Cmp with zero currency should compare amounts")
+	}
+}
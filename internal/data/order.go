@@ -2,11 +2,15 @@ package data
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
+	"strings"
 	"time"
 
+	"project/internal/events"
 	"project/utils"
 	"project/utils/validator"
 
@@ -16,17 +20,28 @@ import (
 )
 
 type Order struct {
-	ID                uuid.UUID `db:"id" json:"id"`
-	UserID            uuid.UUID `db:"user_id" json:"user_id"`
-	StoreID           uuid.UUID `db:"store_id" json:"store_id"`
-	TotalPrice        float64   `db:"total_price" json:"total_price"`
-	Status            string    `db:"status" json:"status"`
-	DeliveryAddress   string    `db:"delivery_address" json:"delivery_address"`
-	DeliveryLatitude  *float64  `db:"delivery_latitude" json:"delivery_latitude,omitempty"`
-	DeliveryLongitude *float64  `db:"delivery_longitude" json:"delivery_longitude,omitempty"`
-	DeliveryNotes     *string   `db:"delivery_notes" json:"delivery_notes,omitempty"`
-	CreatedAt         time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
+	ID                 uuid.UUID `db:"id" json:"id"`
+	UserID             uuid.UUID `db:"user_id" json:"user_id"`
+	StoreID            uuid.UUID `db:"store_id" json:"store_id"`
+	TotalPrice         Money     `db:"total_price" json:"total_price"`
+	DiscountTotal      Money     `db:"discount_total" json:"discount_total"`
+	DeliveryFee        Money     `db:"delivery_fee" json:"delivery_fee"`
+	DeliveryDistanceKm *float64  `db:"delivery_distance_km" json:"delivery_distance_km,omitempty"`
+	Status             string    `db:"status" json:"status"`
+	DeliveryAddress    string    `db:"delivery_address" json:"delivery_address"`
+	DeliveryLatitude   *float64  `db:"delivery_latitude" json:"delivery_latitude,omitempty"`
+	DeliveryLongitude  *float64  `db:"delivery_longitude" json:"delivery_longitude,omitempty"`
+	DeliveryNotes      *string   `db:"delivery_notes" json:"delivery_notes,omitempty"`
+	// ScheduledFor is when a scheduled order's delivery slot starts; orders
+	// for delivery as soon as possible have none.
+	ScheduledFor   *time.Time `db:"scheduled_for" json:"scheduled_for,omitempty"`
+	DeliverySlotID *uuid.UUID `db:"delivery_slot_id" json:"delivery_slot_id,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at" json:"updated_at"`
+
+	CancellationReason *string    `db:"cancellation_reason" json:"cancellation_reason,omitempty"`
+	CancelledBy        *string    `db:"cancelled_by" json:"cancelled_by,omitempty"`
+	CancelledAt        *time.Time `db:"cancelled_at" json:"cancelled_at,omitempty"`
 }
 
 type OrderDB struct {
@@ -34,17 +49,92 @@ type OrderDB struct {
 }
 
 var orderColumns = []string{
-	"id", "user_id", "store_id", "total_price", "status",
+	"id", "user_id", "store_id", "total_price", "discount_total", "delivery_fee", "delivery_distance_km", "status",
 	"delivery_address", "delivery_latitude", "delivery_longitude", "delivery_notes",
+	"scheduled_for", "delivery_slot_id",
 	"created_at", "updated_at",
+	"cancellation_reason", "cancelled_by", "cancelled_at",
+}
+
+const (
+	OrderStatusPending    = "pending"
+	OrderStatusProcessing = "processing"
+	OrderStatusReady      = "ready"
+	OrderStatusShipped    = "shipped"
+	OrderStatusDelivered  = "delivered"
+	OrderStatusCancelled  = "cancelled"
+)
+
+var OrderStatuses = []string{
+	OrderStatusPending, OrderStatusProcessing, OrderStatusReady, OrderStatusShipped,
+	OrderStatusDelivered, OrderStatusCancelled,
+}
+
+// OrderActor is the capacity in which a user changes an order's status.
+type OrderActor string
+
+const (
+	ActorCustomer   OrderActor = "customer"
+	ActorStoreOwner OrderActor = "store_owner"
+	ActorDelivery   OrderActor = "delivery"
+	ActorAdmin      OrderActor = "admin"
+)
+
+// orderTransitions maps each status to the statuses it may move to, and for
+// every move the actors allowed to make it. Statuses missing from the map
+// (delivered, cancelled) are final.
+var orderTransitions = map[string]map[string][]OrderActor{
+	OrderStatusPending: {
+		OrderStatusProcessing: {ActorStoreOwner, ActorAdmin},
+		OrderStatusCancelled:  {ActorCustomer, ActorStoreOwner, ActorAdmin},
+	},
+	OrderStatusProcessing: {
+		OrderStatusReady:     {ActorStoreOwner, ActorAdmin},
+		OrderStatusShipped:   {ActorStoreOwner, ActorDelivery, ActorAdmin},
+		OrderStatusCancelled: {ActorStoreOwner, ActorAdmin},
+	},
+	OrderStatusReady: {
+		OrderStatusShipped:   {ActorStoreOwner, ActorDelivery, ActorAdmin},
+		OrderStatusCancelled: {ActorStoreOwner, ActorAdmin},
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered: {ActorStoreOwner, ActorDelivery, ActorAdmin},
+	},
+}
+
+// CanTransition reports whether actor may move an order from one status to
+// another. It returns ErrInvalidStatusTransition when the move does not exist
+// at all and ErrStatusTransitionForbidden when it exists for other actors only.
+func CanTransition(from, to string, actor OrderActor) error {
+	actors, ok := orderTransitions[from][to]
+	if !ok {
+		return ErrInvalidStatusTransition
+	}
+	for _, a := range actors {
+		if a == actor {
+			return nil
+		}
+	}
+	return ErrStatusTransitionForbidden
+}
+
+// NextStatuses lists the statuses actor may move an order to from its current status.
+func NextStatuses(from string, actor OrderActor) []string {
+	next := []string{}
+	for _, status := range OrderStatuses {
+		if CanTransition(from, status, actor) == nil {
+			next = append(next, status)
+		}
+	}
+	return next
 }
 
 func ValidateOrder(v *validator.Validator, order *Order) {
 	v.Check(order.UserID != uuid.Nil, "user_id", "يجب إدخال معرف المستخدم")
 	v.Check(order.StoreID != uuid.Nil, "store_id", "يجب إدخال معرف المتجر")
-	v.Check(order.TotalPrice >= 0, "total_price", "يجب أن يكون السعر الإجمالي إيجابيًا")
+	v.Check(!order.TotalPrice.IsNegative(), "total_price", "يجب أن يكون السعر الإجمالي إيجابيًا")
 	v.Check(order.Status != "", "status", "يجب إدخال حالة الطلب")
-	v.Check(validator.In(order.Status, "pending", "processing", "shipped", "delivered", "cancelled"), "status", "حالة الطلب غير صالحة")
+	v.Check(validator.In(order.Status, OrderStatuses...), "status", "حالة الطلب غير صالحة")
 	v.Check(order.DeliveryAddress != "", "delivery_address", "يجب إدخال عنوان التوصيل")
 }
 
@@ -55,9 +145,12 @@ func (o *OrderDB) Insert(order *Order) error {
 
 	query, args, err := QB.Insert("orders").
 		Columns(orderColumns...).
-		Values(order.ID, order.UserID, order.StoreID, order.TotalPrice, order.Status,
+		Values(order.ID, order.UserID, order.StoreID, order.TotalPrice, order.DiscountTotal,
+			order.DeliveryFee, order.DeliveryDistanceKm, order.Status,
 			order.DeliveryAddress, order.DeliveryLatitude, order.DeliveryLongitude, order.DeliveryNotes,
-			order.CreatedAt, order.UpdatedAt).
+			order.ScheduledFor, order.DeliverySlotID,
+			order.CreatedAt, order.UpdatedAt,
+			order.CancellationReason, order.CancelledBy, order.CancelledAt).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
 	if err != nil {
@@ -77,7 +170,7 @@ func (o *OrderDB) Get(id uuid.UUID) (*Order, error) {
 	err = o.db.Get(&order, query, args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("order not found")
+			return nil, ErrOrderNotFound
 		}
 		return nil, fmt.Errorf("error getting order: %v", err)
 	}
@@ -85,17 +178,114 @@ func (o *OrderDB) Get(id uuid.UUID) (*Order, error) {
 	return &order, nil
 }
 
-func (o *OrderDB) Update(order *Order) error {
+// Edit applies changes to an order in one transaction: first the delivery
+// details already set on order, when detailsChanged, then a move to status
+// to, when it is not empty. Delivery details can only change while the order
+// is pending. Delivery to the new address is priced again and an address the
+// store does not deliver to is refused, as at checkout.
+func (o *OrderDB) Edit(order *Order, detailsChanged bool, to string, actorID uuid.UUID, actor OrderActor, reason *string) (*OrderStatusHistory, error) {
+	if to == OrderStatusCancelled && actor == ActorStoreOwner && (reason == nil || strings.TrimSpace(*reason) == "") {
+		return nil, ErrCancellationReasonRequired
+	}
+
+	tx, err := o.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if detailsChanged {
+		err = updateOrderDetails(tx, order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entry *OrderStatusHistory
+	if to != "" {
+		entry, err = transitionOrder(tx, order, to, actorID, actor, reason)
+		if err != nil {
+			if to == OrderStatusCancelled && errors.Is(err, ErrInvalidStatusTransition) {
+				return nil, ErrOrderNotCancellable
+			}
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return entry, nil
+}
+
+// Cancel cancels an order and puts its items back in stock. Customers may only
+// cancel orders the store has not started on; store owners may also cancel
+// orders in processing but must tell the customer why.
+func (o *OrderDB) Cancel(order *Order, actorID uuid.UUID, actor OrderActor, reason *string) (*OrderStatusHistory, error) {
+	return o.Edit(order, false, OrderStatusCancelled, actorID, actor, reason)
+}
+
+// updateOrderDetails saves the delivery details of a pending order inside an
+// existing transaction. The delivery fee is worked out again for the new
+// address from what the items cost, so the order total stays in step.
+func updateOrderDetails(tx DBInterface, order *Order) error {
+	var current struct {
+		Status        string `db:"status"`
+		TotalPrice    Money  `db:"total_price"`
+		DiscountTotal Money  `db:"discount_total"`
+		DeliveryFee   Money  `db:"delivery_fee"`
+	}
+	query, args, err := QB.Select("status", "total_price", "discount_total", "delivery_fee").From("orders").
+		Where(squirrel.Eq{"id": order.ID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = tx.Get(&current, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("error getting order: %v", err)
+	}
+	if current.Status != OrderStatusPending {
+		return ErrOrderNotEditable
+	}
+
+	store, err := getPricingStore(tx, order.StoreID)
+	if err != nil {
+		return err
+	}
+	quote := &Quote{
+		Subtotal:         current.TotalPrice.Sub(current.DeliveryFee).Add(current.DiscountTotal),
+		ProductDiscounts: NewMoney(0),
+		CouponDiscount:   current.DiscountTotal,
+		DeliveryFee:      NewMoney(0),
+	}
+	err = quote.applyDelivery(tx, store, order.deliveryPoint())
+	if err != nil {
+		return err
+	}
+
+	order.DeliveryFee = quote.DeliveryFee
+	order.DeliveryDistanceKm = quote.DistanceKm
+	order.TotalPrice = quote.GrandTotal
 	order.UpdatedAt = time.Now()
 
-	query, args, err := QB.Update("orders").
+	query, args, err = QB.Update("orders").
 		SetMap(map[string]interface{}{
-			"status":             order.Status,
-			"delivery_address":   order.DeliveryAddress,
-			"delivery_latitude":  order.DeliveryLatitude,
-			"delivery_longitude": order.DeliveryLongitude,
-			"delivery_notes":     order.DeliveryNotes,
-			"updated_at":         order.UpdatedAt,
+			"delivery_address":     order.DeliveryAddress,
+			"delivery_latitude":    order.DeliveryLatitude,
+			"delivery_longitude":   order.DeliveryLongitude,
+			"delivery_notes":       order.DeliveryNotes,
+			"delivery_fee":         order.DeliveryFee,
+			"delivery_distance_km": order.DeliveryDistanceKm,
+			"total_price":          order.TotalPrice,
+			"updated_at":           order.UpdatedAt,
 		}).
 		Where(squirrel.Eq{"id": order.ID}).
 		ToSql()
@@ -103,7 +293,7 @@ func (o *OrderDB) Update(order *Order) error {
 		return fmt.Errorf("error creating query: %v", err)
 	}
 
-	_, err = o.db.Exec(query, args...)
+	_, err = tx.Exec(query, args...)
 	if err != nil {
 		return fmt.Errorf("error updating order: %v", err)
 	}
@@ -111,6 +301,145 @@ func (o *OrderDB) Update(order *Order) error {
 	return nil
 }
 
+// deliveryPoint returns where the order goes, or nil when it has no coordinates.
+func (o *Order) deliveryPoint() *DeliveryPoint {
+	if o.DeliveryLatitude == nil || o.DeliveryLongitude == nil {
+		return nil
+	}
+	return &DeliveryPoint{Latitude: *o.DeliveryLatitude, Longitude: *o.DeliveryLongitude}
+}
+
+// transitionOrder performs a status change inside an existing transaction.
+// The order row is locked first so that concurrent changes are serialized
+// and always checked against the latest status.
+func transitionOrder(tx DBInterface, order *Order, to string, actorID uuid.UUID, actor OrderActor, reason *string) (*OrderStatusHistory, error) {
+	query, args, err := QB.Select("status").From("orders").
+		Where(squirrel.Eq{"id": order.ID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	var current string
+	err = tx.Get(&current, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order status: %v", err)
+	}
+
+	err = CanTransition(current, to, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	changes := map[string]interface{}{
+		"status":     to,
+		"updated_at": now,
+	}
+	if to == OrderStatusCancelled {
+		err = restockOrder(tx, order.ID, actorID)
+		if err != nil {
+			return nil, err
+		}
+		err = cancelOrderAssignments(tx, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = releaseOrderSlot(tx, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		changes["cancellation_reason"] = reason
+		changes["cancelled_by"] = string(actor)
+		changes["cancelled_at"] = now
+	}
+
+	query, args, err = QB.Update("orders").
+		SetMap(changes).
+		Where(squirrel.Eq{"id": order.ID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error updating order status: %v", err)
+	}
+
+	entry := &OrderStatusHistory{
+		OrderID:    order.ID,
+		FromStatus: &current,
+		ToStatus:   to,
+		ActorID:    &actorID,
+		ActorRole:  actor,
+		Reason:     reason,
+	}
+	historyDB := &OrderStatusHistoryDB{db: tx}
+	err = historyDB.Insert(entry)
+	if err != nil {
+		return nil, fmt.Errorf("error recording status history: %v", err)
+	}
+
+	updated := *order
+	updated.Status = to
+	updated.UpdatedAt = now
+	if to == OrderStatusCancelled {
+		cancelledBy := string(actor)
+		updated.CancellationReason = reason
+		updated.CancelledBy = &cancelledBy
+		updated.CancelledAt = &now
+	}
+
+	err = events.Write(tx, events.OrderStatusChanged, order.ID, &order.StoreID, OrderEventPayload{
+		Order:      &OrderWithItems{Order: updated},
+		FromStatus: &current,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	*order = updated
+	return entry, nil
+}
+
+// restockOrder returns the quantities of every item of an order to their
+// products, recording the cancellation in their inventory, and to their
+// options whose stock is tracked.
+func restockOrder(tx DBInterface, orderID, actorID uuid.UUID) error {
+	orderItemDB := &OrderItemDB{db: tx}
+	productDB := &ProductDB{db: tx}
+
+	items, err := orderItemDB.ListByOrder(orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		stock, err := productDB.RestoreStock(item.ProductID, item.Quantity)
+		if err != nil {
+			return fmt.Errorf("error restoring stock for product %s: %v", item.ProductID, err)
+		}
+		err = recordMovement(tx, &InventoryMovement{
+			ProductID:      item.ProductID,
+			Type:           MovementCancellation,
+			QuantityChange: item.Quantity,
+			QuantityAfter:  stock,
+			OrderID:        &orderID,
+			ActorID:        &actorID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return restoreOrderOptions(tx, orderID)
+}
+
 func (o *OrderDB) Delete(id uuid.UUID) error {
 	query, args, err := QB.Delete("orders").Where(squirrel.Eq{"id": id}).ToSql()
 	if err != nil {
@@ -157,23 +486,52 @@ func (o *OrderDB) ListByUser(userID uuid.UUID) ([]Order, error) {
 	return orders, nil
 }
 
+// OrderEventPayload is the body of order events: the order as the API
+// returns it and, for status changes, the status it moved from.
+type OrderEventPayload struct {
+	Order      *OrderWithItems `json:"order"`
+	FromStatus *string         `json:"from_status,omitempty"`
+}
+
 type OrderWithItems struct {
-	ID                uuid.UUID   `db:"id" json:"id"`
-	UserID            uuid.UUID   `db:"user_id" json:"user_id"`
-	StoreID           uuid.UUID   `db:"store_id" json:"store_id"`
-	TotalPrice        float64     `db:"total_price" json:"total_price"`
-	Status            string      `db:"status" json:"status"`
-	DeliveryAddress   string      `db:"delivery_address" json:"delivery_address"`
-	DeliveryLatitude  *float64    `db:"delivery_latitude" json:"delivery_latitude,omitempty"`
-	DeliveryLongitude *float64    `db:"delivery_longitude" json:"delivery_longitude,omitempty"`
-	DeliveryNotes     *string     `db:"delivery_notes" json:"delivery_notes,omitempty"`
-	CreatedAt         time.Time   `db:"created_at" json:"created_at"`
-	UpdatedAt         time.Time   `db:"updated_at" json:"updated_at"`
-	StoreName         string      `db:"store_name" json:"store_name,omitempty"`
-	Items             []OrderItem `db:"-" json:"items,omitempty"`
+	Order
+	StoreName string      `db:"store_name" json:"store_name,omitempty"`
+	Items     []OrderItem `db:"-" json:"items,omitempty"`
 }
 
-func (o *OrderDB) ListByStore(storeID uuid.UUID, queryParams url.Values, includeItems bool) ([]OrderWithItems, *utils.Meta, error) {
+// ScheduleFilter narrows a store's orders down by when they are delivered.
+type ScheduleFilter struct {
+	// Scheduled keeps only scheduled orders when true and only orders for
+	// delivery as soon as possible when false.
+	Scheduled *bool
+	SlotID    *uuid.UUID
+	// Date keeps orders scheduled on that day in the stores' local time.
+	Date *time.Time
+}
+
+func (f ScheduleFilter) conditions() []string {
+	conditions := []string{}
+	if f.Scheduled != nil {
+		if *f.Scheduled {
+			conditions = append(conditions, "orders.scheduled_for IS NOT NULL")
+		} else {
+			conditions = append(conditions, "orders.scheduled_for IS NULL")
+		}
+	}
+	if f.SlotID != nil {
+		conditions = append(conditions, fmt.Sprintf("orders.delivery_slot_id = '%s'", *f.SlotID))
+	}
+	if f.Date != nil {
+		// scheduled_for is stored in UTC.
+		day := time.Date(f.Date.Year(), f.Date.Month(), f.Date.Day(), 0, 0, 0, 0, StoreLocation)
+		start, end := day.UTC(), day.AddDate(0, 0, 1).UTC()
+		conditions = append(conditions, fmt.Sprintf("orders.scheduled_for >= '%s' AND orders.scheduled_for < '%s'",
+			start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05")))
+	}
+	return conditions
+}
+
+func (o *OrderDB) ListByStore(storeID uuid.UUID, queryParams url.Values, includeItems bool, schedule ScheduleFilter) ([]OrderWithItems, *utils.Meta, error) {
 	var orders []OrderWithItems
 	joins := []string{
 		" stores s ON orders.store_id = s.id",
@@ -183,17 +541,25 @@ func (o *OrderDB) ListByStore(storeID uuid.UUID, queryParams url.Values, include
 		"orders.user_id",
 		"orders.store_id",
 		"orders.total_price",
+		"orders.discount_total",
+		"orders.delivery_fee",
+		"orders.delivery_distance_km",
 		"orders.status",
 		"orders.delivery_address",
 		"orders.delivery_latitude",
 		"orders.delivery_longitude",
 		"orders.delivery_notes",
+		"orders.scheduled_for",
+		"orders.delivery_slot_id",
 		"orders.created_at",
 		"orders.updated_at",
+		"orders.cancellation_reason",
+		"orders.cancelled_by",
+		"orders.cancelled_at",
 		"s.name as store_name",
 	}
 	searchCols := []string{"orders.delivery_address"}
-	additionalFilters := []string{}
+	additionalFilters := append([]string{fmt.Sprintf("orders.store_id = '%s'", storeID)}, schedule.conditions()...)
 
 	meta, err := utils.BuildQuery(&orders, "orders", joins, columns, searchCols, queryParams, additionalFilters)
 	if err != nil {
@@ -223,6 +589,11 @@ func (o *OrderDB) ListByStore(storeID uuid.UUID, queryParams url.Values, include
 			return nil, nil, fmt.Errorf("error getting order items: %v", err)
 		}
 
+		err = fillOrderItemOptions(o.db, orderItems)
+		if err != nil {
+			return nil, nil, err
+		}
+
 		itemMap := make(map[uuid.UUID][]OrderItem)
 		for _, item := range orderItems {
 			itemMap[item.OrderID] = append(itemMap[item.OrderID], item)
@@ -235,21 +606,23 @@ func (o *OrderDB) ListByStore(storeID uuid.UUID, queryParams url.Values, include
 	}
 	return orders, meta, nil
 }
-func (o *OrderDB) CreateFromCart(order *Order, cartID uuid.UUID, cartItems []CartItem) error {
-	// Calculate total price and validate stock
-	var totalPrice float64
-	productDB := &ProductDB{db: o.db}
-	for _, item := range cartItems {
-		product, err := productDB.Get(item.ProductID)
-		if err != nil {
-			return fmt.Errorf("error getting product %s: %v", item.ProductID, err)
-		}
-		if !product.IsAvailable || product.StockQuantity < item.Quantity {
-			return fmt.Errorf("product %s is not available or insufficient stock", item.ProductID)
-		}
-		totalPrice += (product.Price - product.Discount) * float64(item.Quantity)
-	}
-	order.TotalPrice = totalPrice
+
+// CreateFromCart turns a cart into an order in a single transaction: stock is
+// reserved item by item with ProductDB.ReserveStock, the order is priced from
+// the rows returned by those updates, the cart's coupon is checked again and
+// redeemed, the delivery fee is added, and the cart is emptied.
+//
+// With a slot, or when the cart holds one, the order is scheduled for the
+// slot's start and takes a place in it; the store must be open then rather
+// than now.
+func (o *OrderDB) CreateFromCart(order *Order, cartID uuid.UUID, cartItems []CartItem, slot *SlotChoice) error {
+	// Reserve products in a fixed order so that two checkouts sharing
+	// products lock them in the same sequence and cannot deadlock.
+	items := make([]CartItem, len(cartItems))
+	copy(items, cartItems)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ProductID.String() < items[j].ProductID.String()
+	})
 
 	// Start transaction
 	tx, err := o.db.(*sqlx.DB).Beginx()
@@ -261,9 +634,111 @@ func (o *OrderDB) CreateFromCart(order *Order, cartID uuid.UUID, cartItems []Car
 	// Create transaction-bound model instances
 	orderDB := &OrderDB{db: tx}
 	orderItemDB := &OrderItemDB{db: tx}
-	productDBTx := &ProductDB{db: tx}
+	productDB := &ProductDB{db: tx}
 	cartItemDB := &CartItemDB{db: tx}
 	cartDB := &CartDB{db: tx}
+	historyDB := &OrderStatusHistoryDB{db: tx}
+	couponDB := &CouponDB{db: tx}
+	discountDB := &OrderDiscountDB{db: tx}
+
+	cart, err := cartDB.Get(cartID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if slot == nil {
+		slotDB := &DeliverySlotDB{db: tx}
+		slot, err = slotDB.HeldSlot(cartID, now)
+		if err != nil {
+			return err
+		}
+	}
+	openAt := now
+	if slot != nil {
+		deliverySlot, start, err := reserveSlot(tx, order.StoreID, cartID, *slot, now)
+		if err != nil {
+			return err
+		}
+		scheduledFor := start.UTC()
+		order.ScheduledFor = &scheduledFor
+		order.DeliverySlotID = &deliverySlot.ID
+		openAt = start
+	}
+
+	hoursDB := &StoreHoursDB{db: tx}
+	err = hoursDB.CheckOpen(order.StoreID, openAt)
+	if err != nil {
+		return err
+	}
+
+	// The options picked must still follow their products' groups
+	problems, err := checkCartOptions(tx, items)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return ErrInvalidOptionChoice
+	}
+
+	// Reserve stock and price the order from the reserved rows, the same way
+	// cart quotes are priced
+	products := make(map[uuid.UUID]*Product, len(items))
+	sales := make([]InventoryMovement, 0, len(items))
+	for _, item := range items {
+		product, err := productDB.ReserveStock(item.ProductID, item.Quantity)
+		if err != nil {
+			return err
+		}
+		products[item.ProductID] = product
+		sales = append(sales, InventoryMovement{
+			ProductID:      item.ProductID,
+			Type:           MovementSale,
+			QuantityChange: -item.Quantity,
+			QuantityAfter:  product.StockQuantity,
+			ActorID:        &order.UserID,
+		})
+	}
+	options, err := reserveOptions(tx, items)
+	if err != nil {
+		return err
+	}
+	quote := priceCart(items, products, options)
+
+	store, err := getPricingStore(tx, order.StoreID)
+	if err != nil {
+		return err
+	}
+
+	// Check the coupon against the reserved prices. Locking it makes
+	// concurrent checkouts take its remaining uses one at a time.
+	var coupon *Coupon
+	if cart.CouponID != nil {
+		coupon, err = couponDB.getForUpdate(*cart.CouponID)
+		if err != nil {
+			return err
+		}
+		err = couponDB.Check(coupon, CouponBasket{
+			UserID:      order.UserID,
+			StoreID:     store.ID,
+			StoreTypeID: store.StoreTypeID,
+			Subtotal:    quote.ItemsTotal(),
+		}, time.Now())
+		if err != nil {
+			return err
+		}
+		quote.applyCoupon(coupon)
+	}
+
+	err = quote.applyDelivery(tx, store, order.deliveryPoint())
+	if err != nil {
+		return err
+	}
+
+	order.DiscountTotal = quote.CouponDiscount
+	order.DeliveryFee = quote.DeliveryFee
+	order.DeliveryDistanceKm = quote.DistanceKm
+	order.TotalPrice = quote.GrandTotal
 
 	// Insert order
 	err = orderDB.Insert(order)
@@ -271,35 +746,88 @@ func (o *OrderDB) CreateFromCart(order *Order, cartID uuid.UUID, cartItems []Car
 		return fmt.Errorf("error inserting order: %v", err)
 	}
 
-	// Create order items
-	for _, item := range cartItems {
-		product, err := productDB.Get(item.ProductID) // Use non-tx productDB for read-only
+	// Record the sales in the products' inventory
+	for i := range sales {
+		sales[i].OrderID = &order.ID
+		err = recordMovement(tx, &sales[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record the initial status
+	err = historyDB.Insert(&OrderStatusHistory{
+		OrderID:   order.ID,
+		ToStatus:  order.Status,
+		ActorID:   &order.UserID,
+		ActorRole: ActorCustomer,
+	})
+	if err != nil {
+		return fmt.Errorf("error recording status history: %v", err)
+	}
+
+	// Take the order's place in its slot
+	if slot != nil {
+		err = bookOrderSlot(tx, order, cartID, *slot)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record the coupon as a discount line
+	if coupon != nil {
+		err = couponDB.redeem(coupon, order.UserID, order.ID, quote.CouponDiscount)
+		if err != nil {
+			return err
+		}
+		err = discountDB.Insert(&OrderDiscount{
+			OrderID:     order.ID,
+			CouponID:    &coupon.ID,
+			Code:        coupon.Code,
+			Description: coupon.Description,
+			Amount:      quote.CouponDiscount,
+		})
 		if err != nil {
-			return fmt.Errorf("error getting product %s: %v", item.ProductID, err)
+			return err
 		}
+	}
+
+	// Create order items
+	orderItems := make([]OrderItem, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
 		orderItem := &OrderItem{
-			ID:           uuid.New(),
 			OrderID:      order.ID,
-			ProductID:    item.ProductID,
-			Quantity:     item.Quantity,
-			PriceAtOrder: product.Price - product.Discount,
-			CreatedAt:    time.Now(),
+			ProductID:    line.ProductID,
+			Quantity:     line.Quantity,
+			PriceAtOrder: line.UnitPrice.Sub(line.UnitDiscount),
+			Options:      make([]OrderItemOption, len(line.Options)),
+		}
+		for i, option := range line.Options {
+			optionID := option.OptionID
+			orderItem.Options[i] = OrderItemOption{
+				OptionID:   &optionID,
+				GroupName:  option.Group,
+				OptionName: option.Name,
+				PriceDelta: option.PriceDelta,
+			}
 		}
 		err = orderItemDB.Insert(orderItem)
 		if err != nil {
 			return fmt.Errorf("error inserting order item: %v", err)
 		}
+		orderItems = append(orderItems, *orderItem)
+	}
 
-		// Update product stock
-		product.StockQuantity -= item.Quantity
-		err = productDBTx.Update(product)
-		if err != nil {
-			return fmt.Errorf("error updating product stock: %v", err)
-		}
+	// Publish the new order
+	err = events.Write(tx, events.OrderCreated, order.ID, &order.StoreID, OrderEventPayload{
+		Order: &OrderWithItems{Order: *order, Items: orderItems},
+	})
+	if err != nil {
+		return err
 	}
 
 	// Clear cart items
-	for _, item := range cartItems {
+	for _, item := range items {
 		err = cartItemDB.Delete(item.ID)
 		if err != nil {
 			return fmt.Errorf("error deleting cart item %s: %v", item.ID, err)
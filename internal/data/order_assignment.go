@@ -0,0 +1,388 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	AssignmentOffered   = "offered"
+	AssignmentAccepted  = "accepted"
+	AssignmentRejected  = "rejected"
+	AssignmentExpired   = "expired"
+	AssignmentPickedUp  = "picked_up"
+	AssignmentDelivered = "delivered"
+	AssignmentCancelled = "cancelled"
+)
+
+// activeAssignmentStatuses are the statuses in which an assignment still holds
+// the order; an order has at most one assignment in any of them.
+var activeAssignmentStatuses = []string{AssignmentOffered, AssignmentAccepted, AssignmentPickedUp}
+
+// OrderAssignment is the offer of an order to a driver and, once accepted,
+// the driver's progress delivering it.
+type OrderAssignment struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	OrderID     uuid.UUID  `db:"order_id" json:"order_id"`
+	DriverID    uuid.UUID  `db:"driver_id" json:"driver_id"`
+	AssignedBy  *uuid.UUID `db:"assigned_by" json:"assigned_by,omitempty"`
+	Status      string     `db:"status" json:"status"`
+	OfferedAt   time.Time  `db:"offered_at" json:"offered_at"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	RespondedAt *time.Time `db:"responded_at" json:"responded_at,omitempty"`
+	PickedUpAt  *time.Time `db:"picked_up_at" json:"picked_up_at,omitempty"`
+	DeliveredAt *time.Time `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// HoldsOrder reports whether the driver has taken the order on: accepted it
+// or already picked it up. A driver who has only been offered the order does
+// not hold it yet.
+func (a *OrderAssignment) HoldsOrder() bool {
+	return a.Status == AssignmentAccepted || a.Status == AssignmentPickedUp
+}
+
+// AssignmentWithOrder adds what a driver needs to decide on an offer.
+type AssignmentWithOrder struct {
+	OrderAssignment
+	StoreName         string   `db:"store_name" json:"store_name"`
+	StoreAddress      *string  `db:"store_address" json:"store_address,omitempty"`
+	StoreLatitude     *float64 `db:"store_latitude" json:"store_latitude,omitempty"`
+	StoreLongitude    *float64 `db:"store_longitude" json:"store_longitude,omitempty"`
+	DeliveryAddress   string   `db:"delivery_address" json:"delivery_address"`
+	DeliveryLatitude  *float64 `db:"delivery_latitude" json:"delivery_latitude,omitempty"`
+	DeliveryLongitude *float64 `db:"delivery_longitude" json:"delivery_longitude,omitempty"`
+	TotalPrice        Money    `db:"total_price" json:"total_price"`
+}
+
+// DispatchOrder is a ready order waiting for a driver, with the pickup point.
+type DispatchOrder struct {
+	OrderID        uuid.UUID `db:"order_id"`
+	StoreID        uuid.UUID `db:"store_id"`
+	StoreLatitude  *float64  `db:"store_latitude"`
+	StoreLongitude *float64  `db:"store_longitude"`
+}
+
+type OrderAssignmentDB struct {
+	db DBInterface
+}
+
+var orderAssignmentColumns = []string{
+	"id", "order_id", "driver_id", "assigned_by", "status",
+	"offered_at", "expires_at", "responded_at", "picked_up_at", "delivered_at",
+}
+
+// Offer inserts a new offer. It fails with ErrOrderAlreadyAssigned when the
+// order already has a live assignment.
+func (a *OrderAssignmentDB) Offer(assignment *OrderAssignment) error {
+	assignment.ID = uuid.New()
+	assignment.Status = AssignmentOffered
+	assignment.OfferedAt = time.Now()
+
+	query, args, err := QB.Insert("order_assignments").
+		Columns("id", "order_id", "driver_id", "assigned_by", "status", "offered_at", "expires_at").
+		Values(assignment.ID, assignment.OrderID, assignment.DriverID, assignment.AssignedBy,
+			assignment.Status, assignment.OfferedAt, assignment.ExpiresAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = a.db.Exec(query, args...)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrOrderAlreadyAssigned
+		}
+		return fmt.Errorf("error inserting order assignment: %v", err)
+	}
+
+	return nil
+}
+
+func (a *OrderAssignmentDB) Get(id uuid.UUID) (*OrderAssignment, error) {
+	var assignment OrderAssignment
+	query, args, err := QB.Select(orderAssignmentColumns...).From("order_assignments").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = a.db.Get(&assignment, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAssignmentNotFound
+		}
+		return nil, fmt.Errorf("error getting order assignment: %v", err)
+	}
+
+	return &assignment, nil
+}
+
+// GetActiveByOrder returns the live assignment of an order, or nil if it has none.
+func (a *OrderAssignmentDB) GetActiveByOrder(orderID uuid.UUID) (*OrderAssignment, error) {
+	var assignment OrderAssignment
+	query, args, err := QB.Select(orderAssignmentColumns...).From("order_assignments").
+		Where(squirrel.Eq{"order_id": orderID, "status": activeAssignmentStatuses}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = a.db.Get(&assignment, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting order assignment: %v", err)
+	}
+
+	return &assignment, nil
+}
+
+// ListByDriver lists a driver's assignments in the given statuses, newest first.
+func (a *OrderAssignmentDB) ListByDriver(driverID uuid.UUID, statuses ...string) ([]AssignmentWithOrder, error) {
+	assignments := []AssignmentWithOrder{}
+	columns := make([]string, 0, len(orderAssignmentColumns)+8)
+	for _, column := range orderAssignmentColumns {
+		columns = append(columns, "oa."+column)
+	}
+	columns = append(columns,
+		"s.name AS store_name", "s.address_text AS store_address",
+		"s.latitude AS store_latitude", "s.longitude AS store_longitude",
+		"o.delivery_address", "o.delivery_latitude", "o.delivery_longitude", "o.total_price",
+	)
+
+	sb := QB.Select(columns...).
+		From("order_assignments oa").
+		Join("orders o ON oa.order_id = o.id").
+		Join("stores s ON o.store_id = s.id").
+		Where(squirrel.Eq{"oa.driver_id": driverID, "oa.status": statuses}).
+		OrderBy("oa.offered_at DESC")
+	if len(statuses) == 1 && statuses[0] == AssignmentOffered {
+		sb = sb.Where("(oa.expires_at IS NULL OR oa.expires_at > ?)", time.Now())
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = a.db.Select(&assignments, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting driver assignments: %v", err)
+	}
+
+	return assignments, nil
+}
+
+// ListAwaitingDispatch returns ready orders without a live assignment, oldest first.
+func (a *OrderAssignmentDB) ListAwaitingDispatch() ([]DispatchOrder, error) {
+	orders := []DispatchOrder{}
+	query, args, err := QB.Select(
+		"o.id AS order_id", "o.store_id",
+		"s.latitude AS store_latitude", "s.longitude AS store_longitude",
+	).
+		From("orders o").
+		Join("stores s ON o.store_id = s.id").
+		Where(squirrel.Eq{"o.status": OrderStatusReady}).
+		Where(`NOT EXISTS (
+			SELECT 1 FROM order_assignments oa
+			WHERE oa.order_id = o.id AND oa.status IN ('offered', 'accepted', 'picked_up')
+		)`).
+		OrderBy("o.updated_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = a.db.Select(&orders, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing orders awaiting dispatch: %v", err)
+	}
+
+	return orders, nil
+}
+
+// ExpireOffers marks offers that were not answered before their deadline as
+// expired and returns how many there were.
+func (a *OrderAssignmentDB) ExpireOffers(now time.Time) (int64, error) {
+	query, args, err := QB.Update("order_assignments").
+		Set("status", AssignmentExpired).
+		Where(squirrel.Eq{"status": AssignmentOffered}).
+		Where(squirrel.LtOrEq{"expires_at": now}).
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := a.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error expiring offers: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Respond records a driver's answer to an offer.
+func (a *OrderAssignmentDB) Respond(id, driverID uuid.UUID, accept bool) (*OrderAssignment, error) {
+	tx, err := a.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	assignment, err := lockAssignment(tx, id, driverID)
+	if err != nil {
+		return nil, err
+	}
+	if assignment.Status != AssignmentOffered {
+		return nil, ErrInvalidAssignmentState
+	}
+
+	now := time.Now()
+	if assignment.ExpiresAt != nil && assignment.ExpiresAt.Before(now) {
+		return nil, ErrOfferExpired
+	}
+
+	status := AssignmentRejected
+	if accept {
+		status = AssignmentAccepted
+	}
+	err = updateAssignment(tx, assignment.ID, map[string]interface{}{
+		"status":       status,
+		"responded_at": now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	assignment.Status = status
+	assignment.RespondedAt = &now
+	return assignment, nil
+}
+
+// MarkPickedUp records that the driver collected the order from the store and
+// moves the order to shipped.
+func (a *OrderAssignmentDB) MarkPickedUp(id, driverID uuid.UUID) (*OrderAssignment, *Order, error) {
+	return a.advance(id, driverID, AssignmentAccepted, AssignmentPickedUp, "picked_up_at", OrderStatusShipped)
+}
+
+// MarkDelivered records that the driver handed the order to the customer and
+// moves the order to delivered.
+func (a *OrderAssignmentDB) MarkDelivered(id, driverID uuid.UUID) (*OrderAssignment, *Order, error) {
+	return a.advance(id, driverID, AssignmentPickedUp, AssignmentDelivered, "delivered_at", OrderStatusDelivered)
+}
+
+// advance moves an assignment from one status to the next and transitions its
+// order as the driver, all in one transaction.
+func (a *OrderAssignmentDB) advance(id, driverID uuid.UUID, from, to, timestampColumn, orderStatus string) (*OrderAssignment, *Order, error) {
+	tx, err := a.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	assignment, err := lockAssignment(tx, id, driverID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if assignment.Status != from {
+		return nil, nil, ErrInvalidAssignmentState
+	}
+
+	now := time.Now()
+	err = updateAssignment(tx, assignment.ID, map[string]interface{}{
+		"status":        to,
+		timestampColumn: now,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orderDB := &OrderDB{db: tx}
+	order, err := orderDB.Get(assignment.OrderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = transitionOrder(tx, order, orderStatus, driverID, ActorDelivery, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	assignment.Status = to
+	return assignment, order, nil
+}
+
+// lockAssignment loads an assignment for update, making sure it belongs to the driver.
+func lockAssignment(tx DBInterface, id, driverID uuid.UUID) (*OrderAssignment, error) {
+	var assignment OrderAssignment
+	query, args, err := QB.Select(orderAssignmentColumns...).From("order_assignments").
+		Where(squirrel.Eq{"id": id}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = tx.Get(&assignment, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAssignmentNotFound
+		}
+		return nil, fmt.Errorf("error getting order assignment: %v", err)
+	}
+	if assignment.DriverID != driverID {
+		return nil, ErrAssignmentNotFound
+	}
+
+	return &assignment, nil
+}
+
+func updateAssignment(tx DBInterface, id uuid.UUID, changes map[string]interface{}) error {
+	query, args, err := QB.Update("order_assignments").
+		SetMap(changes).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating order assignment: %v", err)
+	}
+
+	return nil
+}
+
+// cancelOrderAssignments releases the live assignment of an order that is being cancelled.
+func cancelOrderAssignments(tx DBInterface, orderID uuid.UUID) error {
+	query, args, err := QB.Update("order_assignments").
+		Set("status", AssignmentCancelled).
+		Where(squirrel.Eq{"order_id": orderID, "status": activeAssignmentStatuses}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error cancelling order assignments: %v", err)
+	}
+
+	return nil
+}
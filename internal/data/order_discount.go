@@ -0,0 +1,68 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// OrderDiscount is a discount line of an order. Code and description are
+// copied from the coupon so the line still reads correctly if the coupon is
+// changed or deleted later.
+type OrderDiscount struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	OrderID     uuid.UUID  `db:"order_id" json:"order_id"`
+	CouponID    *uuid.UUID `db:"coupon_id" json:"coupon_id,omitempty"`
+	Code        string     `db:"code" json:"code"`
+	Description *string    `db:"description" json:"description,omitempty"`
+	Amount      Money      `db:"amount" json:"amount"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+type OrderDiscountDB struct {
+	db DBInterface
+}
+
+var orderDiscountColumns = []string{"id", "order_id", "coupon_id", "code", "description", "amount", "created_at"}
+
+func (d *OrderDiscountDB) Insert(discount *OrderDiscount) error {
+	discount.ID = uuid.New()
+	discount.CreatedAt = time.Now()
+
+	query, args, err := QB.Insert("order_discounts").
+		Columns(orderDiscountColumns...).
+		Values(discount.ID, discount.OrderID, discount.CouponID, discount.Code, discount.Description,
+			discount.Amount, discount.CreatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting order discount: %v", err)
+	}
+
+	return nil
+}
+
+func (d *OrderDiscountDB) ListByOrder(orderID uuid.UUID) ([]OrderDiscount, error) {
+	discounts := []OrderDiscount{}
+	query, args, err := QB.Select(orderDiscountColumns...).
+		From("order_discounts").
+		Where(squirrel.Eq{"order_id": orderID}).
+		OrderBy("created_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&discounts, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order discounts: %v", err)
+	}
+
+	return discounts, nil
+}
@@ -16,21 +16,38 @@ type OrderItem struct {
 	OrderID      uuid.UUID `db:"order_id" json:"order_id"`
 	ProductID    uuid.UUID `db:"product_id" json:"product_id"`
 	Quantity     int       `db:"quantity" json:"quantity"`
-	PriceAtOrder float64   `db:"price_at_order" json:"price_at_order"`
+	PriceAtOrder Money     `db:"price_at_order" json:"price_at_order"`
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+
+	Options []OrderItemOption `db:"-" json:"options,omitempty"`
+}
+
+// OrderItemOption is a copy of an option picked for an order item, as it was
+// when the order was placed. PriceAtOrder already includes its PriceDelta.
+type OrderItemOption struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	OrderItemID uuid.UUID  `db:"order_item_id" json:"order_item_id"`
+	OptionID    *uuid.UUID `db:"option_id" json:"option_id,omitempty"`
+	GroupName   string     `db:"group_name" json:"group_name"`
+	OptionName  string     `db:"option_name" json:"option_name"`
+	PriceDelta  Money      `db:"price_delta" json:"price_delta"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
 }
 
 type OrderItemDB struct {
 	db DBInterface
 }
 
-var orderItemColumns = []string{"id", "order_id", "product_id", "quantity", "price_at_order", "created_at"}
+var (
+	orderItemColumns       = []string{"id", "order_id", "product_id", "quantity", "price_at_order", "created_at"}
+	orderItemOptionColumns = []string{"id", "order_item_id", "option_id", "group_name", "option_name", "price_delta", "created_at"}
+)
 
 func ValidateOrderItem(v *validator.Validator, item *OrderItem) {
 	v.Check(item.OrderID != uuid.Nil, "order_id", "يجب إدخال معرف الطلب")
 	v.Check(item.ProductID != uuid.Nil, "product_id", "يجب إدخال معرف المنتج")
 	v.Check(item.Quantity > 0, "quantity", "يجب أن تكون الكمية أكبر من 0")
-	v.Check(item.PriceAtOrder >= 0, "price_at_order", "يجب أن يكون السعر عند الطلب إيجابيًا")
+	v.Check(!item.PriceAtOrder.IsNegative(), "price_at_order", "يجب أن يكون السعر عند الطلب إيجابيًا")
 }
 
 func (oi *OrderItemDB) Insert(item *OrderItem) error {
@@ -46,7 +63,32 @@ func (oi *OrderItemDB) Insert(item *OrderItem) error {
 		return fmt.Errorf("error creating query: %v", err)
 	}
 
-	return oi.db.QueryRow(query, args...).Scan(&item.ID, &item.CreatedAt)
+	err = oi.db.QueryRow(query, args...).Scan(&item.ID, &item.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if len(item.Options) == 0 {
+		return nil
+	}
+	insert := QB.Insert("order_item_options").Columns(orderItemOptionColumns...)
+	for i := range item.Options {
+		option := &item.Options[i]
+		option.ID = uuid.New()
+		option.OrderItemID = item.ID
+		option.CreatedAt = item.CreatedAt
+		insert = insert.Values(option.ID, option.OrderItemID, option.OptionID, option.GroupName, option.OptionName,
+			option.PriceDelta, option.CreatedAt)
+	}
+	query, args, err = insert.ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = oi.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting order item options: %v", err)
+	}
+	return nil
 }
 
 func (oi *OrderItemDB) Get(id uuid.UUID) (*OrderItem, error) {
@@ -64,7 +106,13 @@ func (oi *OrderItemDB) Get(id uuid.UUID) (*OrderItem, error) {
 		return nil, fmt.Errorf("error getting order item: %v", err)
 	}
 
-	return &item, nil
+	items := []OrderItem{item}
+	err = fillOrderItemOptions(oi.db, items)
+	if err != nil {
+		return nil, err
+	}
+
+	return &items[0], nil
 }
 
 func (oi *OrderItemDB) ListByOrder(orderID uuid.UUID) ([]OrderItem, error) {
@@ -79,5 +127,44 @@ func (oi *OrderItemDB) ListByOrder(orderID uuid.UUID) ([]OrderItem, error) {
 		return nil, fmt.Errorf("error getting order items: %v", err)
 	}
 
+	err = fillOrderItemOptions(oi.db, items)
+	if err != nil {
+		return nil, err
+	}
+
 	return items, nil
 }
+
+// fillOrderItemOptions sets the Options of each item.
+func fillOrderItemOptions(db DBInterface, items []OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	var options []OrderItemOption
+	query, args, err := QB.Select(orderItemOptionColumns...).
+		From("order_item_options").
+		Where(squirrel.Eq{"order_item_id": ids}).
+		OrderBy("created_at", "group_name", "option_name").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	err = db.Select(&options, query, args...)
+	if err != nil {
+		return fmt.Errorf("error getting order item options: %v", err)
+	}
+
+	byItem := make(map[uuid.UUID][]OrderItemOption, len(items))
+	for _, option := range options {
+		byItem[option.OrderItemID] = append(byItem[option.OrderItemID], option)
+	}
+	for i := range items {
+		items[i].Options = byItem[items[i].ID]
+	}
+	return nil
+}
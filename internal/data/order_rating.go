@@ -0,0 +1,254 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// OrderRating is the customer's rating of a delivered order as a whole:
+// how fast it came, how it was packed and, when a driver delivered it, the
+// courier. Ratings count towards their store's and driver's aggregates.
+type OrderRating struct {
+	OrderID   uuid.UUID  `db:"order_id" json:"order_id"`
+	StoreID   uuid.UUID  `db:"store_id" json:"store_id"`
+	UserID    uuid.UUID  `db:"user_id" json:"user_id"`
+	DriverID  *uuid.UUID `db:"driver_id" json:"driver_id,omitempty"`
+	Speed     int        `db:"speed" json:"speed"`
+	Packaging int        `db:"packaging" json:"packaging"`
+	Courier   *int       `db:"courier" json:"courier,omitempty"`
+	Comment   *string    `db:"comment" json:"comment,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// StoreRatingSummary is how a store's orders were rated over a period.
+type StoreRatingSummary struct {
+	StoreID      uuid.UUID `db:"store_id" json:"store_id"`
+	StoreName    string    `db:"store_name" json:"store_name"`
+	OwnerID      uuid.UUID `db:"owner_id" json:"owner_id"`
+	RatingCount  int       `db:"rating_count" json:"rating_count"`
+	SpeedAvg     float64   `db:"speed_avg" json:"speed_avg"`
+	PackagingAvg float64   `db:"packaging_avg" json:"packaging_avg"`
+	CourierAvg   *float64  `db:"courier_avg" json:"courier_avg,omitempty"`
+	OverallAvg   float64   `db:"overall_avg" json:"overall_avg"`
+}
+
+// LowRatedStoresFilter selects the stores LowRatedStores reports.
+type LowRatedStoresFilter struct {
+	// From and To bound when the ratings were given; To is exclusive.
+	From time.Time
+	To   time.Time
+	// MaxRating is the highest overall average reported.
+	MaxRating float64
+	// MinRatings leaves out stores rated fewer times in the period.
+	MinRatings int
+	Limit      int
+}
+
+type OrderRatingDB struct {
+	db DBInterface
+}
+
+var orderRatingColumns = []string{
+	"order_id", "store_id", "user_id", "driver_id", "speed", "packaging", "courier", "comment", "created_at",
+}
+
+// orderRatingOverallSQL is the overall score of a rating: the average of its
+// parts, leaving out the courier when there was none.
+const orderRatingOverallSQL = "(speed + packaging + COALESCE(courier, 0))::numeric / CASE WHEN courier IS NULL THEN 2 ELSE 3 END"
+
+func ValidateOrderRating(v *validator.Validator, rating *OrderRating) {
+	v.Check(rating.Speed >= 1 && rating.Speed <= 5, "speed", "يجب أن يكون تقييم السرعة من 1 إلى 5")
+	v.Check(rating.Packaging >= 1 && rating.Packaging <= 5, "packaging", "يجب أن يكون تقييم التغليف من 1 إلى 5")
+	if rating.Courier != nil {
+		v.Check(*rating.Courier >= 1 && *rating.Courier <= 5, "courier", "يجب أن يكون تقييم المندوب من 1 إلى 5")
+	}
+	if rating.Comment != nil {
+		v.Check(utf8.RuneCountInString(*rating.Comment) <= 2000, "comment", "يجب ألا يزيد التعليق عن 2000 حرف")
+	}
+}
+
+// Insert records the rating of a delivered order and updates the rating
+// aggregates of its store and of the driver who delivered it. The order's
+// store, customer and driver are taken from the order. Only one rating per
+// order is accepted.
+func (o *OrderRatingDB) Insert(rating *OrderRating) error {
+	tx, err := o.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Select("user_id", "store_id", "status").
+		From("orders").
+		Where(squirrel.Eq{"id": rating.OrderID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	var order struct {
+		UserID  uuid.UUID `db:"user_id"`
+		StoreID uuid.UUID `db:"store_id"`
+		Status  string    `db:"status"`
+	}
+	err = tx.Get(&order, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("error getting order: %v", err)
+	}
+	if order.Status != OrderStatusDelivered {
+		return ErrOrderNotDelivered
+	}
+
+	query, args, err = QB.Select("driver_id").
+		From("order_assignments").
+		Where(squirrel.Eq{"order_id": rating.OrderID, "status": AssignmentDelivered}).
+		OrderBy("delivered_at DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	var driverID uuid.UUID
+	err = tx.Get(&driverID, query, args...)
+	switch {
+	case err == nil:
+		rating.DriverID = &driverID
+	case err == sql.ErrNoRows:
+		rating.DriverID = nil
+	default:
+		return fmt.Errorf("error getting order driver: %v", err)
+	}
+	if rating.Courier != nil && rating.DriverID == nil {
+		return ErrNoCourier
+	}
+
+	rating.StoreID = order.StoreID
+	rating.UserID = order.UserID
+	// created_at is stored in UTC, like orders.scheduled_for.
+	rating.CreatedAt = time.Now().UTC()
+
+	// Lock the store, then the driver, so that their aggregates are
+	// recomputed one rating at a time.
+	_, err = tx.Exec(`SELECT 1 FROM stores WHERE id = $1 FOR UPDATE`, rating.StoreID)
+	if err != nil {
+		return fmt.Errorf("error locking store ratings: %v", err)
+	}
+	if rating.DriverID != nil {
+		_, err = tx.Exec(`SELECT 1 FROM drivers WHERE user_id = $1 FOR UPDATE`, *rating.DriverID)
+		if err != nil {
+			return fmt.Errorf("error locking driver ratings: %v", err)
+		}
+	}
+
+	query, args, err = QB.Insert("order_ratings").
+		Columns(orderRatingColumns...).
+		Values(rating.OrderID, rating.StoreID, rating.UserID, rating.DriverID, rating.Speed, rating.Packaging,
+			rating.Courier, rating.Comment, rating.CreatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateOrderRating
+		}
+		return fmt.Errorf("error inserting order rating: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE stores SET
+			speed_rating_avg = COALESCE(r.speed, 0),
+			packaging_rating_avg = COALESCE(r.packaging, 0),
+			courier_rating_avg = COALESCE(r.courier, 0),
+			order_rating_count = r.count
+		FROM (SELECT ROUND(AVG(speed), 2) AS speed, ROUND(AVG(packaging), 2) AS packaging,
+			ROUND(AVG(courier), 2) AS courier, COUNT(*) AS count
+			FROM order_ratings WHERE store_id = $1) r
+		WHERE stores.id = $1`, rating.StoreID)
+	if err != nil {
+		return fmt.Errorf("error updating store order rating: %v", err)
+	}
+	if rating.DriverID != nil {
+		_, err = tx.Exec(`UPDATE drivers SET courier_rating_avg = COALESCE(r.avg, 0), courier_rating_count = r.count
+			FROM (SELECT ROUND(AVG(courier), 2) AS avg, COUNT(courier) AS count
+				FROM order_ratings WHERE driver_id = $1) r
+			WHERE drivers.user_id = $1`, *rating.DriverID)
+		if err != nil {
+			return fmt.Errorf("error updating driver rating: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+func (o *OrderRatingDB) Get(orderID uuid.UUID) (*OrderRating, error) {
+	var rating OrderRating
+	query, args, err := QB.Select(orderRatingColumns...).
+		From("order_ratings").
+		Where(squirrel.Eq{"order_id": orderID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = o.db.Get(&rating, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderRatingNotFound
+		}
+		return nil, fmt.Errorf("error getting order rating: %v", err)
+	}
+
+	return &rating, nil
+}
+
+// LowRatedStores returns the stores whose orders rated in the period average
+// at most filter.MaxRating overall, worst first.
+func (o *OrderRatingDB) LowRatedStores(filter LowRatedStoresFilter) ([]StoreRatingSummary, error) {
+	stores := []StoreRatingSummary{}
+	query, args, err := QB.Select(
+		"s.id AS store_id", "s.name AS store_name", "s.owner_id",
+		"COUNT(*) AS rating_count",
+		"ROUND(AVG(r.speed), 2) AS speed_avg",
+		"ROUND(AVG(r.packaging), 2) AS packaging_avg",
+		"ROUND(AVG(r.courier), 2) AS courier_avg",
+		fmt.Sprintf("ROUND(AVG(%s), 2) AS overall_avg", orderRatingOverallSQL),
+	).
+		From("order_ratings r").
+		Join("stores s ON s.id = r.store_id").
+		Where(squirrel.GtOrEq{"r.created_at": filter.From.UTC()}).
+		Where(squirrel.Lt{"r.created_at": filter.To.UTC()}).
+		GroupBy("s.id", "s.name", "s.owner_id").
+		Having("COUNT(*) >= ?", filter.MinRatings).
+		Having(fmt.Sprintf("AVG(%s) <= ?", orderRatingOverallSQL), filter.MaxRating).
+		OrderBy("overall_avg", "rating_count DESC").
+		Limit(uint64(filter.Limit)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = o.db.Select(&stores, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing low rated stores: %v", err)
+	}
+
+	return stores, nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+type OrderStatusHistory struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	OrderID    uuid.UUID  `db:"order_id" json:"order_id"`
+	FromStatus *string    `db:"from_status" json:"from_status,omitempty"`
+	ToStatus   string     `db:"to_status" json:"to_status"`
+	ActorID    *uuid.UUID `db:"actor_id" json:"actor_id,omitempty"`
+	ActorRole  OrderActor `db:"actor_role" json:"actor_role"`
+	Reason     *string    `db:"reason" json:"reason,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+}
+
+type OrderStatusHistoryDB struct {
+	db DBInterface
+}
+
+var orderStatusHistoryColumns = []string{
+	"id", "order_id", "from_status", "to_status", "actor_id", "actor_role", "reason", "created_at",
+}
+
+func (h *OrderStatusHistoryDB) Insert(entry *OrderStatusHistory) error {
+	entry.ID = uuid.New()
+	entry.CreatedAt = time.Now()
+
+	query, args, err := QB.Insert("order_status_history").
+		Columns(orderStatusHistoryColumns...).
+		Values(entry.ID, entry.OrderID, entry.FromStatus, entry.ToStatus, entry.ActorID,
+			entry.ActorRole, entry.Reason, entry.CreatedAt).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	return h.db.QueryRow(query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+func (h *OrderStatusHistoryDB) ListByOrder(orderID uuid.UUID) ([]OrderStatusHistory, error) {
+	history := []OrderStatusHistory{}
+	query, args, err := QB.Select(orderStatusHistoryColumns...).
+		From("order_status_history").
+		Where(squirrel.Eq{"order_id": orderID}).
+		OrderBy("created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = h.db.Select(&history, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting order status history: %v", err)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,165 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// testDB connects to the database named by TEST_DATABASE_URL, which must
+// have the migrations of internal/migrations applied. Tests that need it are
+// skipped when it is not set, except under CI, where they fail instead so a
+// missing database cannot pass unnoticed.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// insertTestUser adds a user with unique contact details.
+func insertTestUser(t *testing.T, db *sqlx.DB) uuid.UUID {
+	t.Helper()
+	id := uuid.New()
+	_, err := db.Exec(`INSERT INTO users (id, name, email, password, phone_number) VALUES ($1, $2, $3, $4, $5)`,
+		id, "test user", id.String()+"@example.com", "x", "t"+id.String()[:13])
+	if err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	return id
+}
+
+func TestCreateFromCartNeverOversells(t *testing.T) {
+	db := testDB(t)
+	model := NewModels(db)
+
+	const stock = 5
+	const buyers = 20
+
+	var storeTypeID int
+	err := db.Get(&storeTypeID, `INSERT INTO store_types (name) VALUES ($1) RETURNING id`, "test "+uuid.NewString())
+	if err != nil {
+		t.Fatalf("inserting store type: %v", err)
+	}
+	ownerID := insertTestUser(t, db)
+	storeID, productID := uuid.New(), uuid.New()
+	_, err = db.Exec(`INSERT INTO stores (id, owner_id, store_type_id, name, contact_phone, address_text)
+		VALUES ($1, $2, $3, 'test store', '0910000000', 'Tripoli')`, storeID, ownerID, storeTypeID)
+	if err != nil {
+		t.Fatalf("inserting store: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO products (id, store_id, name, price, stock_quantity) VALUES ($1, $2, 'limited', 10, $3)`,
+		productID, storeID, stock)
+	if err != nil {
+		t.Fatalf("inserting product: %v", err)
+	}
+
+	userIDs := make([]uuid.UUID, buyers)
+	cartIDs := make([]uuid.UUID, buyers)
+	for i := range userIDs {
+		userIDs[i] = insertTestUser(t, db)
+		cartIDs[i] = uuid.New()
+		_, err = db.Exec(`INSERT INTO carts (id, user_id, store_id) VALUES ($1, $2, $3)`, cartIDs[i], userIDs[i], storeID)
+		if err != nil {
+			t.Fatalf("inserting cart: %v", err)
+		}
+		_, err = db.Exec(`INSERT INTO cart_items (id, cart_id, product_id, quantity) VALUES ($1, $2, $3, 1)`,
+			uuid.New(), cartIDs[i], productID)
+		if err != nil {
+			t.Fatalf("inserting cart item: %v", err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM orders WHERE store_id = $1`, storeID)
+		for _, id := range append(userIDs, ownerID) {
+			db.Exec(`DELETE FROM users WHERE id = $1`, id)
+		}
+		db.Exec(`DELETE FROM store_types WHERE id = $1`, storeTypeID)
+	})
+
+	start := make(chan struct{})
+	errs := make([]error, buyers)
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		items, err := model.CartItemDB.ListByCart(cartIDs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := &Order{
+			UserID:          userIDs[i],
+			StoreID:         storeID,
+			Status:          OrderStatusPending,
+			DeliveryAddress: fmt.Sprintf("buyer %d", i),
+		}
+
+		wg.Add(1)
+		go func(i int, order *Order, items []CartItem) {
+			defer wg.Done()
+			<-start
+			errs[i] = model.OrderDB.CreateFromCart(order, cartIDs[i], items, nil)
+		}(i, order, items)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded, outOfStock := 0, 0
+	for i, err := range errs {
+		var stockErr *InsufficientStockError
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.As(err, &stockErr):
+			outOfStock++
+			if stockErr.ProductID != productID || stockErr.Requested != 1 {
+				t.Errorf("buyer %d: unexpected stock error %+v", i, stockErr)
+			}
+		default:
+			t.Errorf("buyer %d: unexpected error: %v", i, err)
+		}
+	}
+	if succeeded > stock {
+		t.Errorf("%d orders succeeded for a stock of %d", succeeded, stock)
+	}
+	if succeeded != stock || outOfStock != buyers-stock {
+		t.Errorf("got %d orders and %d stock errors, want %d and %d", succeeded, outOfStock, stock, buyers-stock)
+	}
+
+	var remaining int
+	err = db.Get(&remaining, `SELECT stock_quantity FROM products WHERE id = $1`, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("stock_quantity = %d after the sale, want 0", remaining)
+	}
+
+	var sold int
+	err = db.Get(&sold, `SELECT COALESCE(SUM(oi.quantity), 0) FROM order_items oi
+		JOIN orders o ON o.id = oi.order_id WHERE o.store_id = $1`, storeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sold != stock {
+		t.Errorf("%d items ordered, want %d", sold, stock)
+	}
+}
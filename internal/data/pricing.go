@@ -0,0 +1,390 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"project/utils"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+const (
+	QuoteWarningUnavailable       = "unavailable"
+	QuoteWarningInsufficientStock = "insufficient_stock"
+	QuoteWarningCoupon            = "coupon"
+	QuoteWarningDelivery          = "delivery"
+	QuoteWarningStoreClosed       = "store_closed"
+	QuoteWarningOptions           = "options"
+)
+
+// DeliveryPoint is where an order is to be delivered.
+type DeliveryPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// QuoteLine is a cart item priced at its product's current price. The unit
+// price includes the price of the picked options.
+type QuoteLine struct {
+	CartItemID   uuid.UUID         `json:"cart_item_id"`
+	ProductID    uuid.UUID         `json:"product_id"`
+	Name         string            `json:"name"`
+	Quantity     int               `json:"quantity"`
+	Options      []QuoteLineOption `json:"options,omitempty"`
+	UnitPrice    Money             `json:"unit_price"`
+	UnitDiscount Money             `json:"unit_discount"`
+	LineTotal    Money             `json:"line_total"`
+}
+
+// QuoteLineOption is an option picked for a cart item, at its current price.
+type QuoteLineOption struct {
+	OptionID   uuid.UUID `json:"option_id"`
+	Group      string    `json:"group"`
+	Name       string    `json:"name"`
+	PriceDelta Money     `json:"price_delta"`
+}
+
+// QuoteWarning tells the customer why the cart cannot be ordered as it is,
+// or why its coupon does not apply.
+type QuoteWarning struct {
+	Code      string     `json:"code"`
+	ProductID *uuid.UUID `json:"product_id,omitempty"`
+	Message   string     `json:"message"`
+	Requested int        `json:"requested,omitempty"`
+	Available int        `json:"available,omitempty"`
+}
+
+// Quote is the price breakdown of a cart. CreateFromCart prices orders with
+// the same code, so an order placed right after a quote costs what the quote
+// said.
+type Quote struct {
+	Lines            []QuoteLine    `json:"lines"`
+	Subtotal         Money          `json:"subtotal"`
+	ProductDiscounts Money          `json:"product_discounts"`
+	CouponCode       *string        `json:"coupon_code,omitempty"`
+	CouponDiscount   Money          `json:"coupon_discount"`
+	DeliveryFee      Money          `json:"delivery_fee"`
+	DistanceKm       *float64       `json:"distance_km,omitempty"`
+	DeliveryZone     *string        `json:"delivery_zone,omitempty"`
+	GrandTotal       Money          `json:"grand_total"`
+	Warnings         []QuoteWarning `json:"warnings"`
+	CanCheckout      bool           `json:"can_checkout"`
+}
+
+// priceCart prices cart items from the given products and options: each line
+// at the product's price plus its options' prices, minus the product's
+// discount. Items whose product is missing are left out, and so are options
+// that are missing.
+func priceCart(items []CartItem, products map[uuid.UUID]*Product, options map[uuid.UUID]*chosenOption) *Quote {
+	quote := &Quote{
+		Lines:            make([]QuoteLine, 0, len(items)),
+		Subtotal:         NewMoney(0),
+		ProductDiscounts: NewMoney(0),
+		CouponDiscount:   NewMoney(0),
+		DeliveryFee:      NewMoney(0),
+		Warnings:         []QuoteWarning{},
+	}
+
+	for _, item := range items {
+		product, ok := products[item.ProductID]
+		if !ok {
+			continue
+		}
+		line := QuoteLine{
+			CartItemID:   item.ID,
+			ProductID:    product.ID,
+			Name:         product.Name,
+			Quantity:     item.Quantity,
+			UnitPrice:    product.Price,
+			UnitDiscount: product.Discount,
+		}
+		for _, optionID := range item.OptionIDs {
+			option, ok := options[optionID]
+			if !ok {
+				continue
+			}
+			line.Options = append(line.Options, QuoteLineOption{
+				OptionID:   option.ID,
+				Group:      option.GroupName,
+				Name:       option.Name,
+				PriceDelta: option.PriceDelta,
+			})
+			line.UnitPrice = line.UnitPrice.Add(option.PriceDelta)
+		}
+		line.LineTotal = line.UnitPrice.Sub(line.UnitDiscount).Mul(item.Quantity)
+		quote.Lines = append(quote.Lines, line)
+		quote.Subtotal = quote.Subtotal.Add(line.UnitPrice.Mul(item.Quantity))
+		quote.ProductDiscounts = quote.ProductDiscounts.Add(product.Discount.Mul(item.Quantity))
+	}
+
+	quote.updateTotal()
+	return quote
+}
+
+// ItemsTotal is what the items cost after product discounts. Coupon minimum
+// baskets are checked against it.
+func (q *Quote) ItemsTotal() Money {
+	return q.Subtotal.Sub(q.ProductDiscounts)
+}
+
+func (q *Quote) updateTotal() {
+	q.GrandTotal = q.ItemsTotal().Sub(q.CouponDiscount).Add(q.DeliveryFee)
+}
+
+// applyCoupon takes the coupon's discount off the grand total.
+func (q *Quote) applyCoupon(coupon *Coupon) {
+	q.CouponCode = &coupon.Code
+	q.CouponDiscount = coupon.Discount(q.ItemsTotal())
+	q.updateTotal()
+}
+
+// pricingStore is what pricing needs to know about the store.
+type pricingStore struct {
+	ID          uuid.UUID `db:"id"`
+	StoreTypeID int       `db:"store_type_id"`
+	Latitude    *float64  `db:"latitude"`
+	Longitude   *float64  `db:"longitude"`
+}
+
+func getPricingStore(db DBInterface, storeID uuid.UUID) (*pricingStore, error) {
+	var store pricingStore
+	query, args, err := QB.Select("id", "store_type_id", "latitude", "longitude").
+		From("stores").
+		Where(squirrel.Eq{"id": storeID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = db.Get(&store, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrStoreNotFound
+		}
+		return nil, fmt.Errorf("error getting store: %v", err)
+	}
+
+	return &store, nil
+}
+
+// applyDelivery adds the store's delivery fee for the given point, after
+// checking the store's radius, or its zones if it serves active ones, and
+// minimum order value. Coupons do not count towards the minimum or the free
+// delivery threshold.
+func (q *Quote) applyDelivery(db DBInterface, store *pricingStore, point *DeliveryPoint) error {
+	settingsDB := &StoreDeliverySettingsDB{db: db}
+	settings, err := settingsDB.Get(store.ID)
+	if err != nil {
+		return err
+	}
+
+	zoneDB := &DeliveryZoneDB{db: db}
+	zones, err := zoneDB.ListActiveByStore(store.ID)
+	if err != nil {
+		return err
+	}
+	if len(zones) > 0 {
+		if point == nil {
+			return ErrDeliveryLocationRequired
+		}
+		zone := zoneFor(zones, *point)
+		if zone == nil {
+			return ErrOutsideDeliveryZone
+		}
+		zone.applyTo(settings)
+		q.DeliveryZone = &zone.Name
+	}
+
+	var distanceKm float64
+	if settings.needsDistance() {
+		if point == nil || store.Latitude == nil || store.Longitude == nil {
+			return ErrDeliveryLocationRequired
+		}
+		distanceKm = utils.HaversineKm(*store.Latitude, *store.Longitude, point.Latitude, point.Longitude)
+		q.DistanceKm = &distanceKm
+	}
+
+	fee, err := settings.Fee(q.ItemsTotal(), distanceKm)
+	if err != nil {
+		return err
+	}
+	q.DeliveryFee = fee
+	q.updateTotal()
+	return nil
+}
+
+// isCouponRuleError reports whether err means the coupon does not apply, as
+// opposed to a failure to check it.
+func isCouponRuleError(err error) bool {
+	for _, target := range []error{ErrCouponNotActive, ErrCouponNotApplicable, ErrCouponMinBasket, ErrCouponUsageLimit, ErrCouponUserLimit} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeliveryRuleError reports whether err means the store does not deliver
+// this order, as opposed to a failure to check it.
+func isDeliveryRuleError(err error) bool {
+	return errors.Is(err, ErrOutsideDeliveryRadius) || errors.Is(err, ErrOutsideDeliveryZone) ||
+		errors.Is(err, ErrBelowMinimumOrder) || errors.Is(err, ErrDeliveryLocationRequired)
+}
+
+// Quote prices the cart at current prices without reserving anything. Items
+// that could not be ordered right now and a coupon that no longer applies are
+// reported as warnings, and the cart cannot be checked out until they are
+// resolved. A coupon that does not apply is left out of the totals, and so is
+// delivery when the store does not deliver to point.
+func (c *CartDB) Quote(cart *Cart, point *DeliveryPoint, now time.Time) (*Quote, error) {
+	cartItemDB := &CartItemDB{db: c.db}
+	items, err := cartItemDB.ListByCart(cart.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs := make([]uuid.UUID, len(items))
+	var optionIDs []uuid.UUID
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+		optionIDs = append(optionIDs, item.OptionIDs...)
+	}
+
+	var rows []Product
+	if len(productIDs) > 0 {
+		query, args, err := QB.Select(products_columns...).From("products").Where(squirrel.Eq{"id": productIDs}).ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("error creating query: %v", err)
+		}
+		err = c.db.Select(&rows, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error getting cart products: %v", err)
+		}
+	}
+	products := make(map[uuid.UUID]*Product, len(rows))
+	for i := range rows {
+		products[rows[i].ID] = &rows[i]
+	}
+
+	options, err := getChosenOptions(c.db, optionIDs)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := checkCartOptions(c.db, items)
+	if err != nil {
+		return nil, err
+	}
+
+	// A product can sit on several lines with different options; its stock
+	// has to cover all of them, as it does when the order reserves it.
+	requested := make(map[uuid.UUID]int, len(products))
+	for _, item := range items {
+		requested[item.ProductID] += item.Quantity
+	}
+	stockWarned := make(map[uuid.UUID]bool)
+
+	quote := priceCart(items, products, options)
+	for _, item := range items {
+		product, ok := products[item.ProductID]
+		switch {
+		case !ok || !product.IsAvailable:
+			productID := item.ProductID
+			quote.Warnings = append(quote.Warnings, QuoteWarning{
+				Code:      QuoteWarningUnavailable,
+				ProductID: &productID,
+				Message:   "المنتج لم يعد متاحاً",
+			})
+		case product.StockQuantity < requested[product.ID]:
+			if stockWarned[product.ID] {
+				continue
+			}
+			stockWarned[product.ID] = true
+			quote.Warnings = append(quote.Warnings, QuoteWarning{
+				Code:      QuoteWarningInsufficientStock,
+				ProductID: &product.ID,
+				Message:   fmt.Sprintf("الكمية المتوفرة من المنتج %s أقل من الكمية في السلة", product.Name),
+				Requested: requested[product.ID],
+				Available: product.StockQuantity,
+			})
+		case problems[item.ID] != "":
+			quote.Warnings = append(quote.Warnings, QuoteWarning{
+				Code:      QuoteWarningOptions,
+				ProductID: &product.ID,
+				Message:   problems[item.ID],
+			})
+		}
+	}
+
+	if cart.StoreID == nil {
+		return quote, nil
+	}
+	store, err := getPricingStore(c.db, *cart.StoreID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A cart scheduled for later only needs the store open when its slot
+	// starts, as at checkout.
+	slotDB := &DeliverySlotDB{db: c.db}
+	openAt, err := slotDB.OpenCheckTime(cart.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	hoursDB := &StoreHoursDB{db: c.db}
+	err = hoursDB.CheckOpen(store.ID, openAt)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrStoreClosed):
+		quote.Warnings = append(quote.Warnings, QuoteWarning{
+			Code:    QuoteWarningStoreClosed,
+			Message: err.Error(),
+		})
+	default:
+		return nil, err
+	}
+
+	if cart.CouponID != nil {
+		couponDB := &CouponDB{db: c.db}
+		coupon, err := couponDB.Get(*cart.CouponID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = couponDB.Check(coupon, CouponBasket{
+			UserID:      cart.UserID,
+			StoreID:     store.ID,
+			StoreTypeID: store.StoreTypeID,
+			Subtotal:    quote.ItemsTotal(),
+		}, now)
+		switch {
+		case err == nil:
+			quote.applyCoupon(coupon)
+		case isCouponRuleError(err):
+			quote.Warnings = append(quote.Warnings, QuoteWarning{
+				Code:    QuoteWarningCoupon,
+				Message: err.Error(),
+			})
+		default:
+			return nil, err
+		}
+	}
+
+	err = quote.applyDelivery(c.db, store, point)
+	switch {
+	case err == nil:
+	case isDeliveryRuleError(err):
+		quote.Warnings = append(quote.Warnings, QuoteWarning{
+			Code:    QuoteWarningDelivery,
+			Message: err.Error(),
+		})
+	default:
+		return nil, err
+	}
+
+	quote.CanCheckout = len(items) > 0 && len(quote.Warnings) == 0
+	return quote, nil
+}
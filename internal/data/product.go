@@ -2,8 +2,10 @@ package data
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"project/utils"
@@ -11,38 +13,81 @@ import (
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Product struct {
-	ID            uuid.UUID `db:"id" json:"id"`
-	StoreID       uuid.UUID `db:"store_id" json:"store_id"`
-	Name          string    `db:"name" json:"name"`
-	Description   *string   `db:"description" json:"description,omitempty"`
-	Price         float64   `db:"price" json:"price"`
-	Discount      float64   `db:"discount" json:"discount"`
-	Image         *string   `db:"image" json:"image,omitempty"`
-	StockQuantity int       `db:"stock_quantity" json:"stock_quantity"`
-	IsAvailable   bool      `db:"is_available" json:"is_available"`
-	CreatedAt     time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
+	ID                uuid.UUID  `db:"id" json:"id"`
+	StoreID           uuid.UUID  `db:"store_id" json:"store_id"`
+	CategoryID        *uuid.UUID `db:"category_id" json:"category_id,omitempty"`
+	SKU               *string    `db:"sku" json:"sku,omitempty"`
+	Name              string     `db:"name" json:"name"`
+	Description       *string    `db:"description" json:"description,omitempty"`
+	Price             Money      `db:"price" json:"price"`
+	Discount          Money      `db:"discount" json:"discount"`
+	Image             *string    `db:"image" json:"image,omitempty"`
+	StockQuantity     int        `db:"stock_quantity" json:"stock_quantity"`
+	LowStockThreshold int        `db:"low_stock_threshold" json:"low_stock_threshold"`
+	IsAvailable       bool       `db:"is_available" json:"is_available"`
+	RatingAvg         float64    `db:"rating_avg" json:"rating_avg"`
+	RatingCount       int        `db:"rating_count" json:"rating_count"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updated_at"`
+
+	// Set when the product is fetched on its own or listed.
+	Tags []string `db:"-" json:"tags,omitempty"`
+}
+
+// DefaultLowStockThreshold is the low stock threshold of new products.
+const DefaultLowStockThreshold = 5
+
+// StockLowPayload is the body of ProductStockLow events.
+type StockLowPayload struct {
+	Product   *Product `json:"product"`
+	Threshold int      `json:"threshold"`
 }
 
 type ProductDB struct {
 	db DBInterface
 }
 
+// InsufficientStockError names the product that could not cover a requested
+// quantity. It matches ErrInvalidQuantity with errors.Is.
+type InsufficientStockError struct {
+	ProductID   uuid.UUID
+	ProductName string
+	Requested   int
+	Available   int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("%v: %s (requested %d, available %d)", ErrInvalidQuantity, e.ProductName, e.Requested, e.Available)
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInvalidQuantity
+}
+
 func ValidateProduct(v *validator.Validator, product *Product) {
 	v.Check(product.Name != "", "name", "يجب ادخال الاسم")
 	v.Check(len(product.Name) <= 150, "name", "يجب ألا يزيد عن 150 حرف")
 	v.Check(product.StoreID != uuid.Nil, "store_id", "يجب ادخال رقم المتجر")
-	v.Check(product.Price >= 0, "price", "يجب أن تكون قيمة إيجابية")
-	v.Check(product.Discount >= 0, "discount", "يجب أن تكون الخصم قيمة إيجابية")
-	v.Check(product.Discount <= product.Price, "discount", "يجب ألا يتجاوز الخصم السعر")
+	if product.SKU != nil {
+		v.Check(*product.SKU != "", "sku", "يجب ادخال رمز المنتج")
+		v.Check(len(*product.SKU) <= 64, "sku", "يجب ألا يزيد رمز المنتج عن 64 حرف")
+	}
+	v.Check(!product.Price.IsNegative(), "price", "يجب أن تكون قيمة إيجابية")
+	v.Check(!product.Discount.IsNegative(), "discount", "يجب أن تكون الخصم قيمة إيجابية")
+	v.Check(product.Discount.Cmp(product.Price) <= 0, "discount", "يجب ألا يتجاوز الخصم السعر")
 	v.Check(product.StockQuantity >= 0, "stock_quantity", "الكمية يجب أن تكون رقم غير سالب")
+	v.Check(product.LowStockThreshold >= 0, "low_stock_threshold", "حد المخزون المنخفض يجب أن يكون رقم غير سالب")
 
 }
 
-func (p *ProductDB) Insert(product *Product) error {
+// Insert creates the product with the given normalized tags, if any. Its
+// initial stock is recorded as an adjustment by actorID.
+func (p *ProductDB) Insert(product *Product, tags []string, actorID uuid.UUID) error {
 	product.ID = uuid.New()
 	product.CreatedAt = time.Now()
 	product.UpdatedAt = time.Now()
@@ -51,12 +96,15 @@ func (p *ProductDB) Insert(product *Product) error {
 		Columns(
 			"id",
 			"store_id",
+			"category_id",
+			"sku",
 			"name",
 			"description",
 			"price",
 			"discount",
 			"image",
 			"stock_quantity",
+			"low_stock_threshold",
 			"is_available",
 			"created_at",
 			"updated_at",
@@ -64,12 +112,15 @@ func (p *ProductDB) Insert(product *Product) error {
 		Values(
 			product.ID,
 			product.StoreID,
+			product.CategoryID,
+			product.SKU,
 			product.Name,
 			product.Description,
 			product.Price,    // Fixed: Price (NUMERIC) goes here
 			product.Discount, // Fixed: Discount (NUMERIC) goes here
 			product.Image,    // Fixed: Image (VARCHAR) goes here
 			product.StockQuantity,
+			product.LowStockThreshold,
 			product.IsAvailable,
 			product.CreatedAt,
 			product.UpdatedAt,
@@ -80,7 +131,49 @@ func (p *ProductDB) Insert(product *Product) error {
 		return fmt.Errorf("error creating query: %v", err)
 	}
 
-	return p.db.QueryRow(query, args...).Scan(&product.ID, &product.CreatedAt, &product.UpdatedAt)
+	tx, err := p.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(query, args...).Scan(&product.ID, &product.CreatedAt, &product.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateSKU
+		}
+		return fmt.Errorf("error inserting product: %v", err)
+	}
+
+	if tags != nil {
+		err = setProductTags(tx, product.ID, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	if product.StockQuantity != 0 {
+		err = recordMovement(tx, &InventoryMovement{
+			ProductID:      product.ID,
+			Type:           MovementAdjustment,
+			QuantityChange: product.StockQuantity,
+			QuantityAfter:  product.StockQuantity,
+			ActorID:        &actorID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	if tags != nil {
+		product.Tags = tags
+	}
+	return nil
 }
 
 func (p *ProductDB) Get(id uuid.UUID) (*Product, error) {
@@ -98,21 +191,63 @@ func (p *ProductDB) Get(id uuid.UUID) (*Product, error) {
 		return nil, fmt.Errorf("error getting product: %v", err)
 	}
 
+	err = p.fillTags([]*Product{&product})
+	if err != nil {
+		return nil, err
+	}
+
 	return &product, nil
 }
-func (p *ProductDB) Update(product *Product) error {
+
+// Update saves the product's details. Its stock is left as it is in the
+// database, which sales may have changed since the product was read, unless
+// stock sets it by hand; the new level is then recorded as an adjustment in
+// the same transaction. product.StockQuantity is refreshed either way.
+// Non-nil tags, which must be normalized, replace the product's tags in the
+// same transaction too.
+func (p *ProductDB) Update(product *Product, tags []string, stock *StockChange) error {
 	product.UpdatedAt = time.Now()
 
-	query, args, err := QB.Update("products").
+	tx, err := p.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Select("stock_quantity").From("products").
+		Where(squirrel.Eq{"id": product.ID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	var before int
+	err = tx.Get(&before, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return fmt.Errorf("error getting product stock: %v", err)
+	}
+
+	product.StockQuantity = before
+	if stock != nil {
+		product.StockQuantity = stock.Quantity
+	}
+
+	query, args, err = QB.Update("products").
 		SetMap(map[string]interface{}{
-			"name":           product.Name,
-			"description":    product.Description,
-			"image":          product.Image,
-			"price":          product.Price,
-			"discount":       product.Discount,
-			"stock_quantity": product.StockQuantity,
-			"is_available":   product.IsAvailable,
-			"updated_at":     product.UpdatedAt,
+			"category_id":         product.CategoryID,
+			"sku":                 product.SKU,
+			"name":                product.Name,
+			"description":         product.Description,
+			"image":               product.Image,
+			"price":               product.Price,
+			"discount":            product.Discount,
+			"stock_quantity":      product.StockQuantity,
+			"low_stock_threshold": product.LowStockThreshold,
+			"is_available":        product.IsAvailable,
+			"updated_at":          product.UpdatedAt,
 		}).
 		Where(squirrel.Eq{"id": product.ID}).
 		ToSql()
@@ -121,14 +256,121 @@ func (p *ProductDB) Update(product *Product) error {
 		return fmt.Errorf("error creating query: %v", err)
 	}
 
-	_, err = p.db.Exec(query, args...)
+	_, err = tx.Exec(query, args...)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateSKU
+		}
 		return fmt.Errorf("error updating product: %v", err)
 	}
 
+	if tags != nil {
+		err = setProductTags(tx, product.ID, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	if product.StockQuantity != before {
+		err = recordMovement(tx, &InventoryMovement{
+			ProductID:      product.ID,
+			Type:           MovementAdjustment,
+			QuantityChange: product.StockQuantity - before,
+			QuantityAfter:  product.StockQuantity,
+			ActorID:        &stock.ActorID,
+			Note:           stock.Note,
+		})
+		if err != nil {
+			return err
+		}
+		err = notifyLowStock(tx, product, before)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	if tags != nil {
+		product.Tags = tags
+	}
 	return nil
 }
 
+// ReserveStock takes quantity units of a product out of stock and returns the
+// updated product. The availability check and the decrement are a single
+// conditional UPDATE, so concurrent checkouts can never oversell; when the
+// product cannot cover the quantity an *InsufficientStockError is returned.
+// The caller records the sale once the order exists.
+func (p *ProductDB) ReserveStock(id uuid.UUID, quantity int) (*Product, error) {
+	query, args, err := QB.Update("products").
+		Set("stock_quantity", squirrel.Expr("stock_quantity - ?", quantity)).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": id, "is_available": true}).
+		Where(squirrel.GtOrEq{"stock_quantity": quantity}).
+		Suffix("RETURNING " + strings.Join(products_columns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	var product Product
+	err = p.db.Get(&product, query, args...)
+	if err == nil {
+		err = notifyLowStock(p.db, &product, product.StockQuantity+quantity)
+		if err != nil {
+			return nil, err
+		}
+		return &product, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error reserving product stock: %v", err)
+	}
+
+	current, err := p.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	available := current.StockQuantity
+	if !current.IsAvailable {
+		available = 0
+	}
+	return nil, &InsufficientStockError{
+		ProductID:   id,
+		ProductName: current.Name,
+		Requested:   quantity,
+		Available:   available,
+	}
+}
+
+// RestoreStock adds quantity back to a product's stock, e.g. when an order
+// is cancelled, and returns the new stock level.
+func (p *ProductDB) RestoreStock(id uuid.UUID, quantity int) (int, error) {
+	query, args, err := QB.Update("products").
+		Set("stock_quantity", squirrel.Expr("stock_quantity + ?", quantity)).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING stock_quantity").
+		ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("error creating query: %v", err)
+	}
+
+	var stock int
+	err = p.db.Get(&stock, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrProductNotFound
+		}
+		return 0, fmt.Errorf("error restoring product stock: %v", err)
+	}
+
+	return stock, nil
+}
+
 func (p *ProductDB) Delete(id uuid.UUID) error {
 	query, args, err := QB.Delete("products").Where(squirrel.Eq{"id": id}).ToSql()
 	if err != nil {
@@ -143,26 +385,58 @@ func (p *ProductDB) Delete(id uuid.UUID) error {
 	return nil
 }
 
+// productTableColumns are the columns of the products table as stored,
+// without the search vector. products_columns is built from them.
+var productTableColumns = []string{
+	"id", "store_id", "category_id", "sku", "name", "description", "price", "discount", "image",
+	"stock_quantity", "low_stock_threshold", "is_available", "rating_avg", "rating_count", "created_at", "updated_at",
+}
+
 type ProductWithStore struct {
 	Product
-	StoreName  string  `db:"store_name" json:"store_name"`
-	StoreImage *string `db:"store_image" json:"store_image,omitempty"`
+	StoreName  string   `db:"store_name" json:"store_name"`
+	StoreImage *string  `db:"store_image" json:"store_image,omitempty"`
+	SearchRank *float64 `db:"search_rank" json:"search_rank,omitempty"`
 }
 
-func (p *ProductDB) List(queryParams url.Values) ([]ProductWithStore, *utils.Meta, error) {
+// ProductFilter narrows down listed products.
+type ProductFilter struct {
+	// CategoryID matches products in the category or its subcategories.
+	CategoryID *uuid.UUID
+	// Tags matches products having all of the tags, which must be
+	// normalized.
+	Tags []string
+}
+
+func (p *ProductDB) List(queryParams url.Values, filter ProductFilter) ([]ProductWithStore, *utils.Meta, error) {
 	var products []ProductWithStore
 
 	joins := []string{
 		" stores s ON p.store_id = s.id",
 	}
 
-	columns := []string{
-		"p.*",
-		"s.name as store_name",
-		"s.image as store_image",
+	columns := make([]string, 0, len(productTableColumns)+2)
+	for _, column := range productTableColumns {
+		columns = append(columns, "p."+column)
+	}
+	columns = append(columns, "s.name as store_name", "s.image as store_image")
+
+	filters := tagFilters("p.id", filter.Tags)
+	if filter.CategoryID != nil {
+		filters = append(filters, descendantsFilter("p.category_id", *filter.CategoryID))
+	}
+
+	queryParams, columns, filters = textSearch(queryParams, "p.search_vector", columns, filters)
+	meta, err := utils.BuildQuery(&products, "products p", joins, columns, nil, queryParams, filters)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	meta, err := utils.BuildQuery(&products, "products p", joins, columns, []string{"p.name", "p.description"}, queryParams, nil)
+	refs := make([]*Product, len(products))
+	for i := range products {
+		refs[i] = &products[i].Product
+	}
+	err = p.fillTags(refs)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -172,7 +446,7 @@ func (p *ProductDB) List(queryParams url.Values) ([]ProductWithStore, *utils.Met
 
 func (p *ProductDB) GetStoreProducts(storeID uuid.UUID) ([]Product, error) {
 	var products []Product
-	query, args, err := QB.Select("*").From("products").Where(squirrel.Eq{"store_id": storeID}).ToSql()
+	query, args, err := QB.Select(productTableColumns...).From("products").Where(squirrel.Eq{"store_id": storeID}).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("error creating query: %v", err)
 	}
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// ProductCategory is a section of a store's menu. Categories with a parent
+// are shown inside it.
+type ProductCategory struct {
+	ID        uuid.UUID  `db:"id" json:"id"`
+	StoreID   uuid.UUID  `db:"store_id" json:"store_id"`
+	ParentID  *uuid.UUID `db:"parent_id" json:"parent_id,omitempty"`
+	Name      string     `db:"name" json:"name"`
+	SortOrder int        `db:"sort_order" json:"sort_order"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// MenuCategory is a category with its products and subcategories, as shown
+// on the store's menu.
+type MenuCategory struct {
+	ProductCategory
+	Products      []Product      `json:"products"`
+	Subcategories []MenuCategory `json:"subcategories"`
+}
+
+// Menu is a store's available products grouped by category.
+type Menu struct {
+	Categories    []MenuCategory `json:"categories"`
+	Uncategorized []Product      `json:"uncategorized"`
+}
+
+type ProductCategoryDB struct {
+	db DBInterface
+}
+
+var productCategoryColumns = []string{"id", "store_id", "parent_id", "name", "sort_order", "created_at", "updated_at"}
+
+func ValidateProductCategory(v *validator.Validator, category *ProductCategory) {
+	v.Check(category.Name != "", "name", "يجب ادخال اسم التصنيف")
+	v.Check(utf8.RuneCountInString(category.Name) <= 100, "name", "يجب ألا يزيد اسم التصنيف عن 100 حرف")
+	v.Check(category.ParentID == nil || *category.ParentID != category.ID, "parent_id", "لا يمكن أن يكون التصنيف أباً لنفسه")
+}
+
+// checkParent makes sure the category's parent is in the same store and is
+// not the category itself or one of its subcategories.
+func (c *ProductCategoryDB) checkParent(category *ProductCategory) error {
+	if category.ParentID == nil {
+		return nil
+	}
+	parent, err := c.Get(*category.ParentID)
+	if err != nil {
+		return err
+	}
+	if parent.StoreID != category.StoreID {
+		return ErrProductCategoryNotFound
+	}
+
+	var cycle bool
+	err = c.db.Get(&cycle, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM product_categories WHERE id = $1
+			UNION
+			SELECT pc.id, pc.parent_id FROM product_categories pc
+			JOIN ancestors a ON pc.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`, parent.ID, category.ID)
+	if err != nil {
+		return fmt.Errorf("error checking category parent: %v", err)
+	}
+	if cycle {
+		return ErrInvalidCategoryParent
+	}
+	return nil
+}
+
+func (c *ProductCategoryDB) Insert(category *ProductCategory) error {
+	category.ID = uuid.New()
+	category.CreatedAt = time.Now()
+	category.UpdatedAt = category.CreatedAt
+
+	err := c.checkParent(category)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := QB.Insert("product_categories").
+		Columns(productCategoryColumns...).
+		Values(category.ID, category.StoreID, category.ParentID, category.Name, category.SortOrder,
+			category.CreatedAt, category.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateProductCategory
+		}
+		return fmt.Errorf("error inserting product category: %v", err)
+	}
+
+	return nil
+}
+
+func (c *ProductCategoryDB) Get(id uuid.UUID) (*ProductCategory, error) {
+	var category ProductCategory
+	query, args, err := QB.Select(productCategoryColumns...).
+		From("product_categories").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = c.db.Get(&category, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrProductCategoryNotFound
+		}
+		return nil, fmt.Errorf("error getting product category: %v", err)
+	}
+
+	return &category, nil
+}
+
+// CheckStore returns ErrProductCategoryNotFound unless the category belongs
+// to the store.
+func (c *ProductCategoryDB) CheckStore(id, storeID uuid.UUID) error {
+	category, err := c.Get(id)
+	if err != nil {
+		return err
+	}
+	if category.StoreID != storeID {
+		return ErrProductCategoryNotFound
+	}
+	return nil
+}
+
+func (c *ProductCategoryDB) Update(category *ProductCategory) error {
+	category.UpdatedAt = time.Now()
+
+	err := c.checkParent(category)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := QB.Update("product_categories").
+		SetMap(map[string]interface{}{
+			"parent_id":  category.ParentID,
+			"name":       category.Name,
+			"sort_order": category.SortOrder,
+			"updated_at": category.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": category.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = c.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateProductCategory
+		}
+		return fmt.Errorf("error updating product category: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the category and its subcategories. Their products are
+// left uncategorized.
+func (c *ProductCategoryDB) Delete(id uuid.UUID) error {
+	query, args, err := QB.Delete("product_categories").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting product category: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProductCategoryNotFound
+	}
+
+	return nil
+}
+
+// ListByStore returns all of the store's categories in menu order: by sort
+// order, then name.
+func (c *ProductCategoryDB) ListByStore(storeID uuid.UUID) ([]ProductCategory, error) {
+	categories := []ProductCategory{}
+	query, args, err := QB.Select(productCategoryColumns...).
+		From("product_categories").
+		Where(squirrel.Eq{"store_id": storeID}).
+		OrderBy("sort_order", "name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = c.db.Select(&categories, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing product categories: %v", err)
+	}
+
+	return categories, nil
+}
+
+// Menu returns the store's available products grouped by category.
+// Categories with no products in them or in their subcategories are left
+// out.
+func (c *ProductCategoryDB) Menu(storeID uuid.UUID) (*Menu, error) {
+	categories, err := c.ListByStore(storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []Product{}
+	query, args, err := QB.Select(products_columns...).
+		From("products").
+		Where(squirrel.Eq{"store_id": storeID, "is_available": true}).
+		OrderBy("name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	err = c.db.Select(&products, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing menu products: %v", err)
+	}
+
+	productDB := &ProductDB{db: c.db}
+	refs := make([]*Product, len(products))
+	for i := range products {
+		refs[i] = &products[i]
+	}
+	err = productDB.fillTags(refs)
+	if err != nil {
+		return nil, err
+	}
+
+	menu := &Menu{Categories: []MenuCategory{}, Uncategorized: []Product{}}
+	byCategory := make(map[uuid.UUID][]Product)
+	for _, product := range products {
+		if product.CategoryID == nil {
+			menu.Uncategorized = append(menu.Uncategorized, product)
+			continue
+		}
+		byCategory[*product.CategoryID] = append(byCategory[*product.CategoryID], product)
+	}
+
+	children := make(map[uuid.UUID][]ProductCategory)
+	var roots []ProductCategory
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+			continue
+		}
+		children[*category.ParentID] = append(children[*category.ParentID], category)
+	}
+
+	var build func(category ProductCategory) (MenuCategory, bool)
+	build = func(category ProductCategory) (MenuCategory, bool) {
+		section := MenuCategory{
+			ProductCategory: category,
+			Products:        byCategory[category.ID],
+			Subcategories:   []MenuCategory{},
+		}
+		if section.Products == nil {
+			section.Products = []Product{}
+		}
+		for _, child := range children[category.ID] {
+			if sub, ok := build(child); ok {
+				section.Subcategories = append(section.Subcategories, sub)
+			}
+		}
+		return section, len(section.Products) > 0 || len(section.Subcategories) > 0
+	}
+	for _, root := range roots {
+		if section, ok := build(root); ok {
+			menu.Categories = append(menu.Categories, section)
+		}
+	}
+
+	return menu, nil
+}
+
+// descendantsFilter is the SQL condition for products in the category or in
+// any of its subcategories.
+func descendantsFilter(column string, categoryID uuid.UUID) string {
+	return fmt.Sprintf(`%s IN (
+		WITH RECURSIVE subcategories AS (
+			SELECT id FROM product_categories WHERE id = '%s'
+			UNION
+			SELECT pc.id FROM product_categories pc JOIN subcategories s ON pc.parent_id = s.id
+		)
+		SELECT id FROM subcategories)`, column, categoryID)
+}
@@ -0,0 +1,555 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// MaxImportRows is how many products a single CSV import can hold.
+const MaxImportRows = 5000
+
+// ProductCSVColumns are the columns of product CSV exports, named after the
+// products table. Imports need name and price; the other columns are
+// optional and may come in any order.
+var ProductCSVColumns = []string{
+	"sku", "name", "description", "price", "discount", "stock_quantity", "low_stock_threshold", "is_available", "category_id",
+}
+
+// ProductImportRow is a product read from a line of a CSV import.
+type ProductImportRow struct {
+	Line    int
+	Product Product
+	// Columns are the columns the file sets; when a row updates a product
+	// the other ones are left as they are.
+	Columns []string
+}
+
+// ImportRowError reports why a line of a CSV import was rejected.
+type ImportRowError struct {
+	Line   int               `json:"line"`
+	SKU    *string           `json:"sku,omitempty"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ProductImportOptions control how ProductDB.Import writes the rows.
+type ProductImportOptions struct {
+	// Upsert updates the store's products whose SKU matches a row. Without
+	// it such rows are rejected.
+	Upsert bool
+	// DryRun checks the rows and counts what would change without writing.
+	DryRun  bool
+	ActorID uuid.UUID
+}
+
+// ProductImportResult is the report of a CSV import.
+type ProductImportResult struct {
+	DryRun  bool             `json:"dry_run"`
+	Created int              `json:"created"`
+	Updated int              `json:"updated"`
+	Errors  []ImportRowError `json:"errors"`
+}
+
+// ReadProductCSV reads the products of a CSV file for the store. Rows that
+// cannot be parsed or fail ValidateProduct are returned as errors with their
+// line; an error is returned only when the file itself is unusable.
+func ReadProductCSV(r io.Reader, storeID uuid.UUID) ([]ProductImportRow, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, errors.New("ملف CSV فارغ")
+		}
+		return nil, nil, fmt.Errorf("ملف CSV غير صالح: %v", err)
+	}
+	// Lines with the wrong number of fields are reported like any other bad
+	// row rather than failing the whole file.
+	reader.FieldsPerRecord = -1
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !validator.In(name, ProductCSVColumns...) {
+			return nil, nil, fmt.Errorf("عمود غير معروف في ملف CSV: %s", name)
+		}
+		if _, ok := index[name]; ok {
+			return nil, nil, fmt.Errorf("عمود مكرر في ملف CSV: %s", name)
+		}
+		index[name] = i
+	}
+	for _, name := range []string{"name", "price"} {
+		if _, ok := index[name]; !ok {
+			return nil, nil, fmt.Errorf("عمود %s مطلوب في ملف CSV", name)
+		}
+	}
+	columns := make([]string, 0, len(index))
+	for _, name := range ProductCSVColumns {
+		if _, ok := index[name]; ok {
+			columns = append(columns, name)
+		}
+	}
+
+	rows := []ProductImportRow{}
+	rowErrors := []ImportRowError{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows)+len(rowErrors) == MaxImportRows {
+			return nil, nil, fmt.Errorf("يجب ألا يزيد عدد المنتجات في ملف CSV عن %d", MaxImportRows)
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, ImportRowError{
+				Line:   parseErr.StartLine,
+				Errors: map[string]string{"row": fmt.Sprintf("صيغة السطر غير صالحة: %v", parseErr.Err)},
+			})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("ملف CSV غير صالح: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			rowErrors = append(rowErrors, ImportRowError{
+				Line:   line,
+				Errors: map[string]string{"row": fmt.Sprintf("عدد الحقول %d لا يطابق عدد الأعمدة %d", len(record), len(header))},
+			})
+			continue
+		}
+
+		field := func(name string) (string, bool) {
+			i, ok := index[name]
+			if !ok {
+				return "", false
+			}
+			return strings.TrimSpace(record[i]), true
+		}
+		row, v := parseProductRecord(field, storeID)
+		if !v.Valid() {
+			rowErrors = append(rowErrors, ImportRowError{Line: line, SKU: row.SKU, Errors: v.Errors})
+			continue
+		}
+		rows = append(rows, ProductImportRow{Line: line, Product: row, Columns: columns})
+	}
+
+	return rows, rowErrors, nil
+}
+
+// parseProductRecord builds a product from the fields of a CSV record, with
+// the defaults of CreateProductHandler for missing ones, and validates it.
+func parseProductRecord(field func(name string) (string, bool), storeID uuid.UUID) (Product, *validator.Validator) {
+	v := validator.New()
+	product := Product{
+		StoreID:           storeID,
+		Discount:          NewMoney(0),
+		LowStockThreshold: DefaultLowStockThreshold,
+		IsAvailable:       true,
+	}
+
+	if sku, _ := field("sku"); sku != "" {
+		product.SKU = &sku
+	}
+	product.Name, _ = field("name")
+	if description, _ := field("description"); description != "" {
+		product.Description = &description
+	}
+	if price, _ := field("price"); price != "" {
+		val, err := ParseMoney(price)
+		v.Check(err == nil, "price", "السعر يجب أن يكون رقمًا صالحًا (مثال: 30 أو 40.50)")
+		product.Price = val
+	} else {
+		v.AddError("price", "يجب إدخال السعر")
+	}
+	if discount, _ := field("discount"); discount != "" {
+		val, err := ParseMoney(discount)
+		v.Check(err == nil, "discount", "الخصم يجب أن يكون رقمًا صالحًا (مثال: 0 أو 5.50)")
+		product.Discount = val
+	}
+	if stock, _ := field("stock_quantity"); stock != "" {
+		val, err := strconv.Atoi(stock)
+		v.Check(err == nil, "stock_quantity", "كمية المخزون يجب أن تكون عددًا صحيحًا صالحًا (مثال: 0 أو 100)")
+		product.StockQuantity = val
+	}
+	if threshold, _ := field("low_stock_threshold"); threshold != "" {
+		val, err := strconv.Atoi(threshold)
+		v.Check(err == nil, "low_stock_threshold", "حد المخزون المنخفض يجب أن يكون عددًا صحيحًا صالحًا (مثال: 5)")
+		product.LowStockThreshold = val
+	}
+	if available, _ := field("is_available"); available != "" {
+		val, err := strconv.ParseBool(available)
+		v.Check(err == nil, "is_available", "قيمة is_available غير صالحة")
+		product.IsAvailable = val
+	}
+	if categoryID, _ := field("category_id"); categoryID != "" {
+		val, err := uuid.Parse(categoryID)
+		v.Check(err == nil, "category_id", "معرف التصنيف غير صالح")
+		product.CategoryID = &val
+	}
+
+	ValidateProduct(v, &product)
+	return product, v
+}
+
+// productCopyColumns are the columns new products are copied into.
+var productCopyColumns = []string{
+	"id", "store_id", "category_id", "sku", "name", "description", "price", "discount",
+	"stock_quantity", "low_stock_threshold", "is_available", "created_at", "updated_at",
+}
+
+// Import writes the rows read by ReadProductCSV to the store in a single
+// transaction. New products are inserted with COPY; with opts.Upsert, rows
+// whose SKU the store already uses update that product. Rows naming a
+// category of another store, or repeating an SKU of the file, are rejected.
+// Stock set by the import is recorded in the products' inventory.
+func (p *ProductDB) Import(storeID uuid.UUID, rows []ProductImportRow, opts ProductImportOptions) (*ProductImportResult, error) {
+	result := &ProductImportResult{DryRun: opts.DryRun, Errors: []ImportRowError{}}
+
+	tx, err := p.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var categoryIDs []uuid.UUID
+	var skus []string
+	for _, row := range rows {
+		if row.Product.CategoryID != nil {
+			categoryIDs = append(categoryIDs, *row.Product.CategoryID)
+		}
+		if row.Product.SKU != nil {
+			skus = append(skus, *row.Product.SKU)
+		}
+	}
+
+	var storeCategories []uuid.UUID
+	query, args, err := QB.Select("id").
+		From("product_categories").
+		Where(squirrel.Eq{"store_id": storeID, "id": categoryIDs}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	err = tx.Select(&storeCategories, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting store categories: %v", err)
+	}
+
+	// Lock the products being updated so their stock movements are exact
+	var existing []Product
+	query, args, err = QB.Select(products_columns...).
+		From("products").
+		Where(squirrel.Eq{"store_id": storeID, "sku": skus}).
+		OrderBy("id").
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	err = tx.Select(&existing, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting products by sku: %v", err)
+	}
+	bySKU := make(map[string]int, len(existing))
+	for i, product := range existing {
+		bySKU[*product.SKU] = i
+	}
+
+	var inserts, updates []ProductImportRow
+	var stockBefore []int
+	seen := make(map[string]int, len(skus))
+	for _, row := range rows {
+		v := validator.New()
+		product := row.Product
+		if product.CategoryID != nil {
+			v.Check(validator.InUUID(*product.CategoryID, storeCategories), "category_id", "التصنيف غير موجود")
+		}
+		if product.SKU != nil {
+			if line, ok := seen[*product.SKU]; ok {
+				v.AddError("sku", fmt.Sprintf("رمز المنتج مكرر في السطر %d", line))
+			}
+			seen[*product.SKU] = row.Line
+		}
+		if !v.Valid() {
+			result.Errors = append(result.Errors, ImportRowError{Line: row.Line, SKU: product.SKU, Errors: v.Errors})
+			continue
+		}
+
+		i, ok := -1, false
+		if product.SKU != nil {
+			i, ok = bySKU[*product.SKU]
+		}
+		switch {
+		case !ok:
+			inserts = append(inserts, row)
+		case opts.Upsert:
+			merged, v := mergeImportedProduct(existing[i], row)
+			if !v.Valid() {
+				result.Errors = append(result.Errors, ImportRowError{Line: row.Line, SKU: product.SKU, Errors: v.Errors})
+				continue
+			}
+			row.Product = merged
+			updates = append(updates, row)
+			stockBefore = append(stockBefore, existing[i].StockQuantity)
+		default:
+			result.Errors = append(result.Errors, ImportRowError{
+				Line:   row.Line,
+				SKU:    product.SKU,
+				Errors: map[string]string{"sku": ErrDuplicateSKU.Error()},
+			})
+		}
+	}
+	result.Created = len(inserts)
+	result.Updated = len(updates)
+	if opts.DryRun {
+		return result, nil
+	}
+
+	err = copyImportedProducts(tx, inserts, opts.ActorID)
+	if err != nil {
+		return nil, err
+	}
+	for i, row := range updates {
+		err = updateImportedProduct(tx, &row, stockBefore[i], opts.ActorID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return result, nil
+}
+
+// copyImportedProducts inserts new products, and the import movements of
+// their initial stock, with COPY.
+func copyImportedProducts(tx *sqlx.Tx, rows []ProductImportRow, actorID uuid.UUID) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	now := time.Now()
+
+	stmt, err := tx.Prepare(pq.CopyIn("products", productCopyColumns...))
+	if err != nil {
+		return fmt.Errorf("error preparing product copy: %v", err)
+	}
+	for i := range rows {
+		product := &rows[i].Product
+		product.ID = uuid.New()
+		product.CreatedAt = now
+		product.UpdatedAt = now
+		_, err = stmt.Exec(product.ID, product.StoreID, product.CategoryID, product.SKU, product.Name,
+			product.Description, product.Price, product.Discount, product.StockQuantity,
+			product.LowStockThreshold, product.IsAvailable, product.CreatedAt, product.UpdatedAt)
+		if err != nil {
+			stmt.Close()
+			return fmt.Errorf("error copying product: %v", err)
+		}
+	}
+	err = closeCopy(stmt)
+	if err != nil {
+		return err
+	}
+
+	stmt, err = tx.Prepare(pq.CopyIn("inventory_movements", inventoryMovementColumns...))
+	if err != nil {
+		return fmt.Errorf("error preparing inventory copy: %v", err)
+	}
+	for _, row := range rows {
+		if row.Product.StockQuantity == 0 {
+			continue
+		}
+		_, err = stmt.Exec(uuid.New(), row.Product.ID, MovementImport, row.Product.StockQuantity,
+			row.Product.StockQuantity, nil, actorID, nil, now)
+		if err != nil {
+			stmt.Close()
+			return fmt.Errorf("error copying inventory movement: %v", err)
+		}
+	}
+	return closeCopy(stmt)
+}
+
+// closeCopy flushes a COPY statement. A clash with an SKU created since the
+// import checked them is reported as ErrDuplicateSKU.
+func closeCopy(stmt *sql.Stmt) error {
+	_, err := stmt.Exec()
+	if err != nil {
+		stmt.Close()
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateSKU
+		}
+		return fmt.Errorf("error copying rows: %v", err)
+	}
+	return stmt.Close()
+}
+
+// mergeImportedProduct sets the columns of the file on a copy of the stored
+// product and validates the result. A row is checked against what the product
+// will be after the update, since columns the file leaves out keep their
+// stored values rather than the defaults the row was parsed with.
+func mergeImportedProduct(stored Product, row ProductImportRow) (Product, *validator.Validator) {
+	merged := stored
+	imported := row.Product
+	for _, column := range row.Columns {
+		switch column {
+		case "sku":
+			merged.SKU = imported.SKU
+		case "name":
+			merged.Name = imported.Name
+		case "description":
+			merged.Description = imported.Description
+		case "price":
+			merged.Price = imported.Price
+		case "discount":
+			merged.Discount = imported.Discount
+		case "stock_quantity":
+			merged.StockQuantity = imported.StockQuantity
+		case "low_stock_threshold":
+			merged.LowStockThreshold = imported.LowStockThreshold
+		case "is_available":
+			merged.IsAvailable = imported.IsAvailable
+		case "category_id":
+			merged.CategoryID = imported.CategoryID
+		}
+	}
+
+	v := validator.New()
+	ValidateProduct(v, &merged)
+	return merged, v
+}
+
+// updateImportedProduct saves the columns of the file onto an existing
+// product, recording a stock change as an import movement.
+func updateImportedProduct(tx *sqlx.Tx, row *ProductImportRow, before int, actorID uuid.UUID) error {
+	product := &row.Product
+	product.UpdatedAt = time.Now()
+
+	values := map[string]interface{}{
+		"sku":                 product.SKU,
+		"name":                product.Name,
+		"description":         product.Description,
+		"price":               product.Price,
+		"discount":            product.Discount,
+		"stock_quantity":      product.StockQuantity,
+		"low_stock_threshold": product.LowStockThreshold,
+		"is_available":        product.IsAvailable,
+		"category_id":         product.CategoryID,
+	}
+	changes := map[string]interface{}{"updated_at": product.UpdatedAt}
+	for _, column := range row.Columns {
+		changes[column] = values[column]
+	}
+
+	query, args, err := QB.Update("products").
+		SetMap(changes).
+		Where(squirrel.Eq{"id": product.ID}).
+		Suffix("RETURNING " + strings.Join(products_columns, ", ")).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	err = tx.Get(product, query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating imported product: %v", err)
+	}
+
+	if product.StockQuantity == before {
+		return nil
+	}
+	err = recordMovement(tx, &InventoryMovement{
+		ProductID:      product.ID,
+		Type:           MovementImport,
+		QuantityChange: product.StockQuantity - before,
+		QuantityAfter:  product.StockQuantity,
+		ActorID:        &actorID,
+	})
+	if err != nil {
+		return err
+	}
+	return notifyLowStock(tx, product, before)
+}
+
+// ExportCSV writes the store's products to w as CSV, in the columns of
+// ProductCSVColumns, reading them one row at a time.
+func (p *ProductDB) ExportCSV(storeID uuid.UUID, w io.Writer) error {
+	query, args, err := QB.Select(ProductCSVColumns...).
+		From("products").
+		Where(squirrel.Eq{"store_id": storeID}).
+		OrderBy("name", "id").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	rows, err := p.db.(*sqlx.DB).Queryx(query, args...)
+	if err != nil {
+		return fmt.Errorf("error exporting products: %v", err)
+	}
+	defer rows.Close()
+
+	writer := csv.NewWriter(w)
+	err = writer.Write(ProductCSVColumns)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var product Product
+		err = rows.StructScan(&product)
+		if err != nil {
+			return fmt.Errorf("error reading exported product: %v", err)
+		}
+		err = writer.Write(productCSVRecord(&product))
+		if err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error exporting products: %v", err)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// productCSVRecord is the record of a product in a CSV export.
+func productCSVRecord(product *Product) []string {
+	optional := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	categoryID := ""
+	if product.CategoryID != nil {
+		categoryID = product.CategoryID.String()
+	}
+	return []string{
+		optional(product.SKU),
+		product.Name,
+		optional(product.Description),
+		product.Price.String(),
+		product.Discount.String(),
+		strconv.Itoa(product.StockQuantity),
+		strconv.Itoa(product.LowStockThreshold),
+		strconv.FormatBool(product.IsAvailable),
+		categoryID,
+	}
+}
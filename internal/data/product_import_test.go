@@ -0,0 +1,72 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestMergeImportedProductValidatesStoredDiscount(t *testing.T) {
+	storeID := uuid.New()
+	sku := "TEA-1"
+	stored := Product{
+		ID:                uuid.New(),
+		StoreID:           storeID,
+		SKU:               &sku,
+		Name:              "tea",
+		Price:             NewMoney(1000),
+		Discount:          NewMoney(800),
+		StockQuantity:     4,
+		LowStockThreshold: 2,
+		IsAvailable:       true,
+	}
+
+	tests := []struct {
+		name      string
+		csv       string
+		wantValid bool
+		wantPrice int64
+	}{
+		{
+			name:      "lowered price below the stored discount",
+			csv:       "sku,name,price\nTEA-1,tea,5\n",
+			wantValid: false,
+		},
+		{
+			name:      "lowered price with a lowered discount",
+			csv:       "sku,name,price,discount\nTEA-1,tea,5,1\n",
+			wantValid: true,
+			wantPrice: 500,
+		},
+		{
+			name:      "raised price keeps the stored discount",
+			csv:       "sku,name,price\nTEA-1,tea,12\n",
+			wantValid: true,
+			wantPrice: 1200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, rowErrors, err := ReadProductCSV(strings.NewReader(tt.csv), storeID)
+			if err != nil || len(rowErrors) != 0 || len(rows) != 1 {
+				t.Fatalf("ReadProductCSV: %d rows, errors %v, %v", len(rows), rowErrors, err)
+			}
+
+			merged, v := mergeImportedProduct(stored, rows[0])
+			if v.Valid() != tt.wantValid {
+				t.Fatalf("valid = %v, want %v (errors %v)", v.Valid(), tt.wantValid, v.Errors)
+			}
+			if !tt.wantValid {
+				return
+			}
+			if merged.ID != stored.ID || merged.Price.Amount != tt.wantPrice {
+				t.Errorf("merged product %s at %d, want %s at %d", merged.ID, merged.Price.Amount, stored.ID, tt.wantPrice)
+			}
+			if merged.StockQuantity != stored.StockQuantity || merged.LowStockThreshold != stored.LowStockThreshold {
+				t.Errorf("columns missing from the file changed: stock %d, threshold %d",
+					merged.StockQuantity, merged.LowStockThreshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,527 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+const (
+	OptionSelectSingle = "single"
+	OptionSelectMulti  = "multi"
+)
+
+// ProductOptionGroup is a choice customers make when ordering a product,
+// such as its size. A required group needs at least MinSelect picks, and at
+// least one; an optional group can be left out, or picked at least MinSelect
+// times. No group takes more than MaxSelect picks.
+type ProductOptionGroup struct {
+	ID            uuid.UUID `db:"id" json:"id"`
+	ProductID     uuid.UUID `db:"product_id" json:"product_id"`
+	Name          string    `db:"name" json:"name"`
+	SelectionType string    `db:"selection_type" json:"selection_type"`
+	IsRequired    bool      `db:"is_required" json:"is_required"`
+	MinSelect     int       `db:"min_select" json:"min_select"`
+	MaxSelect     int       `db:"max_select" json:"max_select"`
+	SortOrder     int       `db:"sort_order" json:"sort_order"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
+
+	Options []ProductOption `db:"-" json:"options"`
+}
+
+// ProductOption is one pick of a group, adding PriceDelta to the product's
+// price. StockQuantity is nil when the option's stock is not tracked.
+type ProductOption struct {
+	ID            uuid.UUID `db:"id" json:"id"`
+	GroupID       uuid.UUID `db:"group_id" json:"group_id"`
+	Name          string    `db:"name" json:"name"`
+	PriceDelta    Money     `db:"price_delta" json:"price_delta"`
+	StockQuantity *int      `db:"stock_quantity" json:"stock_quantity,omitempty"`
+	IsAvailable   bool      `db:"is_available" json:"is_available"`
+	SortOrder     int       `db:"sort_order" json:"sort_order"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// chosenOption is an option picked for a cart item, with the product and
+// group it belongs to.
+type chosenOption struct {
+	ProductOption
+	ProductID uuid.UUID `db:"product_id"`
+	GroupName string    `db:"group_name"`
+}
+
+type ProductOptionDB struct {
+	db DBInterface
+}
+
+var (
+	optionGroupColumns = []string{
+		"id", "product_id", "name", "selection_type", "is_required", "min_select", "max_select",
+		"sort_order", "created_at", "updated_at",
+	}
+	productOptionColumns = []string{
+		"id", "group_id", "name", "price_delta", "stock_quantity", "is_available", "sort_order",
+		"created_at", "updated_at",
+	}
+	chosenOptionColumns = []string{
+		"o.id", "o.group_id", "o.name", "o.price_delta", "o.stock_quantity", "o.is_available", "o.sort_order",
+		"o.created_at", "o.updated_at", "g.product_id", "g.name AS group_name",
+	}
+)
+
+func ValidateOptionGroup(v *validator.Validator, group *ProductOptionGroup) {
+	v.Check(group.Name != "", "name", "يجب ادخال اسم المجموعة")
+	v.Check(utf8.RuneCountInString(group.Name) <= 100, "name", "يجب ألا يزيد اسم المجموعة عن 100 حرف")
+	v.Check(validator.In(group.SelectionType, OptionSelectSingle, OptionSelectMulti), "selection_type", "نوع الاختيار يجب أن يكون single أو multi")
+	v.Check(group.MinSelect >= 0, "min_select", "الحد الأدنى للاختيارات يجب أن يكون غير سالب")
+	v.Check(group.MaxSelect >= 1, "max_select", "الحد الأقصى للاختيارات يجب أن يكون 1 على الأقل")
+	v.Check(group.MaxSelect >= group.MinSelect, "max_select", "الحد الأقصى للاختيارات يجب ألا يقل عن الحد الأدنى")
+	v.Check(group.SelectionType != OptionSelectSingle || group.MaxSelect == 1, "max_select", "مجموعة الاختيار الواحد تقبل اختياراً واحداً فقط")
+}
+
+func ValidateProductOption(v *validator.Validator, option *ProductOption) {
+	v.Check(option.Name != "", "name", "يجب ادخال اسم الخيار")
+	v.Check(utf8.RuneCountInString(option.Name) <= 100, "name", "يجب ألا يزيد اسم الخيار عن 100 حرف")
+	v.Check(!option.PriceDelta.IsNegative(), "price_delta", "فرق السعر يجب أن يكون غير سالب")
+	v.Check(option.StockQuantity == nil || *option.StockQuantity >= 0, "stock_quantity", "الكمية يجب أن تكون رقم غير سالب")
+}
+
+// ValidateOptionChoice checks the options picked for quantity units of a
+// product against the product's groups: every option must belong to one of
+// them and be in stock, and every group must be picked as its rules say.
+func ValidateOptionChoice(v *validator.Validator, groups []ProductOptionGroup, optionIDs []uuid.UUID, quantity int) {
+	type pick struct {
+		group  *ProductOptionGroup
+		option *ProductOption
+	}
+	options := make(map[uuid.UUID]pick)
+	for i := range groups {
+		for j := range groups[i].Options {
+			options[groups[i].Options[j].ID] = pick{group: &groups[i], option: &groups[i].Options[j]}
+		}
+	}
+
+	picked := make(map[uuid.UUID]int, len(groups))
+	seen := make(map[uuid.UUID]bool, len(optionIDs))
+	for _, id := range optionIDs {
+		p, ok := options[id]
+		if !ok {
+			v.AddError("option_ids", "أحد الخيارات لا يخص هذا المنتج")
+			continue
+		}
+		if seen[id] {
+			v.AddError("option_ids", "لا يمكن اختيار الخيار نفسه أكثر من مرة")
+			continue
+		}
+		seen[id] = true
+		picked[p.group.ID]++
+
+		if !p.option.IsAvailable || (p.option.StockQuantity != nil && *p.option.StockQuantity < quantity) {
+			v.AddError("option_ids", fmt.Sprintf("الخيار %s غير متوفر بالكمية المطلوبة", p.option.Name))
+		}
+	}
+
+	for _, group := range groups {
+		count := picked[group.ID]
+		switch {
+		case group.IsRequired && count < max(group.MinSelect, 1):
+			v.AddError("options", fmt.Sprintf("يجب اختيار %d على الأقل من %s", max(group.MinSelect, 1), group.Name))
+		case !group.IsRequired && count > 0 && count < group.MinSelect:
+			v.AddError("options", fmt.Sprintf("يجب اختيار %d على الأقل من %s أو تركها", group.MinSelect, group.Name))
+		case count > group.MaxSelect:
+			v.AddError("options", fmt.Sprintf("يجب ألا يزيد الاختيار من %s عن %d", group.Name, group.MaxSelect))
+		}
+	}
+}
+
+// SameOptions reports whether a and b hold the same options, in any order.
+func SameOptions(a, b []uuid.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[uuid.UUID]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
+
+func (p *ProductOptionDB) InsertGroup(group *ProductOptionGroup) error {
+	group.ID = uuid.New()
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	group.Options = []ProductOption{}
+
+	query, args, err := QB.Insert("product_option_groups").
+		Columns(optionGroupColumns...).
+		Values(group.ID, group.ProductID, group.Name, group.SelectionType, group.IsRequired, group.MinSelect,
+			group.MaxSelect, group.SortOrder, group.CreatedAt, group.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting option group: %v", err)
+	}
+
+	return nil
+}
+
+// GetGroup returns the group without its options.
+func (p *ProductOptionDB) GetGroup(id uuid.UUID) (*ProductOptionGroup, error) {
+	var group ProductOptionGroup
+	query, args, err := QB.Select(optionGroupColumns...).
+		From("product_option_groups").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = p.db.Get(&group, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOptionGroupNotFound
+		}
+		return nil, fmt.Errorf("error getting option group: %v", err)
+	}
+
+	return &group, nil
+}
+
+func (p *ProductOptionDB) UpdateGroup(group *ProductOptionGroup) error {
+	group.UpdatedAt = time.Now()
+
+	query, args, err := QB.Update("product_option_groups").
+		SetMap(map[string]interface{}{
+			"name":           group.Name,
+			"selection_type": group.SelectionType,
+			"is_required":    group.IsRequired,
+			"min_select":     group.MinSelect,
+			"max_select":     group.MaxSelect,
+			"sort_order":     group.SortOrder,
+			"updated_at":     group.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": group.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating option group: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteGroup removes the group with its options. Carts lose the options
+// picked from it; orders keep their copies.
+func (p *ProductOptionDB) DeleteGroup(id uuid.UUID) error {
+	query, args, err := QB.Delete("product_option_groups").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting option group: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrOptionGroupNotFound
+	}
+
+	return nil
+}
+
+func (p *ProductOptionDB) InsertOption(option *ProductOption) error {
+	option.ID = uuid.New()
+	option.CreatedAt = time.Now()
+	option.UpdatedAt = option.CreatedAt
+
+	query, args, err := QB.Insert("product_options").
+		Columns(productOptionColumns...).
+		Values(option.ID, option.GroupID, option.Name, option.PriceDelta, option.StockQuantity, option.IsAvailable,
+			option.SortOrder, option.CreatedAt, option.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting product option: %v", err)
+	}
+
+	return nil
+}
+
+func (p *ProductOptionDB) GetOption(id uuid.UUID) (*ProductOption, error) {
+	var option ProductOption
+	query, args, err := QB.Select(productOptionColumns...).
+		From("product_options").
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = p.db.Get(&option, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrProductOptionNotFound
+		}
+		return nil, fmt.Errorf("error getting product option: %v", err)
+	}
+
+	return &option, nil
+}
+
+func (p *ProductOptionDB) UpdateOption(option *ProductOption) error {
+	option.UpdatedAt = time.Now()
+
+	query, args, err := QB.Update("product_options").
+		SetMap(map[string]interface{}{
+			"name":           option.Name,
+			"price_delta":    option.PriceDelta,
+			"stock_quantity": option.StockQuantity,
+			"is_available":   option.IsAvailable,
+			"sort_order":     option.SortOrder,
+			"updated_at":     option.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": option.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating product option: %v", err)
+	}
+
+	return nil
+}
+
+func (p *ProductOptionDB) DeleteOption(id uuid.UUID) error {
+	query, args, err := QB.Delete("product_options").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := p.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting product option: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProductOptionNotFound
+	}
+
+	return nil
+}
+
+// ListByProduct returns the product's groups with their options, both in
+// sort order.
+func (p *ProductOptionDB) ListByProduct(productID uuid.UUID) ([]ProductOptionGroup, error) {
+	groups, err := listOptionGroups(p.db, productID)
+	if err != nil {
+		return nil, err
+	}
+	return groups[productID], nil
+}
+
+// listOptionGroups returns the groups of each of the products, with their
+// options. Every product has an entry, even when it has no groups.
+func listOptionGroups(db DBInterface, productIDs ...uuid.UUID) (map[uuid.UUID][]ProductOptionGroup, error) {
+	byProduct := make(map[uuid.UUID][]ProductOptionGroup, len(productIDs))
+	for _, id := range productIDs {
+		byProduct[id] = []ProductOptionGroup{}
+	}
+	if len(productIDs) == 0 {
+		return byProduct, nil
+	}
+
+	var groups []ProductOptionGroup
+	query, args, err := QB.Select(optionGroupColumns...).
+		From("product_option_groups").
+		Where(squirrel.Eq{"product_id": productIDs}).
+		OrderBy("sort_order", "name").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	err = db.Select(&groups, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing option groups: %v", err)
+	}
+
+	groupIDs := make([]uuid.UUID, len(groups))
+	for i, group := range groups {
+		groupIDs[i] = group.ID
+	}
+	var options []ProductOption
+	if len(groupIDs) > 0 {
+		query, args, err = QB.Select(productOptionColumns...).
+			From("product_options").
+			Where(squirrel.Eq{"group_id": groupIDs}).
+			OrderBy("sort_order", "name").
+			ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("error creating query: %v", err)
+		}
+		err = db.Select(&options, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error listing product options: %v", err)
+		}
+	}
+
+	byGroup := make(map[uuid.UUID][]ProductOption, len(groups))
+	for _, option := range options {
+		byGroup[option.GroupID] = append(byGroup[option.GroupID], option)
+	}
+	for _, group := range groups {
+		group.Options = byGroup[group.ID]
+		if group.Options == nil {
+			group.Options = []ProductOption{}
+		}
+		byProduct[group.ProductID] = append(byProduct[group.ProductID], group)
+	}
+	return byProduct, nil
+}
+
+// getChosenOptions returns the given options by ID.
+func getChosenOptions(db DBInterface, ids []uuid.UUID) (map[uuid.UUID]*chosenOption, error) {
+	options := make(map[uuid.UUID]*chosenOption, len(ids))
+	if len(ids) == 0 {
+		return options, nil
+	}
+
+	var rows []chosenOption
+	query, args, err := QB.Select(chosenOptionColumns...).
+		From("product_options o").
+		Join("product_option_groups g ON g.id = o.group_id").
+		Where(squirrel.Eq{"o.id": ids}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+	err = db.Select(&rows, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting product options: %v", err)
+	}
+
+	for i := range rows {
+		options[rows[i].ID] = &rows[i]
+	}
+	return options, nil
+}
+
+// reserveOptions takes the options picked for the items out of stock and
+// returns them as updated. Like ProductDB.ReserveStock, each check and
+// decrement is a single conditional UPDATE, and the options are updated in a
+// fixed order. Options whose stock is not tracked only need to be available.
+func reserveOptions(tx DBInterface, items []CartItem) (map[uuid.UUID]*chosenOption, error) {
+	quantities := make(map[uuid.UUID]int)
+	for _, item := range items {
+		for _, id := range item.OptionIDs {
+			quantities[id] += item.Quantity
+		}
+	}
+	ids := make([]uuid.UUID, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	options := make(map[uuid.UUID]*chosenOption, len(ids))
+	for _, id := range ids {
+		var option chosenOption
+		err := tx.Get(&option, `
+			UPDATE product_options o SET stock_quantity = o.stock_quantity - $2
+			FROM product_option_groups g
+			WHERE g.id = o.group_id AND o.id = $1 AND o.is_available
+				AND (o.stock_quantity IS NULL OR o.stock_quantity >= $2)
+			RETURNING `+strings.Join(chosenOptionColumns, ", "), id, quantities[id])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, ErrProductOptionUnavailable
+			}
+			return nil, fmt.Errorf("error reserving product option: %v", err)
+		}
+		options[id] = &option
+	}
+	return options, nil
+}
+
+// restoreOrderOptions gives the order's options back to the ones whose stock
+// is tracked.
+func restoreOrderOptions(tx DBInterface, orderID uuid.UUID) error {
+	_, err := tx.Exec(`
+		UPDATE product_options o SET stock_quantity = o.stock_quantity + r.quantity
+		FROM (
+			SELECT oio.option_id, SUM(oi.quantity) AS quantity
+			FROM order_item_options oio
+			JOIN order_items oi ON oi.id = oio.order_item_id
+			WHERE oi.order_id = $1 AND oio.option_id IS NOT NULL
+			GROUP BY oio.option_id
+		) r
+		WHERE o.id = r.option_id AND o.stock_quantity IS NOT NULL`, orderID)
+	if err != nil {
+		return fmt.Errorf("error restoring option stock: %v", err)
+	}
+	return nil
+}
+
+// checkCartOptions checks the options of each cart item against its
+// product's current groups. It returns the first broken rule of each item
+// that has one.
+func checkCartOptions(db DBInterface, items []CartItem) (map[uuid.UUID]string, error) {
+	productIDs := make([]uuid.UUID, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	groups, err := listOptionGroups(db, productIDs...)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make(map[uuid.UUID]string)
+	for _, item := range items {
+		v := validator.New()
+		ValidateOptionChoice(v, groups[item.ProductID], item.OptionIDs, item.Quantity)
+		for _, key := range []string{"option_ids", "options"} {
+			if message, ok := v.Errors[key]; ok {
+				problems[item.ID] = message
+				break
+			}
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,352 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"project/utils"
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ProductReview is a customer's rating of a product they received. Each
+// order item can be reviewed once; the store owner can reply.
+type ProductReview struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	ProductID   uuid.UUID  `db:"product_id" json:"product_id"`
+	StoreID     uuid.UUID  `db:"store_id" json:"store_id"`
+	UserID      uuid.UUID  `db:"user_id" json:"user_id"`
+	UserName    string     `db:"user_name" json:"user_name"`
+	OrderItemID uuid.UUID  `db:"order_item_id" json:"order_item_id"`
+	Rating      int        `db:"rating" json:"rating"`
+	Body        *string    `db:"body" json:"body,omitempty"`
+	Image       *string    `db:"image" json:"image,omitempty"`
+	Reply       *string    `db:"reply" json:"reply,omitempty"`
+	RepliedAt   *time.Time `db:"replied_at" json:"replied_at,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+type ProductReviewDB struct {
+	db DBInterface
+}
+
+var productReviewColumns = []string{
+	"r.id", "r.product_id", "r.store_id", "r.user_id", "u.name AS user_name", "r.order_item_id", "r.rating", "r.body",
+	fmt.Sprintf("CASE WHEN NULLIF(r.image, '') IS NOT NULL THEN FORMAT('%s/%%s', r.image) ELSE NULL END AS image", Domain),
+	"r.reply", "r.replied_at", "r.created_at", "r.updated_at",
+}
+
+func ValidateReview(v *validator.Validator, review *ProductReview) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "يجب أن يكون التقييم من 1 إلى 5")
+	if review.Body != nil {
+		v.Check(utf8.RuneCountInString(*review.Body) <= 2000, "body", "يجب ألا يزيد نص التقييم عن 2000 حرف")
+	}
+}
+
+func ValidateReviewReply(v *validator.Validator, reply string) {
+	v.Check(reply != "", "reply", "يجب إدخال الرد")
+	v.Check(utf8.RuneCountInString(reply) <= 2000, "reply", "يجب ألا يزيد الرد عن 2000 حرف")
+}
+
+// lockRatings locks a product and its store so that their ratings are
+// recomputed one review at a time.
+func lockRatings(tx DBInterface, productID uuid.UUID) error {
+	var locked int
+	err := tx.Get(&locked, `SELECT 1 FROM products p JOIN stores s ON s.id = p.store_id
+		WHERE p.id = $1 FOR UPDATE`, productID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrProductNotFound
+		}
+		return fmt.Errorf("error locking product ratings: %v", err)
+	}
+	return nil
+}
+
+// refreshRatings recomputes the rating aggregates of a product and its store
+// from their reviews. It must run after lockRatings in the same transaction.
+func refreshRatings(tx DBInterface, productID, storeID uuid.UUID) error {
+	_, err := tx.Exec(`UPDATE products SET rating_avg = COALESCE(r.avg, 0), rating_count = r.count
+		FROM (SELECT ROUND(AVG(rating), 2) AS avg, COUNT(*) AS count FROM product_reviews WHERE product_id = $1) r
+		WHERE products.id = $1`, productID)
+	if err != nil {
+		return fmt.Errorf("error updating product rating: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE stores SET rating_avg = COALESCE(r.avg, 0), rating_count = r.count
+		FROM (SELECT ROUND(AVG(rating), 2) AS avg, COUNT(*) AS count FROM product_reviews WHERE store_id = $1) r
+		WHERE stores.id = $1`, storeID)
+	if err != nil {
+		return fmt.Errorf("error updating store rating: %v", err)
+	}
+	return nil
+}
+
+// Insert adds the user's review of a product. The review is attached to an
+// item of one of the user's delivered orders: review.OrderItemID when set,
+// otherwise the latest such item not reviewed yet. ErrReviewNotAllowed is
+// returned when the user has received no such item and ErrDuplicateReview
+// when every one of them is reviewed already.
+func (r *ProductReviewDB) Insert(review *ProductReview) error {
+	tx, err := r.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = lockRatings(tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	items := QB.Select("oi.id", "o.store_id",
+		"EXISTS (SELECT 1 FROM product_reviews pr WHERE pr.order_item_id = oi.id) AS reviewed").
+		From("order_items oi").
+		Join("orders o ON o.id = oi.order_id").
+		Where(squirrel.Eq{
+			"o.user_id":     review.UserID,
+			"o.status":      OrderStatusDelivered,
+			"oi.product_id": review.ProductID,
+		}).
+		OrderBy("reviewed", "o.updated_at DESC").
+		Limit(1)
+	if review.OrderItemID != uuid.Nil {
+		items = items.Where(squirrel.Eq{"oi.id": review.OrderItemID})
+	}
+	query, args, err := items.ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	var item struct {
+		ID       uuid.UUID `db:"id"`
+		StoreID  uuid.UUID `db:"store_id"`
+		Reviewed bool      `db:"reviewed"`
+	}
+	err = tx.Get(&item, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrReviewNotAllowed
+		}
+		return fmt.Errorf("error getting reviewable order item: %v", err)
+	}
+	if item.Reviewed {
+		return ErrDuplicateReview
+	}
+
+	review.ID = uuid.New()
+	review.OrderItemID = item.ID
+	review.StoreID = item.StoreID
+	review.CreatedAt = time.Now()
+	review.UpdatedAt = review.CreatedAt
+
+	query, args, err = QB.Insert("product_reviews").
+		Columns("id", "product_id", "store_id", "user_id", "order_item_id", "rating", "body", "image",
+			"created_at", "updated_at").
+		Values(review.ID, review.ProductID, review.StoreID, review.UserID, review.OrderItemID, review.Rating,
+			review.Body, review.Image, review.CreatedAt, review.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateReview
+		}
+		return fmt.Errorf("error inserting review: %v", err)
+	}
+
+	err = refreshRatings(tx, review.ProductID, review.StoreID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+func (r *ProductReviewDB) Get(id uuid.UUID) (*ProductReview, error) {
+	var review ProductReview
+	query, args, err := QB.Select(productReviewColumns...).
+		From("product_reviews r").
+		Join("users u ON u.id = r.user_id").
+		Where(squirrel.Eq{"r.id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = r.db.Get(&review, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrReviewNotFound
+		}
+		return nil, fmt.Errorf("error getting review: %v", err)
+	}
+
+	return &review, nil
+}
+
+// Update saves the review's rating, text and photo, and the new ratings of
+// its product and store. review.Image must be the stored path, without the
+// domain Get adds.
+func (r *ProductReviewDB) Update(review *ProductReview) error {
+	review.UpdatedAt = time.Now()
+
+	tx, err := r.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = lockRatings(tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := QB.Update("product_reviews").
+		SetMap(map[string]interface{}{
+			"rating":     review.Rating,
+			"body":       review.Body,
+			"image":      review.Image,
+			"updated_at": review.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": review.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating review: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrReviewNotFound
+	}
+
+	err = refreshRatings(tx, review.ProductID, review.StoreID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// Reply sets the store owner's reply to a review.
+func (r *ProductReviewDB) Reply(review *ProductReview, reply string) error {
+	now := time.Now()
+	query, args, err := QB.Update("product_reviews").
+		Set("reply", reply).
+		Set("replied_at", now).
+		Where(squirrel.Eq{"id": review.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error replying to review: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrReviewNotFound
+	}
+
+	review.Reply = &reply
+	review.RepliedAt = &now
+	return nil
+}
+
+// Delete removes the review and updates the ratings of its product and
+// store.
+func (r *ProductReviewDB) Delete(review *ProductReview) error {
+	tx, err := r.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = lockRatings(tx, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	query, args, err := QB.Delete("product_reviews").Where(squirrel.Eq{"id": review.ID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting review: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrReviewNotFound
+	}
+
+	err = refreshRatings(tx, review.ProductID, review.StoreID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+	return nil
+}
+
+// ListByProduct returns the product's reviews, newest first unless the
+// query asks for another order.
+func (r *ProductReviewDB) ListByProduct(productID uuid.UUID, queryParams url.Values) ([]ProductReview, *utils.Meta, error) {
+	return r.list(fmt.Sprintf("r.product_id = '%s'", productID), queryParams)
+}
+
+// ListByStore returns the reviews of all of the store's products, newest
+// first unless the query asks for another order.
+func (r *ProductReviewDB) ListByStore(storeID uuid.UUID, queryParams url.Values) ([]ProductReview, *utils.Meta, error) {
+	return r.list(fmt.Sprintf("r.store_id = '%s'", storeID), queryParams)
+}
+
+func (r *ProductReviewDB) list(filter string, queryParams url.Values) ([]ProductReview, *utils.Meta, error) {
+	queryParams = cloneValues(queryParams)
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "-created_at")
+	}
+	additionalFilters := []string{filter}
+
+	joins := []string{"users u ON u.id = r.user_id"}
+	reviews := []ProductReview{}
+	meta, err := utils.BuildQuery(&reviews, "product_reviews r", joins, productReviewColumns, nil, queryParams, additionalFilters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list reviews: %v", err)
+	}
+	return reviews, meta, nil
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MaxProductTags is how many tags a product can have.
+const MaxProductTags = 20
+
+// NormalizeTags trims, lower-cases and de-duplicates tag names, dropping
+// empty ones. The result is sorted.
+func NormalizeTags(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	tags := []string{}
+	for _, name := range names {
+		tag := strings.ToLower(strings.TrimSpace(name))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// ValidateTags checks tags already passed through NormalizeTags.
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= MaxProductTags, "tags", "يجب ألا يزيد عدد الوسوم عن 20")
+	for _, tag := range tags {
+		if utf8.RuneCountInString(tag) > 50 {
+			v.AddError("tags", "يجب ألا يزيد الوسم عن 50 حرف")
+			return
+		}
+	}
+}
+
+// setProductTags replaces the product's tags inside the transaction that
+// writes the product, creating the tags that do not exist yet. tags must be
+// normalized.
+func setProductTags(tx DBInterface, productID uuid.UUID, tags []string) error {
+	query, args, err := QB.Delete("product_tags").Where(squirrel.Eq{"product_id": productID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error clearing product tags: %v", err)
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	insertTags := QB.Insert("tags").Columns("id", "name")
+	for _, tag := range tags {
+		insertTags = insertTags.Values(uuid.New(), tag)
+	}
+	query, args, err = insertTags.Suffix("ON CONFLICT (name) DO NOTHING").ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting tags: %v", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO product_tags (product_id, tag_id)
+		SELECT $1, id FROM tags WHERE name = ANY($2)`, productID, pq.Array(tags))
+	if err != nil {
+		return fmt.Errorf("error tagging product: %v", err)
+	}
+	return nil
+}
+
+// fillTags sets the Tags of each product.
+func (p *ProductDB) fillTags(products []*Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+
+	var rows []struct {
+		ProductID uuid.UUID `db:"product_id"`
+		Name      string    `db:"name"`
+	}
+	query, args, err := QB.Select("pt.product_id", "t.name").
+		From("product_tags pt").
+		Join("tags t ON t.id = pt.tag_id").
+		Where(squirrel.Eq{"pt.product_id": ids}).
+		OrderBy("t.name").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	err = p.db.Select(&rows, query, args...)
+	if err != nil {
+		return fmt.Errorf("error getting product tags: %v", err)
+	}
+
+	byProduct := make(map[uuid.UUID][]string, len(products))
+	for _, row := range rows {
+		byProduct[row.ProductID] = append(byProduct[row.ProductID], row.Name)
+	}
+	for _, product := range products {
+		product.Tags = byProduct[product.ID]
+		if product.Tags == nil {
+			product.Tags = []string{}
+		}
+	}
+	return nil
+}
+
+// tagFilters are the SQL conditions for products having every one of the
+// tags, which must be normalized.
+func tagFilters(column string, tags []string) []string {
+	filters := make([]string, len(tags))
+	for i, tag := range tags {
+		filters[i] = fmt.Sprintf(`EXISTS (SELECT 1 FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+			WHERE pt.product_id = %s AND t.name = %s)`, column, pq.QuoteLiteral(tag))
+	}
+	return filters
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"project/utils"
+)
+
+// SearchSuggestion is a product or store whose name matches what the user
+// has typed so far.
+type SearchSuggestion struct {
+	Type string  `db:"type" json:"type"`
+	ID   string  `db:"id" json:"id"`
+	Name string  `db:"name" json:"name"`
+	Rank float64 `db:"rank" json:"-"`
+}
+
+type SearchDB struct {
+	db DBInterface
+}
+
+// prefixTSQuery turns the search terms in q into a tsquery matching rows
+// that have every term as a word or the start of one. It returns "" if q has
+// no terms. Terms are only letters and digits, so they can be put in the SQL
+// as they are.
+func prefixTSQuery(q string) string {
+	terms := utils.SearchTerms(q)
+	if len(terms) == 0 {
+		return ""
+	}
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// textSearch moves the q parameter into a full-text match on vectorColumn.
+// Matching rows get a search_rank column, and are sorted by it unless
+// another sort is asked for. It returns the parameters, columns and filters
+// to pass on to utils.BuildQuery.
+func textSearch(queryParams url.Values, vectorColumn string, columns, filters []string) (url.Values, []string, []string) {
+	q := queryParams.Get("q")
+	if q == "" {
+		return queryParams, columns, filters
+	}
+
+	queryParams = cloneValues(queryParams)
+	queryParams.Del("q")
+
+	tsquery := prefixTSQuery(q)
+	if tsquery == "" {
+		return queryParams, columns, filters
+	}
+
+	match := fmt.Sprintf("to_tsquery('simple', '%s')", tsquery)
+	filters = append(append([]string{}, filters...), fmt.Sprintf("%s @@ %s", vectorColumn, match))
+	columns = append(append([]string{}, columns...), fmt.Sprintf("ts_rank(%s, %s) AS search_rank", vectorColumn, match))
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "-search_rank")
+	}
+	return queryParams, columns, filters
+}
+
+// Suggest returns up to limit available products and active stores matching
+// what the user has typed so far, best matches first.
+func (s *SearchDB) Suggest(q string, limit int) ([]SearchSuggestion, error) {
+	suggestions := []SearchSuggestion{}
+	tsquery := prefixTSQuery(q)
+	if tsquery == "" {
+		return suggestions, nil
+	}
+
+	query := `
+		SELECT type, id, name, rank FROM (
+			SELECT 'product' AS type, p.id::text AS id, p.name,
+				ts_rank(p.search_vector, to_tsquery('simple', $1)) AS rank
+			FROM products p
+			JOIN stores s ON s.id = p.store_id
+			WHERE p.search_vector @@ to_tsquery('simple', $1)
+				AND p.is_available AND s.is_active
+			UNION ALL
+			SELECT 'store', s.id::text, s.name,
+				ts_rank(s.search_vector, to_tsquery('simple', $1))
+			FROM stores s
+			WHERE s.search_vector @@ to_tsquery('simple', $1) AND s.is_active
+		) matches
+		ORDER BY rank DESC, name
+		LIMIT $2`
+
+	err := s.db.Select(&suggestions, query, tsquery, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error getting search suggestions: %v", err)
+	}
+	return suggestions, nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"project/utils"
@@ -14,20 +15,36 @@ import (
 )
 
 type Store struct {
-	ID           uuid.UUID `db:"id" json:"id"`
-	OwnerID      uuid.UUID `db:"owner_id" json:"owner_id"`
-	StoreTypeID  int       `db:"store_type_id" json:"store_type_id"`
-	Name         string    `db:"name" json:"name"`
-	Description  *string   `db:"description" json:"description,omitempty"`
-	ContactPhone string    `db:"contact_phone" json:"contact_phone"`           // New field for contact phone
-	ContactEmail *string   `db:"contact_email" json:"contact_email,omitempty"` // New field for contact email
-	Image        *string   `db:"image" json:"image,omitempty"`
-	AddressText  *string   `db:"address_text" json:"address_text,omitempty"`
-	Latitude     *float64  `db:"latitude" json:"latitude,omitempty"`
-	Longitude    *float64  `db:"longitude" json:"longitude,omitempty"`
-	IsActive     bool      `db:"is_active" json:"is_active"`
-	CreatedAt    time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+	ID                 uuid.UUID  `db:"id" json:"id"`
+	OwnerID            uuid.UUID  `db:"owner_id" json:"owner_id"`
+	StoreTypeID        int        `db:"store_type_id" json:"store_type_id"`
+	Name               string     `db:"name" json:"name"`
+	Description        *string    `db:"description" json:"description,omitempty"`
+	ContactPhone       string     `db:"contact_phone" json:"contact_phone"`           // New field for contact phone
+	ContactEmail       *string    `db:"contact_email" json:"contact_email,omitempty"` // New field for contact email
+	Image              *string    `db:"image" json:"image,omitempty"`
+	AddressText        *string    `db:"address_text" json:"address_text,omitempty"`
+	Latitude           *float64   `db:"latitude" json:"latitude,omitempty"`
+	Longitude          *float64   `db:"longitude" json:"longitude,omitempty"`
+	IsActive           bool       `db:"is_active" json:"is_active"`
+	PausedUntil        *time.Time `db:"paused_until" json:"paused_until,omitempty"`
+	RatingAvg          float64    `db:"rating_avg" json:"rating_avg"`
+	RatingCount        int        `db:"rating_count" json:"rating_count"`
+	SpeedRatingAvg     float64    `db:"speed_rating_avg" json:"speed_rating_avg"`
+	PackagingRatingAvg float64    `db:"packaging_rating_avg" json:"packaging_rating_avg"`
+	CourierRatingAvg   float64    `db:"courier_rating_avg" json:"courier_rating_avg"`
+	OrderRatingCount   int        `db:"order_rating_count" json:"order_rating_count"`
+	CreatedAt          time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time  `db:"updated_at" json:"updated_at"`
+
+	// Set when stores are listed by distance from a point.
+	DistanceKm *float64 `db:"distance_km" json:"distance_km,omitempty"`
+	// Set when stores are searched by text.
+	SearchRank *float64 `db:"search_rank" json:"search_rank,omitempty"`
+
+	// Set by FillOpenStatus.
+	IsOpenNow     bool       `db:"-" json:"is_open_now"`
+	NextOpeningAt *time.Time `db:"-" json:"next_opening_at"`
 }
 type StoreDB struct {
 	db *sqlx.DB
@@ -183,16 +200,112 @@ func (s *StoreDB) DeleteStore(id uuid.UUID) error {
 	return tx.Commit()
 }
 
-func (s *StoreDB) ListStores(queryParams url.Values) ([]Store, *utils.Meta, error) {
+// StoreFilter narrows stores down by location.
+type StoreFilter struct {
+	// DeliversTo keeps stores that deliver to the point: stores serving
+	// active zones if one of them contains it, other stores if it is within
+	// their radius.
+	DeliversTo *DeliveryPoint
+	// Near keeps stores within RadiusKm of the point and sorts them by
+	// distance unless another sort is asked for.
+	Near     *DeliveryPoint
+	RadiusKm float64
+}
+
+func (s *StoreDB) ListStores(queryParams url.Values, filter StoreFilter) ([]Store, *utils.Meta, error) {
 	additionalFilters := []string{}
 	if ownerID := queryParams.Get("owner_id"); ownerID != "" {
 		additionalFilters = append(additionalFilters, fmt.Sprintf("owner_id = '%s'", ownerID))
 	}
+	if filter.DeliversTo != nil {
+		zoneDB := &DeliveryZoneDB{db: s.db}
+		zoneIDs, err := zoneDB.ContainingIDs(*filter.DeliversTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		additionalFilters = append(additionalFilters, deliversToFilter(*filter.DeliversTo, zoneIDs))
+	}
+
+	columns := stores_columns
+	if near := filter.Near; near != nil {
+		distance := haversineKmSQL("stores.latitude", "stores.longitude", near.Latitude, near.Longitude)
+		minLat, minLng, maxLat, maxLng := utils.BoundingBox(near.Latitude, near.Longitude, filter.RadiusKm)
+		// The box can use the location index; the exact distance check
+		// then only runs on the stores inside it.
+		additionalFilters = append(additionalFilters,
+			fmt.Sprintf("stores.latitude BETWEEN %f AND %f AND stores.longitude BETWEEN %f AND %f", minLat, maxLat, minLng, maxLng),
+			fmt.Sprintf("%s <= %f", distance, filter.RadiusKm))
+
+		columns = append(append([]string{}, stores_columns...), distance+" AS distance_km")
+		if queryParams.Get("sort") == "" {
+			queryParams = cloneValues(queryParams)
+			queryParams.Set("sort", "distance_km")
+		}
+	}
+
+	queryParams, columns, additionalFilters = textSearch(queryParams, "stores.search_vector", columns, additionalFilters)
 
 	var stores []Store
-	meta, err := utils.BuildQuery(&stores, "stores", nil, stores_columns, []string{"name", "description"}, queryParams, additionalFilters)
+	meta, err := utils.BuildQuery(&stores, "stores", nil, columns, nil, queryParams, additionalFilters)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to list stores: %v", err)
 	}
 	return stores, meta, nil
 }
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, value := range values {
+		clone[key] = append([]string{}, value...)
+	}
+	return clone
+}
+
+// deliversToFilter is the SQL condition for stores delivering to point, given
+// the zones that contain it.
+func deliversToFilter(point DeliveryPoint, zoneIDs []uuid.UUID) string {
+	inZone := "FALSE"
+	if len(zoneIDs) > 0 {
+		ids := make([]string, len(zoneIDs))
+		for i, id := range zoneIDs {
+			ids[i] = fmt.Sprintf("'%s'", id)
+		}
+		inZone = fmt.Sprintf(`EXISTS (SELECT 1 FROM store_delivery_zones sdz
+			WHERE sdz.store_id = stores.id AND sdz.zone_id IN (%s))`, strings.Join(ids, ", "))
+	}
+
+	return fmt.Sprintf(`(%s OR (
+		NOT EXISTS (SELECT 1 FROM store_delivery_zones sdz
+			JOIN delivery_zones dz ON dz.id = sdz.zone_id
+			WHERE sdz.store_id = stores.id AND dz.is_active)
+		AND NOT EXISTS (SELECT 1 FROM store_delivery_settings sds
+			WHERE sds.store_id = stores.id AND sds.radius_km IS NOT NULL
+			AND (stores.latitude IS NULL OR stores.longitude IS NULL OR %s > sds.radius_km))))`,
+		inZone, haversineKmSQL("stores.latitude", "stores.longitude", point.Latitude, point.Longitude))
+}
+
+// haversineKmSQL is the SQL for the distance in kilometres between the
+// coordinates in the given columns and a point, computed like
+// utils.HaversineKm.
+func haversineKmSQL(latColumn, lngColumn string, lat, lng float64) string {
+	return fmt.Sprintf(`(2 * 6371 * ASIN(LEAST(1, SQRT(
+		POWER(SIN(RADIANS(%[3]f - %[1]s) / 2), 2) +
+		COS(RADIANS(%[1]s)) * COS(RADIANS(%[3]f)) * POWER(SIN(RADIANS(%[4]f - %[2]s) / 2), 2)))))`,
+		latColumn, lngColumn, lat, lng)
+}
+
+// ListIDsByOwner returns the IDs of every store owned by the user.
+func (s *StoreDB) ListIDsByOwner(ownerID uuid.UUID) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	query, args, err := QB.Select("id").From("stores").Where(squirrel.Eq{"owner_id": ownerID}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = s.db.Select(&ids, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing owner stores: %v", err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,417 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// StoreLocation is the time zone opening hours and holidays are given in.
+var StoreLocation = loadStoreLocation()
+
+func loadStoreLocation() *time.Location {
+	location, err := time.LoadLocation("Africa/Tripoli")
+	if err != nil {
+		return time.FixedZone("EET", 2*60*60)
+	}
+	return location
+}
+
+// scheduleLookaheadDays is how far ahead NextOpening looks for an opening.
+const scheduleLookaheadDays = 60
+
+// OpeningHours is one shift of a store's week. A shift that closes at or
+// before its opening time closes the next day.
+type OpeningHours struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	StoreID   uuid.UUID `db:"store_id" json:"store_id"`
+	DayOfWeek int       `db:"day_of_week" json:"day_of_week"`
+	OpensAt   string    `db:"opens_at" json:"opens_at"`
+	ClosesAt  string    `db:"closes_at" json:"closes_at"`
+}
+
+// StoreHoliday is a day the store stays closed.
+type StoreHoliday struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	StoreID   uuid.UUID `db:"store_id" json:"store_id"`
+	Date      string    `db:"date" json:"date"`
+	Reason    *string   `db:"reason" json:"reason,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// StoreClosedError is returned when a store does not take orders right now.
+// It matches ErrStoreClosed.
+type StoreClosedError struct {
+	NextOpeningAt *time.Time
+}
+
+func (e *StoreClosedError) Error() string {
+	if e.NextOpeningAt == nil {
+		return ErrStoreClosed.Error()
+	}
+	return fmt.Sprintf("%s، يفتح في %s", ErrStoreClosed.Error(),
+		e.NextOpeningAt.In(StoreLocation).Format("2006-01-02 15:04"))
+}
+
+func (e *StoreClosedError) Is(target error) bool {
+	return target == ErrStoreClosed
+}
+
+// StoreSchedule is everything that decides whether a store is open.
+type StoreSchedule struct {
+	IsActive    bool
+	PausedUntil *time.Time
+	Hours       []OpeningHours
+	Holidays    []StoreHoliday
+}
+
+type shift struct {
+	start, end time.Time
+}
+
+// shifts returns the store's shifts starting from the day before from up to
+// days after it. Stores without hours have one shift a day lasting all day.
+func (s *StoreSchedule) shifts(from time.Time, days int) []shift {
+	holidays := make(map[string]bool, len(s.Holidays))
+	for _, holiday := range s.Holidays {
+		holidays[holiday.Date] = true
+	}
+
+	local := from.In(StoreLocation)
+	var shifts []shift
+	for d := -1; d <= days; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, StoreLocation)
+		if holidays[day.Format("2006-01-02")] {
+			continue
+		}
+		if len(s.Hours) == 0 {
+			shifts = append(shifts, shift{day, day.AddDate(0, 0, 1)})
+			continue
+		}
+		for _, hours := range s.Hours {
+			if hours.DayOfWeek != int(day.Weekday()) {
+				continue
+			}
+			opens, errOpens := time.Parse("15:04", hours.OpensAt)
+			closes, errCloses := time.Parse("15:04", hours.ClosesAt)
+			if errOpens != nil || errCloses != nil {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), opens.Hour(), opens.Minute(), 0, 0, StoreLocation)
+			end := time.Date(day.Year(), day.Month(), day.Day(), closes.Hour(), closes.Minute(), 0, 0, StoreLocation)
+			if !end.After(start) {
+				end = end.AddDate(0, 0, 1)
+			}
+			shifts = append(shifts, shift{start, end})
+		}
+	}
+	return shifts
+}
+
+func (s *StoreSchedule) paused(now time.Time) bool {
+	return s.PausedUntil != nil && now.Before(*s.PausedUntil)
+}
+
+// IsOpen reports whether the store takes orders at now.
+func (s *StoreSchedule) IsOpen(now time.Time) bool {
+	if !s.IsActive || s.paused(now) {
+		return false
+	}
+	for _, shift := range s.shifts(now, 0) {
+		if !now.Before(shift.start) && now.Before(shift.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns when a closed store next takes orders. It returns nil
+// when the store is open, inactive, or closed for the whole lookahead.
+func (s *StoreSchedule) NextOpening(now time.Time) *time.Time {
+	if !s.IsActive || s.IsOpen(now) {
+		return nil
+	}
+
+	var next *time.Time
+	for _, shift := range s.shifts(now, scheduleLookaheadDays) {
+		start := shift.start
+		if start.Before(now) {
+			start = now
+		}
+		if s.PausedUntil != nil && start.Before(*s.PausedUntil) {
+			start = *s.PausedUntil
+		}
+		if start.Before(shift.end) && (next == nil || start.Before(*next)) {
+			next = &start
+		}
+	}
+	return next
+}
+
+// Check returns a StoreClosedError if the store does not take orders at now.
+func (s *StoreSchedule) Check(now time.Time) error {
+	if s.IsOpen(now) {
+		return nil
+	}
+	return &StoreClosedError{NextOpeningAt: s.NextOpening(now)}
+}
+
+func ValidateOpeningHours(v *validator.Validator, hours []OpeningHours) {
+	for _, h := range hours {
+		v.Check(h.DayOfWeek >= 0 && h.DayOfWeek <= 6, "day_of_week", "يوم الأسبوع يجب أن يكون بين 0 (الأحد) و 6 (السبت)")
+		_, errOpens := time.Parse("15:04", h.OpensAt)
+		_, errCloses := time.Parse("15:04", h.ClosesAt)
+		v.Check(errOpens == nil && errCloses == nil, "hours", "أوقات العمل يجب أن تكون بصيغة HH:MM")
+		v.Check(h.OpensAt != h.ClosesAt, "hours", "وقت الإغلاق يجب أن يختلف عن وقت الفتح")
+	}
+}
+
+func ValidateStoreHoliday(v *validator.Validator, holiday *StoreHoliday) {
+	_, err := time.Parse("2006-01-02", holiday.Date)
+	v.Check(err == nil, "date", "التاريخ يجب أن يكون بصيغة YYYY-MM-DD")
+	v.Check(holiday.Reason == nil || len(*holiday.Reason) <= 255, "reason", "يجب ألا يزيد السبب عن 255 حرف")
+}
+
+type StoreHoursDB struct {
+	db DBInterface
+}
+
+var openingHoursColumns = []string{
+	"id", "store_id", "day_of_week",
+	"to_char(opens_at, 'HH24:MI') AS opens_at", "to_char(closes_at, 'HH24:MI') AS closes_at",
+}
+
+var storeHolidayColumns = []string{
+	"id", "store_id", "to_char(date, 'YYYY-MM-DD') AS date", "reason", "created_at",
+}
+
+// ListHours returns the stores' opening hours ordered by day and time.
+func (d *StoreHoursDB) ListHours(storeIDs ...uuid.UUID) ([]OpeningHours, error) {
+	hours := []OpeningHours{}
+	query, args, err := QB.Select(openingHoursColumns...).
+		From("store_opening_hours").
+		Where(squirrel.Eq{"store_id": storeIDs}).
+		OrderBy("day_of_week", "store_opening_hours.opens_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&hours, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing opening hours: %v", err)
+	}
+
+	return hours, nil
+}
+
+// ReplaceHours replaces the store's weekly opening hours. No hours at all
+// means the store is open around the clock.
+func (d *StoreHoursDB) ReplaceHours(storeID uuid.UUID, hours []OpeningHours) error {
+	tx, err := d.db.(*sqlx.DB).Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Delete("store_opening_hours").Where(squirrel.Eq{"store_id": storeID}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error clearing opening hours: %v", err)
+	}
+
+	if len(hours) > 0 {
+		insert := QB.Insert("store_opening_hours").Columns("id", "store_id", "day_of_week", "opens_at", "closes_at")
+		for i := range hours {
+			hours[i].ID = uuid.New()
+			hours[i].StoreID = storeID
+			insert = insert.Values(hours[i].ID, storeID, hours[i].DayOfWeek, hours[i].OpensAt, hours[i].ClosesAt)
+		}
+		query, args, err = insert.ToSql()
+		if err != nil {
+			return fmt.Errorf("error creating query: %v", err)
+		}
+		_, err = tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("error inserting opening hours: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListHolidays returns the stores' holidays from the given date on, in date
+// order.
+func (d *StoreHoursDB) ListHolidays(from time.Time, storeIDs ...uuid.UUID) ([]StoreHoliday, error) {
+	holidays := []StoreHoliday{}
+	query, args, err := QB.Select(storeHolidayColumns...).
+		From("store_holidays").
+		Where(squirrel.Eq{"store_id": storeIDs}).
+		Where(squirrel.GtOrEq{"store_holidays.date": from.In(StoreLocation).Format("2006-01-02")}).
+		OrderBy("store_holidays.date").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&holidays, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing holidays: %v", err)
+	}
+
+	return holidays, nil
+}
+
+func (d *StoreHoursDB) InsertHoliday(holiday *StoreHoliday) error {
+	holiday.ID = uuid.New()
+	holiday.CreatedAt = time.Now()
+
+	query, args, err := QB.Insert("store_holidays").
+		Columns("id", "store_id", "date", "reason", "created_at").
+		Values(holiday.ID, holiday.StoreID, holiday.Date, holiday.Reason, holiday.CreatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateStoreHoliday
+		}
+		return fmt.Errorf("error inserting holiday: %v", err)
+	}
+
+	return nil
+}
+
+func (d *StoreHoursDB) DeleteHoliday(storeID, id uuid.UUID) error {
+	query, args, err := QB.Delete("store_holidays").
+		Where(squirrel.Eq{"id": id, "store_id": storeID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting holiday: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrStoreHolidayNotFound
+	}
+
+	return nil
+}
+
+// SetPausedUntil stops the store taking orders until the given time, or lets
+// it take them again when until is nil.
+func (d *StoreHoursDB) SetPausedUntil(storeID uuid.UUID, until *time.Time) error {
+	query, args, err := QB.Update("stores").
+		Set("paused_until", until).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": storeID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error pausing store: %v", err)
+	}
+
+	return nil
+}
+
+// Schedules loads the schedules of the given stores, keyed by store ID.
+func (d *StoreHoursDB) Schedules(stores []Store, now time.Time) (map[uuid.UUID]*StoreSchedule, error) {
+	schedules := make(map[uuid.UUID]*StoreSchedule, len(stores))
+	if len(stores) == 0 {
+		return schedules, nil
+	}
+
+	storeIDs := make([]uuid.UUID, len(stores))
+	for i, store := range stores {
+		storeIDs[i] = store.ID
+		schedules[store.ID] = &StoreSchedule{IsActive: store.IsActive, PausedUntil: store.PausedUntil}
+	}
+
+	hours, err := d.ListHours(storeIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hours {
+		schedules[h.StoreID].Hours = append(schedules[h.StoreID].Hours, h)
+	}
+
+	// Shifts that started yesterday may still be running.
+	holidays, err := d.ListHolidays(now.AddDate(0, 0, -1), storeIDs...)
+	if err != nil {
+		return nil, err
+	}
+	for _, holiday := range holidays {
+		schedules[holiday.StoreID].Holidays = append(schedules[holiday.StoreID].Holidays, holiday)
+	}
+
+	return schedules, nil
+}
+
+// CheckOpen returns a StoreClosedError if the store does not take orders at
+// now.
+func (d *StoreHoursDB) CheckOpen(storeID uuid.UUID, now time.Time) error {
+	var store Store
+	query, args, err := QB.Select("id", "is_active", "paused_until").
+		From("stores").
+		Where(squirrel.Eq{"id": storeID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&store, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrStoreNotFound
+		}
+		return fmt.Errorf("error getting store: %v", err)
+	}
+
+	schedules, err := d.Schedules([]Store{store}, now)
+	if err != nil {
+		return err
+	}
+	return schedules[storeID].Check(now)
+}
+
+// FillOpenStatus sets IsOpenNow and NextOpeningAt on the stores.
+func (s *StoreDB) FillOpenStatus(stores []Store, now time.Time) error {
+	hoursDB := &StoreHoursDB{db: s.db}
+	schedules, err := hoursDB.Schedules(stores, now)
+	if err != nil {
+		return err
+	}
+
+	for i := range stores {
+		schedule := schedules[stores[i].ID]
+		stores[i].IsOpenNow = schedule.IsOpen(now)
+		stores[i].NextOpeningAt = schedule.NextOpening(now)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"project/internal/events"
 	"project/utils"
 	"project/utils/validator"
 
@@ -36,6 +37,14 @@ type User struct {
 	Roles                    pq.StringArray `db:"roles" json:"roles"` // from github.com/lib/pq
 }
 
+// UserSignedUpPayload is the body of UserSignedUp events. It leaves out the
+// user's contact details, since the outbox keeps events and hands them to
+// every consumer; consumers that need more look the user up.
+type UserSignedUpPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type StringArray []string
 
 // Scan implements the sql.Scanner interface.
@@ -115,7 +124,13 @@ func (u *UserDB) InsertUser(user *User) error {
 		return fmt.Errorf("خطأ في إنشاء الاستعلام: %v", err)
 	}
 
-	err = u.db.QueryRowx(query, args...).StructScan(user)
+	tx, err := u.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("خطأ في بدء المعاملة: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowx(query, args...).StructScan(user)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok {
 			switch pqErr.Constraint {
@@ -128,6 +143,19 @@ func (u *UserDB) InsertUser(user *User) error {
 		return fmt.Errorf("خطأ في إضافة المستخدم: %v", err)
 	}
 
+	err = events.Write(tx, events.UserSignedUp, user.ID, nil, UserSignedUpPayload{
+		UserID:    user.ID,
+		CreatedAt: user.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("خطأ في تأكيد المعاملة: %v", err)
+	}
+
 	return nil
 }
 
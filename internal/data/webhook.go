@@ -0,0 +1,209 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"project/internal/events"
+	"project/utils/validator"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebhookEventTypes are the events a store can subscribe its endpoints to.
+var WebhookEventTypes = []string{events.OrderCreated, events.OrderStatusChanged, events.ProductStockLow}
+
+// Webhook is an endpoint a store owner registered to be told about the
+// store's events. The secret is only shown once, when the webhook is created.
+type Webhook struct {
+	ID         uuid.UUID      `db:"id" json:"id"`
+	StoreID    uuid.UUID      `db:"store_id" json:"store_id"`
+	URL        string         `db:"url" json:"url"`
+	Secret     string         `db:"secret" json:"-"`
+	EventTypes pq.StringArray `db:"event_types" json:"event_types"`
+	IsActive   bool           `db:"is_active" json:"is_active"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+type WebhookDB struct {
+	db DBInterface
+}
+
+var webhookColumns = []string{"id", "store_id", "url", "secret", "event_types", "is_active", "created_at", "updated_at"}
+
+// IsPublicAddress reports whether webhooks may be sent to ip. Loopback,
+// link-local, private, unspecified and multicast addresses are refused so that
+// store owners cannot reach the API's own network.
+func IsPublicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// webhookHostAllowed reports whether host, a name or an IP address, only
+// resolves to public addresses.
+func webhookHostAllowed(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return IsPublicAddress(ip)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !IsPublicAddress(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.StoreID != uuid.Nil, "store_id", "يجب ادخال رقم المتجر")
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", "رابط غير صالح")
+	if v.Valid() {
+		v.Check(webhookHostAllowed(u.Hostname()), "url", "لا يمكن إرسال الأحداث إلى هذا العنوان")
+	}
+	v.Check(len(webhook.URL) <= 2048, "url", "يجب ألا يزيد الرابط عن 2048 حرف")
+	for _, eventType := range webhook.EventTypes {
+		v.Check(validator.In(eventType, WebhookEventTypes...), "event_types", "نوع الحدث غير مدعوم: "+eventType)
+	}
+}
+
+func (w *WebhookDB) Insert(webhook *Webhook) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("error generating webhook secret: %v", err)
+	}
+
+	webhook.ID = uuid.New()
+	webhook.Secret = hex.EncodeToString(secret)
+	webhook.CreatedAt = time.Now()
+	webhook.UpdatedAt = webhook.CreatedAt
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = pq.StringArray{}
+	}
+
+	query, args, err := QB.Insert("webhooks").
+		Columns(webhookColumns...).
+		Values(webhook.ID, webhook.StoreID, webhook.URL, webhook.Secret, webhook.EventTypes,
+			webhook.IsActive, webhook.CreatedAt, webhook.UpdatedAt).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = w.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error inserting webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (w *WebhookDB) Get(id uuid.UUID) (*Webhook, error) {
+	var webhook Webhook
+	query, args, err := QB.Select(webhookColumns...).From("webhooks").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = w.db.Get(&webhook, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook: %v", err)
+	}
+
+	return &webhook, nil
+}
+
+func (w *WebhookDB) Update(webhook *Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	query, args, err := QB.Update("webhooks").
+		SetMap(map[string]interface{}{
+			"url":         webhook.URL,
+			"event_types": webhook.EventTypes,
+			"is_active":   webhook.IsActive,
+			"updated_at":  webhook.UpdatedAt,
+		}).
+		Where(squirrel.Eq{"id": webhook.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = w.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (w *WebhookDB) Delete(id uuid.UUID) error {
+	query, args, err := QB.Delete("webhooks").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	result, err := w.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to verify affected rows: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (w *WebhookDB) ListByStore(storeID uuid.UUID) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	query, args, err := QB.Select(webhookColumns...).From("webhooks").
+		Where(squirrel.Eq{"store_id": storeID}).
+		OrderBy("created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = w.db.Select(&webhooks, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %v", err)
+	}
+
+	return webhooks, nil
+}
+
+// ListSubscribed returns the active webhooks of a store that want eventType.
+func (w *WebhookDB) ListSubscribed(storeID uuid.UUID, eventType string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	query, args, err := QB.Select(webhookColumns...).From("webhooks").
+		Where(squirrel.Eq{"store_id": storeID, "is_active": true}).
+		Where("(cardinality(event_types) = 0 OR ? = ANY(event_types))", eventType).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = w.db.Select(&webhooks, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing subscribed webhooks: %v", err)
+	}
+
+	return webhooks, nil
+}
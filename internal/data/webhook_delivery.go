@@ -0,0 +1,200 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"project/utils"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event to be sent to one webhook, with the outcome
+// of the latest attempt.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `db:"id" json:"id"`
+	WebhookID      uuid.UUID       `db:"webhook_id" json:"webhook_id"`
+	EventID        int64           `db:"event_id" json:"event_id"`
+	EventType      string          `db:"event_type" json:"event_type"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	LastStatusCode *int            `db:"last_status_code" json:"last_status_code,omitempty"`
+	LastError      *string         `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	DeliveredAt    *time.Time      `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+type WebhookDeliveryDB struct {
+	db DBInterface
+}
+
+var webhookDeliveryColumns = []string{
+	"id", "webhook_id", "event_id", "event_type", "payload", "status", "attempts",
+	"next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at",
+}
+
+// Enqueue schedules an event for a webhook. Enqueueing the same event twice
+// is a no-op.
+func (d *WebhookDeliveryDB) Enqueue(webhookID uuid.UUID, eventID int64, eventType string, payload []byte) error {
+	now := time.Now()
+	query, args, err := QB.Insert("webhook_deliveries").
+		Columns("id", "webhook_id", "event_id", "event_type", "payload", "status", "next_attempt_at", "created_at").
+		Values(uuid.New(), webhookID, eventID, eventType, payload, DeliveryPending, now, now).
+		Suffix("ON CONFLICT (webhook_id, event_id) DO NOTHING").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error enqueueing webhook delivery: %v", err)
+	}
+
+	return nil
+}
+
+func (d *WebhookDeliveryDB) Get(id uuid.UUID) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	query, args, err := QB.Select(webhookDeliveryColumns...).From("webhook_deliveries").Where(squirrel.Eq{"id": id}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Get(&delivery, query, args...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook delivery: %v", err)
+	}
+
+	return &delivery, nil
+}
+
+// ListByWebhook returns the delivery log of a webhook, newest first by default.
+func (d *WebhookDeliveryDB) ListByWebhook(webhookID uuid.UUID, queryParams url.Values) ([]WebhookDelivery, *utils.Meta, error) {
+	if queryParams.Get("sort") == "" {
+		queryParams.Set("sort", "-created_at")
+	}
+
+	var deliveries []WebhookDelivery
+	meta, err := utils.BuildQuery(&deliveries, "webhook_deliveries", nil, webhookDeliveryColumns, []string{"event_type"}, queryParams,
+		[]string{fmt.Sprintf("webhook_id = '%s'", webhookID)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list webhook deliveries: %v", err)
+	}
+	return deliveries, meta, nil
+}
+
+// ClaimDue hands out up to limit pending deliveries whose time has come,
+// pushing their next attempt back by lease so that other workers leave them
+// alone while they are being sent.
+func (d *WebhookDeliveryDB) ClaimDue(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	query, args, err := QB.Update("webhook_deliveries").
+		Set("next_attempt_at", now.Add(lease)).
+		Where(`id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)`, DeliveryPending, now, limit).
+		Suffix("RETURNING " + strings.Join(webhookDeliveryColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = d.db.Select(&deliveries, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
+
+// ApplyAttempt sets the outcome of an attempt made at now on the delivery.
+// A nil nextAttempt means there will be no further attempts.
+func (d *WebhookDelivery) ApplyAttempt(statusCode *int, attemptErr *string, nextAttempt *time.Time, now time.Time) {
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	d.LastError = attemptErr
+
+	switch {
+	case attemptErr == nil:
+		d.Status = DeliverySucceeded
+		d.DeliveredAt = &now
+	case nextAttempt != nil:
+		d.Status = DeliveryPending
+		d.NextAttemptAt = *nextAttempt
+	default:
+		d.Status = DeliveryFailed
+	}
+}
+
+// RecordAttempt stores the outcome of sending a delivery. A nil nextAttempt
+// means there will be no further attempts.
+func (d *WebhookDeliveryDB) RecordAttempt(delivery *WebhookDelivery, statusCode *int, attemptErr *string, nextAttempt *time.Time) error {
+	delivery.ApplyAttempt(statusCode, attemptErr, nextAttempt, time.Now())
+
+	query, args, err := QB.Update("webhook_deliveries").
+		SetMap(map[string]interface{}{
+			"status":           delivery.Status,
+			"attempts":         delivery.Attempts,
+			"next_attempt_at":  delivery.NextAttemptAt,
+			"last_status_code": delivery.LastStatusCode,
+			"last_error":       delivery.LastError,
+			"delivered_at":     delivery.DeliveredAt,
+		}).
+		Where(squirrel.Eq{"id": delivery.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error recording webhook attempt: %v", err)
+	}
+
+	return nil
+}
+
+// Redeliver queues a delivery to be sent again right away, whatever its outcome so far.
+func (d *WebhookDeliveryDB) Redeliver(delivery *WebhookDelivery) error {
+	delivery.Status = DeliveryPending
+	delivery.NextAttemptAt = time.Now()
+
+	query, args, err := QB.Update("webhook_deliveries").
+		SetMap(map[string]interface{}{
+			"status":          delivery.Status,
+			"next_attempt_at": delivery.NextAttemptAt,
+		}).
+		Where(squirrel.Eq{"id": delivery.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error requeueing webhook delivery: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,393 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// batchSize is how many events are read from the outbox at a time.
+	batchSize = 500
+	// pollInterval is how often the outbox is read without a notification.
+	// It covers events held back behind a slow transaction and notifications
+	// lost while the listener was reconnecting.
+	pollInterval = time.Second
+	// subscriptionBuffer is how many events may queue for a subscriber
+	// before it is dropped for being too slow.
+	subscriptionBuffer = 256
+)
+
+// visible restricts a query to events written by transactions that finished
+// before any transaction still in flight began.
+const visible = "tx_id < txid_snapshot_xmin(txid_current_snapshot())"
+
+// Bus reads the outbox and hands events to in-process subscribers and
+// durable consumers.
+//
+// Subscriptions are live only: they see events committed after they were
+// made, including broadcast ones, and are closed if they fall behind. Callers
+// that need history read it with ListAfter. Consumers keep their position in
+// the database and see every stored event at least once.
+type Bus struct {
+	db  *sqlx.DB
+	dsn string
+	log *log.Logger
+
+	mu        sync.Mutex
+	subs      map[*Subscription]struct{}
+	consumers []chan struct{}
+	closed    bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Subscription receives live events matching its filter on C. C is closed
+// when the bus shuts down or the subscriber falls too far behind.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter Filter
+}
+
+func NewBus(db *sqlx.DB, dsn string, logger *log.Logger) *Bus {
+	return &Bus{
+		db:   db,
+		dsn:  dsn,
+		log:  logger,
+		subs: make(map[*Subscription]struct{}),
+		quit: make(chan struct{}),
+	}
+}
+
+// Start listens for outbox notifications and begins delivering events
+// committed from now on.
+func (b *Bus) Start() error {
+	cursor, err := b.Head()
+	if err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(b.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			b.log.Printf("events: listener: %v", err)
+		}
+	})
+	for _, channel := range []string{notifyChannel, liveChannel} {
+		err = listener.Listen(channel)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("error listening for events: %v", err)
+		}
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer listener.Close()
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			// A nil notification means the connection was re-established;
+			// reading the outbox catches up on anything missed meanwhile.
+			case n := <-listener.Notify:
+				if n != nil && n.Channel == liveChannel {
+					b.publishLive(n.Extra)
+					continue
+				}
+			case <-ticker.C:
+			case <-b.quit:
+				return
+			}
+			cursor = b.drain(cursor)
+			b.wakeConsumers()
+		}
+	}()
+
+	return nil
+}
+
+// Done is closed when the bus starts shutting down.
+func (b *Bus) Done() <-chan struct{} {
+	return b.quit
+}
+
+// Shutdown stops reading the outbox, waits for consumers to finish their
+// current batch and closes every subscription.
+func (b *Bus) Shutdown() {
+	close(b.quit)
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Subscribe starts receiving live events that match filter.
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, filter: filter}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// ListAfter returns up to limit committed events past cursor that match
+// filter, oldest first.
+func (b *Bus) ListAfter(cursor Cursor, filter Filter, limit int) ([]Event, error) {
+	return listAfter(b.db, cursor, filter, limit)
+}
+
+// Head returns the position of the newest event that is safe to read, so a
+// reader starting there sees only what happens from now on.
+func (b *Bus) Head() (Cursor, error) {
+	return head(b.db)
+}
+
+// Consume calls handle for every event matching filter, at least once, until
+// the bus shuts down. Progress is stored under name, so a restarted process
+// resumes where it stopped; with several API instances only one at a time
+// holds the consumer. A new consumer starts at the current head. When handle
+// fails the event is retried on the next round.
+func (b *Bus) Consume(name string, filter Filter, handle func(Event) error) error {
+	start, err := b.Head()
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec(`INSERT INTO outbox_consumers (name, tx_id, event_id) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING`, name, start.TxID, start.ID)
+	if err != nil {
+		return fmt.Errorf("error registering consumer %s: %v", name, err)
+	}
+
+	wake := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.consumers = append(b.consumers, wake)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			err := b.consumeBatch(name, filter, handle)
+			if err != nil {
+				b.log.Printf("events: consumer %s: %v", name, err)
+			}
+
+			select {
+			case <-wake:
+			case <-ticker.C:
+			case <-b.quit:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (b *Bus) consumeBatch(name string, filter Filter, handle func(Event) error) error {
+	for {
+		more, err := b.consumeOnce(name, filter, handle)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// consumeOnce handles one batch under the consumer's row lock and reports
+// whether there may be more waiting.
+func (b *Bus) consumeOnce(name string, filter Filter, handle func(Event) error) (bool, error) {
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var cursor Cursor
+	err = tx.QueryRow(`SELECT tx_id, event_id FROM outbox_consumers WHERE name = $1 FOR UPDATE SKIP LOCKED`, name).
+		Scan(&cursor.TxID, &cursor.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Another instance is running this consumer.
+			return false, nil
+		}
+		return false, fmt.Errorf("error locking consumer: %v", err)
+	}
+
+	events, err := listAfter(tx, cursor, Filter{}, batchSize)
+	if err != nil {
+		return false, err
+	}
+
+	var handleErr error
+	for i := range events {
+		if filter.Match(&events[i]) {
+			if handleErr = handle(events[i]); handleErr != nil {
+				break
+			}
+		}
+		cursor = events[i].Cursor()
+	}
+
+	_, err = tx.Exec(`UPDATE outbox_consumers SET tx_id = $1, event_id = $2, updated_at = NOW() WHERE name = $3`,
+		cursor.TxID, cursor.ID, name)
+	if err != nil {
+		return false, fmt.Errorf("error saving consumer position: %v", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	if handleErr != nil {
+		return false, handleErr
+	}
+	return len(events) == batchSize, nil
+}
+
+// drain delivers every event past cursor to subscribers and returns the new position.
+func (b *Bus) drain(cursor Cursor) Cursor {
+	for {
+		events, err := listAfter(b.db, cursor, Filter{}, batchSize)
+		if err != nil {
+			b.log.Printf("events: %v", err)
+			return cursor
+		}
+
+		for i := range events {
+			b.publish(&events[i])
+			cursor = events[i].Cursor()
+		}
+
+		if len(events) < batchSize {
+			return cursor
+		}
+	}
+}
+
+func (b *Bus) publish(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.c <- *event:
+		default:
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// publishLive hands a broadcast event to subscribers.
+func (b *Bus) publishLive(message string) {
+	var event Event
+	err := json.Unmarshal([]byte(message), &event)
+	if err != nil {
+		b.log.Printf("events: decoding broadcast: %v", err)
+		return
+	}
+	b.publish(&event)
+}
+
+func (b *Bus) wakeConsumers() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, wake := range b.consumers {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type queryer interface {
+	Select(dest interface{}, query string, args ...interface{}) error
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func listAfter(db queryer, cursor Cursor, filter Filter, limit int) ([]Event, error) {
+	events := []Event{}
+	sb := qb.Select(eventColumns...).From("outbox_events").
+		Where("(tx_id, id) > (?, ?)", cursor.TxID, cursor.ID).
+		Where(visible).
+		OrderBy("tx_id ASC", "id ASC").
+		Limit(uint64(limit))
+	if len(filter.Types) > 0 {
+		sb = sb.Where(squirrel.Eq{"type": filter.Types})
+	}
+	if len(filter.StoreIDs) > 0 {
+		sb = sb.Where(squirrel.Eq{"store_id": filter.StoreIDs})
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = db.Select(&events, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing events: %v", err)
+	}
+
+	return events, nil
+}
+
+func head(db queryer) (Cursor, error) {
+	var cursor Cursor
+	query, args, err := qb.Select("tx_id", "id").From("outbox_events").
+		Where(visible).
+		OrderBy("tx_id DESC", "id DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return cursor, fmt.Errorf("error creating query: %v", err)
+	}
+
+	err = db.QueryRow(query, args...).Scan(&cursor.TxID, &cursor.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return cursor, fmt.Errorf("error getting event head: %v", err)
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDB connects to the database named by TEST_DATABASE_URL, which must
+// have the migrations of internal/migrations applied. Tests that need it are
+// skipped when it is not set, except under CI, where they fail instead.
+func testDB(t *testing.T) (*sqlx.DB, string) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, dsn
+}
+
+// testEventType returns an event type no other test writes, and removes its
+// events when the test ends.
+func testEventType(t *testing.T, db *sqlx.DB) string {
+	t.Helper()
+	eventType := "test." + uuid.NewString()
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM outbox_events WHERE type = $1`, eventType)
+	})
+	return eventType
+}
+
+func TestConsumeRedeliversAfterHandlerError(t *testing.T) {
+	db, dsn := testDB(t)
+	eventType := testEventType(t, db)
+	name := "test-" + uuid.NewString()
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM outbox_consumers WHERE name = $1`, name)
+	})
+
+	bus := NewBus(db, dsn, log.New(io.Discard, "", 0))
+	defer bus.Shutdown()
+
+	// The handler fails the first time it sees each event.
+	handled := make(chan uuid.UUID, 10)
+	failed := make(map[uuid.UUID]bool)
+	err := bus.Consume(name, Filter{Types: []string{eventType}}, func(e Event) error {
+		handled <- *e.AggregateID
+		if !failed[*e.AggregateID] {
+			failed[*e.AggregateID] = true
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{first, second} {
+		err = Write(db, eventType, id, nil, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []uuid.UUID{first, first, second, second}
+	for i, id := range want {
+		select {
+		case got := <-handled:
+			if got != id {
+				t.Fatalf("delivery %d was event %v, want %v", i, got, id)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("delivery %d of event %v never happened", i, id)
+		}
+	}
+
+	// Once handled, events are not delivered again.
+	select {
+	case got := <-handled:
+		t.Fatalf("event %v delivered after it was handled", got)
+	case <-time.After(2 * pollInterval):
+	}
+}
+
+func TestListAfterWaitsForOlderTransactions(t *testing.T) {
+	db, _ := testDB(t)
+	eventType := testEventType(t, db)
+	filter := Filter{Types: []string{eventType}}
+
+	cursor, err := head(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow transaction writes its event first but commits last.
+	slow, fast := uuid.New(), uuid.New()
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	err = Write(tx, eventType, slow, nil, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Write(db, eventType, fast, nil, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := listAfter(db, cursor, filter, batchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("read %d events while an older transaction was running, want none", len(events))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other transactions running against the database may hold the events
+	// back a little longer.
+	deadline := time.Now().Add(10 * time.Second)
+	for len(events) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		events, err = listAfter(db, cursor, filter, batchSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("read %d events after both committed, want 2", len(events))
+	}
+	if *events[0].AggregateID != slow || *events[1].AggregateID != fast {
+		t.Errorf("events read in the order %v, %v; want the slow transaction's first",
+			*events[0].AggregateID, *events[1].AggregateID)
+	}
+}
+
+func TestBroadcastReachesSubscribersWithoutStoring(t *testing.T) {
+	db, dsn := testDB(t)
+	eventType := testEventType(t, db)
+	filter := Filter{Types: []string{eventType}}
+
+	bus := NewBus(db, dsn, log.New(io.Discard, "", 0))
+	err := bus.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Shutdown()
+	sub := bus.Subscribe(filter)
+
+	cursor, err := head(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	err = Broadcast(db, eventType, id, nil, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-sub.C:
+		if event.AggregateID == nil || *event.AggregateID != id {
+			t.Errorf("received event about %v, want %v", event.AggregateID, id)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("broadcast event never arrived")
+	}
+
+	events, err := listAfter(db, cursor, filter, batchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("broadcast event was stored in the outbox")
+	}
+}
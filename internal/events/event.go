@@ -0,0 +1,185 @@
+// Package events is the application's domain event bus. Events are written to
+// the outbox_events table inside the same transaction as the change they
+// describe, and a trigger announces every insert with NOTIFY so that every API
+// instance picks them up through a pq.Listener. Frequent updates that only
+// matter as they happen are sent with Broadcast instead and never stored.
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+const (
+	OrderCreated       = "order.created"
+	OrderStatusChanged = "order.status_changed"
+	ProductStockLow    = "product.stock_low"
+	UserSignedUp       = "user.signed_up"
+
+	// OrderDriverLocation is broadcast, not stored in the outbox.
+	OrderDriverLocation = "order.driver_location"
+)
+
+// notifyChannel is the channel the outbox trigger notifies on.
+const notifyChannel = "outbox_events"
+
+// liveChannel carries the events sent with Broadcast.
+const liveChannel = "outbox_live"
+
+var qb = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+
+// Event is a committed entry of the outbox.
+type Event struct {
+	ID          int64           `db:"id" json:"id"`
+	TxID        int64           `db:"tx_id" json:"-"`
+	Type        string          `db:"type" json:"type"`
+	AggregateID *uuid.UUID      `db:"aggregate_id" json:"aggregate_id,omitempty"`
+	StoreID     *uuid.UUID      `db:"store_id" json:"store_id,omitempty"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+}
+
+var eventColumns = []string{"id", "tx_id", "type", "aggregate_id", "store_id", "payload", "created_at"}
+
+// Cursor is a position in the outbox. Events are ordered by the transaction
+// that wrote them and then by ID, which is the order in which they become
+// safe to read: once every transaction older than the oldest one still
+// running has finished, no event can appear behind the cursor any more.
+type Cursor struct {
+	TxID int64
+	ID   int64
+}
+
+func (e *Event) Cursor() Cursor {
+	return Cursor{TxID: e.TxID, ID: e.ID}
+}
+
+// String renders the cursor for clients, e.g. as an SSE event id.
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.TxID, c.ID)
+}
+
+// After reports whether c comes after other.
+func (c Cursor) After(other Cursor) bool {
+	return c.TxID > other.TxID || (c.TxID == other.TxID && c.ID > other.ID)
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	txPart, idPart, ok := strings.Cut(s, "-")
+	if !ok {
+		return Cursor{}, errors.New("invalid event id")
+	}
+	txID, err := strconv.ParseInt(txPart, 10, 64)
+	if err != nil {
+		return Cursor{}, errors.New("invalid event id")
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return Cursor{}, errors.New("invalid event id")
+	}
+	return Cursor{TxID: txID, ID: id}, nil
+}
+
+// Filter selects events by type and store. Empty fields match everything.
+type Filter struct {
+	Types    []string
+	StoreIDs []uuid.UUID
+}
+
+func (f Filter) Match(e *Event) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, e.Type) {
+		return false
+	}
+	if len(f.StoreIDs) > 0 {
+		if e.StoreID == nil {
+			return false
+		}
+		found := false
+		for _, id := range f.StoreIDs {
+			if id == *e.StoreID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Execer is satisfied by *sqlx.DB and *sqlx.Tx. Pass the transaction that
+// makes the change so the event commits or rolls back with it.
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Write appends an event to the outbox. aggregateID names the order, product
+// or user the event is about; storeID, when set, routes it to that store's
+// owners.
+func Write(db Execer, eventType string, aggregateID uuid.UUID, storeID *uuid.UUID, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	query, args, err := qb.Insert("outbox_events").
+		Columns("type", "aggregate_id", "store_id", "payload", "created_at").
+		Values(eventType, aggregateID, storeID, body, time.Now()).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("error creating query: %v", err)
+	}
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error writing event: %v", err)
+	}
+
+	return nil
+}
+
+// Broadcast sends an event to the live subscribers of every API instance
+// without storing it. The event has no position: consumers and ListAfter
+// never see it, and it is lost if nobody is listening. Sent inside a
+// transaction, it goes out when the transaction commits.
+func Broadcast(db Execer, eventType string, aggregateID uuid.UUID, storeID *uuid.UUID, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+	message, err := json.Marshal(Event{
+		Type:        eventType,
+		AggregateID: &aggregateID,
+		StoreID:     storeID,
+		Payload:     body,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+
+	_, err = db.Exec(`SELECT pg_notify($1, $2)`, liveChannel, string(message))
+	if err != nil {
+		return fmt.Errorf("error broadcasting event: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+CREATE TABLE order_status_history (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    actor_id UUID,
+    actor_role VARCHAR(20) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id);
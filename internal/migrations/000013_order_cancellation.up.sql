@@ -0,0 +1,4 @@
+ALTER TABLE orders
+    ADD COLUMN cancellation_reason TEXT,
+    ADD COLUMN cancelled_by VARCHAR(20),
+    ADD COLUMN cancelled_at TIMESTAMP;
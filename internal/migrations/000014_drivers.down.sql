@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_drivers_is_online;
+DROP TABLE IF EXISTS drivers CASCADE;
@@ -0,0 +1,14 @@
+CREATE TABLE drivers (
+    user_id UUID NOT NULL PRIMARY KEY,
+    vehicle_type VARCHAR(20) NOT NULL,
+    vehicle_plate VARCHAR(20),
+    is_online BOOLEAN NOT NULL DEFAULT FALSE,
+    latitude NUMERIC(10, 7),
+    longitude NUMERIC(10, 7),
+    location_updated_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_drivers_is_online ON drivers(is_online);
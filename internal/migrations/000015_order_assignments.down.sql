@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_order_assignments_active;
+DROP INDEX IF EXISTS idx_order_assignments_driver_id;
+DROP INDEX IF EXISTS idx_order_assignments_order_id;
+DROP TABLE IF EXISTS order_assignments CASCADE;
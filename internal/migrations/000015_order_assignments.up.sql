@@ -0,0 +1,21 @@
+CREATE TABLE order_assignments (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    driver_id UUID NOT NULL,
+    assigned_by UUID,
+    status VARCHAR(20) NOT NULL DEFAULT 'offered',
+    offered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    responded_at TIMESTAMP,
+    picked_up_at TIMESTAMP,
+    delivered_at TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (driver_id) REFERENCES drivers(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (assigned_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_assignments_order_id ON order_assignments(order_id);
+CREATE INDEX idx_order_assignments_driver_id ON order_assignments(driver_id);
+-- An order can only be held by one driver at a time.
+CREATE UNIQUE INDEX idx_order_assignments_active ON order_assignments(order_id)
+    WHERE status IN ('offered', 'accepted', 'picked_up');
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_store_events_store_id;
+DROP INDEX IF EXISTS idx_store_events_position;
+DROP TABLE IF EXISTS store_events CASCADE;
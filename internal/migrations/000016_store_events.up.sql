@@ -0,0 +1,17 @@
+CREATE TABLE store_events (
+    id BIGSERIAL PRIMARY KEY,
+    -- Transaction that wrote the event; readers only consume events from
+    -- transactions older than every one still running, so a slow commit can
+    -- never be skipped.
+    tx_id BIGINT NOT NULL DEFAULT txid_current(),
+    store_id UUID NOT NULL,
+    order_id UUID,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_store_events_position ON store_events(tx_id, id);
+CREATE INDEX idx_store_events_store_id ON store_events(store_id, tx_id, id);
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS outbox_consumers CASCADE;
+
+DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events;
+DROP FUNCTION IF EXISTS notify_outbox_event();
+DROP INDEX IF EXISTS idx_outbox_events_type;
+
+DELETE FROM outbox_events WHERE store_id IS NULL;
+ALTER TABLE outbox_events ALTER COLUMN store_id SET NOT NULL;
+ALTER TABLE outbox_events RENAME COLUMN aggregate_id TO order_id;
+UPDATE outbox_events SET order_id = NULL WHERE order_id NOT IN (SELECT id FROM orders);
+ALTER TABLE outbox_events ADD CONSTRAINT store_events_order_id_fkey
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE;
+ALTER INDEX idx_outbox_events_store_id RENAME TO idx_store_events_store_id;
+ALTER INDEX idx_outbox_events_position RENAME TO idx_store_events_position;
+ALTER SEQUENCE outbox_events_id_seq RENAME TO store_events_id_seq;
+ALTER TABLE outbox_events RENAME TO store_events;
@@ -0,0 +1,30 @@
+-- The store feed table becomes the general outbox: events may now be about
+-- products and users too, and need not belong to a store.
+ALTER TABLE store_events RENAME TO outbox_events;
+ALTER SEQUENCE store_events_id_seq RENAME TO outbox_events_id_seq;
+ALTER INDEX idx_store_events_position RENAME TO idx_outbox_events_position;
+ALTER INDEX idx_store_events_store_id RENAME TO idx_outbox_events_store_id;
+ALTER TABLE outbox_events DROP CONSTRAINT store_events_order_id_fkey;
+ALTER TABLE outbox_events RENAME COLUMN order_id TO aggregate_id;
+ALTER TABLE outbox_events ALTER COLUMN store_id DROP NOT NULL;
+
+CREATE INDEX idx_outbox_events_type ON outbox_events(type, tx_id, id);
+
+CREATE OR REPLACE FUNCTION notify_outbox_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', NEW.id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER outbox_events_notify
+    AFTER INSERT ON outbox_events
+    FOR EACH ROW EXECUTE FUNCTION notify_outbox_event();
+
+-- Position of each durable consumer in the outbox.
+CREATE TABLE outbox_consumers (
+    name VARCHAR(100) NOT NULL PRIMARY KEY,
+    tx_id BIGINT NOT NULL,
+    event_id BIGINT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
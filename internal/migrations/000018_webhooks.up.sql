@@ -0,0 +1,14 @@
+CREATE TABLE webhooks (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    -- Empty means every event type.
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhooks_store_id ON webhooks(store_id);
@@ -0,0 +1,30 @@
+CREATE TABLE coupons (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(50) NOT NULL,
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL,
+    percent_off INT,
+    amount_off NUMERIC(10, 2),
+    min_basket NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    usage_limit INT,
+    per_user_limit INT,
+    starts_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ends_at TIMESTAMP,
+    store_id UUID,
+    store_type_id INT,
+    created_by UUID NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (store_type_id) REFERENCES store_types(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id),
+    CHECK (
+        (discount_type = 'percentage' AND percent_off BETWEEN 1 AND 100 AND amount_off IS NULL) OR
+        (discount_type = 'fixed' AND amount_off > 0 AND percent_off IS NULL)
+    )
+);
+
+-- Codes are matched case-insensitively and stored upper-cased.
+CREATE UNIQUE INDEX idx_coupons_code ON coupons(code);
+CREATE INDEX idx_coupons_store_id ON coupons(store_id);
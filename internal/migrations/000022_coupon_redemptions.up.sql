@@ -0,0 +1,13 @@
+CREATE TABLE coupon_redemptions (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    coupon_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    order_id UUID NOT NULL UNIQUE,
+    amount NUMERIC(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_coupon_redemptions_coupon_user ON coupon_redemptions(coupon_id, user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE carts
+    ADD COLUMN coupon_id UUID REFERENCES coupons(id) ON DELETE SET NULL;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_discounts;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS discount_total;
@@ -0,0 +1,16 @@
+ALTER TABLE orders
+    ADD COLUMN discount_total NUMERIC(10, 2) NOT NULL DEFAULT 0;
+
+CREATE TABLE order_discounts (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    coupon_id UUID,
+    code VARCHAR(50) NOT NULL,
+    description TEXT,
+    amount NUMERIC(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_discounts_order_id ON order_discounts(order_id);
@@ -0,0 +1,12 @@
+CREATE TABLE store_delivery_settings (
+    store_id UUID NOT NULL PRIMARY KEY,
+    -- A NULL radius means the store delivers at any distance.
+    radius_km NUMERIC(6, 2),
+    base_fee NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    per_km_fee NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    free_delivery_threshold NUMERIC(10, 2),
+    min_order_value NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE
+);
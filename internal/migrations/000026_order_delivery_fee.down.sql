@@ -0,0 +1,3 @@
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS delivery_fee,
+    DROP COLUMN IF EXISTS delivery_distance_km;
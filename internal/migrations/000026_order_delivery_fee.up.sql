@@ -0,0 +1,3 @@
+ALTER TABLE orders
+    ADD COLUMN delivery_fee NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN delivery_distance_km NUMERIC(8, 3);
@@ -0,0 +1,20 @@
+CREATE TABLE delivery_zones (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    -- A GeoJSON MultiPolygon with [longitude, latitude] positions.
+    geometry JSONB NOT NULL,
+    -- The bounding box of the geometry, so candidate zones for a point can
+    -- be found in SQL before the exact check.
+    min_latitude DOUBLE PRECISION NOT NULL,
+    min_longitude DOUBLE PRECISION NOT NULL,
+    max_latitude DOUBLE PRECISION NOT NULL,
+    max_longitude DOUBLE PRECISION NOT NULL,
+    -- When set, these replace the store's own delivery fees inside the zone.
+    base_fee NUMERIC(10, 2),
+    per_km_fee NUMERIC(10, 2),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_delivery_zones_name ON delivery_zones(name);
@@ -0,0 +1,11 @@
+-- Stores that serve any zone only deliver inside their zones.
+CREATE TABLE store_delivery_zones (
+    store_id UUID NOT NULL,
+    zone_id UUID NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (store_id, zone_id),
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (zone_id) REFERENCES delivery_zones(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_store_delivery_zones_zone_id ON store_delivery_zones(zone_id);
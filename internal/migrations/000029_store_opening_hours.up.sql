@@ -0,0 +1,16 @@
+-- Weekly opening hours in the stores' local time. A store may have several
+-- shifts a day; a shift closing at or before its opening time runs past
+-- midnight. Stores without any hours are open around the clock.
+CREATE TABLE store_opening_hours (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id UUID NOT NULL,
+    -- 0 is Sunday.
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+    opens_at TIME NOT NULL,
+    closes_at TIME NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    CHECK (opens_at <> closes_at)
+);
+
+CREATE INDEX idx_store_opening_hours_store_id ON store_opening_hours(store_id);
@@ -0,0 +1,10 @@
+-- Days a store is closed. Shifts starting on a holiday are skipped.
+CREATE TABLE store_holidays (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id UUID NOT NULL,
+    date DATE NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    UNIQUE (store_id, date)
+);
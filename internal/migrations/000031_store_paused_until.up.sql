@@ -0,0 +1,2 @@
+-- Stores stop taking orders until this time, e.g. while the kitchen is busy.
+ALTER TABLE stores ADD COLUMN paused_until TIMESTAMP;
@@ -0,0 +1,19 @@
+-- Weekly delivery windows for orders placed ahead of time, in the stores'
+-- local time.
+CREATE TABLE delivery_slots (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id UUID NOT NULL,
+    -- 0 is Sunday.
+    day_of_week SMALLINT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+    starts_at TIME NOT NULL,
+    ends_at TIME NOT NULL,
+    -- How many orders the store takes per slot and date.
+    capacity INT NOT NULL CHECK (capacity > 0),
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX idx_delivery_slots_store_id ON delivery_slots(store_id);
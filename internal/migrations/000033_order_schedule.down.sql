@@ -0,0 +1,3 @@
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS scheduled_for,
+    DROP COLUMN IF EXISTS delivery_slot_id;
@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    ADD COLUMN scheduled_for TIMESTAMP,
+    ADD COLUMN delivery_slot_id UUID REFERENCES delivery_slots(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_orders_store_scheduled_for ON orders(store_id, scheduled_for);
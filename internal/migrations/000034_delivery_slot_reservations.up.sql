@@ -0,0 +1,21 @@
+-- A place in a slot on a given date. Carts hold a place for a short while
+-- during checkout; orders keep theirs until they are cancelled.
+CREATE TABLE delivery_slot_reservations (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    slot_id UUID NOT NULL,
+    slot_date DATE NOT NULL,
+    cart_id UUID UNIQUE,
+    order_id UUID UNIQUE,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (slot_id) REFERENCES delivery_slots(id) ON DELETE CASCADE,
+    FOREIGN KEY (cart_id) REFERENCES carts(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    CHECK (
+        (cart_id IS NOT NULL AND expires_at IS NOT NULL AND order_id IS NULL) OR
+        (order_id IS NOT NULL AND cart_id IS NULL AND expires_at IS NULL)
+    )
+);
+
+CREATE INDEX idx_delivery_slot_reservations_slot ON delivery_slot_reservations(slot_id, slot_date);
+CREATE INDEX idx_delivery_slot_reservations_expires_at ON delivery_slot_reservations(expires_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_stores_latitude_longitude;
@@ -0,0 +1,2 @@
+-- Lets nearby-store searches narrow stores down to a bounding box.
+CREATE INDEX idx_stores_latitude_longitude ON stores(latitude, longitude);
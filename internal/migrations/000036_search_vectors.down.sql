@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS stores_search_vector ON stores;
+DROP TRIGGER IF EXISTS products_search_vector ON products;
+DROP FUNCTION IF EXISTS stores_search_vector_update();
+DROP FUNCTION IF EXISTS products_search_vector_update();
+ALTER TABLE stores DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE products DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS search_normalize(TEXT);
@@ -0,0 +1,46 @@
+-- Folds text for search the way utils.NormalizeSearchText does: lower case,
+-- no diacritics or tatweel, and one form of alef, yaa and taa marbuta.
+CREATE OR REPLACE FUNCTION search_normalize(input TEXT) RETURNS TEXT AS $$
+    SELECT translate(
+        regexp_replace(
+            normalize(lower(coalesce(input, '')), NFKD),
+            '[\u0300-\u036f\u0610-\u061a\u064b-\u065f\u0670\u06d6-\u06ed\u0640]', '', 'g'),
+        'ٱىة', 'ايه');
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE products ADD COLUMN search_vector TSVECTOR;
+ALTER TABLE stores ADD COLUMN search_vector TSVECTOR;
+
+CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', search_normalize(NEW.name)), 'A') ||
+        setweight(to_tsvector('simple', search_normalize(NEW.description)), 'B');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER products_search_vector
+    BEFORE INSERT OR UPDATE OF name, description ON products
+    FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
+
+CREATE OR REPLACE FUNCTION stores_search_vector_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', search_normalize(NEW.name)), 'A') ||
+        setweight(to_tsvector('simple', search_normalize(NEW.description)), 'B') ||
+        setweight(to_tsvector('simple', search_normalize(NEW.address_text)), 'C');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stores_search_vector
+    BEFORE INSERT OR UPDATE OF name, description, address_text ON stores
+    FOR EACH ROW EXECUTE FUNCTION stores_search_vector_update();
+
+-- Fires the triggers for the rows already there.
+UPDATE products SET name = name;
+UPDATE stores SET name = name;
+
+CREATE INDEX idx_products_search_vector ON products USING GIN (search_vector);
+CREATE INDEX idx_stores_search_vector ON stores USING GIN (search_vector);
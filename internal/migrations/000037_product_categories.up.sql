@@ -0,0 +1,22 @@
+-- A store's menu categories. Subcategories are deleted with their parent;
+-- products in a deleted category are left uncategorized.
+CREATE TABLE product_categories (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    store_id UUID NOT NULL,
+    parent_id UUID,
+    name VARCHAR(100) NOT NULL,
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_id) REFERENCES product_categories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_categories_store_id ON product_categories(store_id);
+CREATE INDEX idx_product_categories_parent_id ON product_categories(parent_id);
+-- Sibling categories have different names.
+CREATE UNIQUE INDEX idx_product_categories_name ON product_categories(
+    store_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'), name);
+
+ALTER TABLE products ADD COLUMN category_id UUID REFERENCES product_categories(id) ON DELETE SET NULL;
+CREATE INDEX idx_products_category_id ON products(category_id);
@@ -0,0 +1,17 @@
+-- Tags are shared by all stores, so customers can filter on them across the
+-- marketplace. Names are stored trimmed and in lower case.
+CREATE TABLE tags (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(50) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE product_tags (
+    product_id UUID NOT NULL,
+    tag_id UUID NOT NULL,
+    PRIMARY KEY (product_id, tag_id),
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_tags_tag_id ON product_tags(tag_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS product_options;
+DROP TABLE IF EXISTS product_option_groups;
@@ -0,0 +1,37 @@
+-- Choices a customer makes when ordering a product, such as its size or
+-- extra toppings. A required group needs at least min_select picks (and at
+-- least one); an optional group can be left out or picked between min_select
+-- and max_select times.
+CREATE TABLE product_option_groups (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    selection_type VARCHAR(10) NOT NULL CHECK (selection_type IN ('single', 'multi')),
+    is_required BOOLEAN NOT NULL DEFAULT FALSE,
+    min_select INTEGER NOT NULL DEFAULT 0,
+    max_select INTEGER NOT NULL DEFAULT 1,
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    CHECK (min_select >= 0 AND max_select >= 1 AND max_select >= min_select),
+    CHECK (selection_type = 'multi' OR max_select = 1)
+);
+
+CREATE INDEX idx_product_option_groups_product_id ON product_option_groups(product_id);
+
+-- stock_quantity is NULL for options whose stock is not tracked.
+CREATE TABLE product_options (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    group_id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    price_delta NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (price_delta >= 0),
+    stock_quantity INTEGER CHECK (stock_quantity >= 0),
+    is_available BOOLEAN NOT NULL DEFAULT TRUE,
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (group_id) REFERENCES product_option_groups(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_options_group_id ON product_options(group_id);
@@ -0,0 +1,9 @@
+CREATE TABLE cart_item_options (
+    cart_item_id UUID NOT NULL,
+    option_id UUID NOT NULL,
+    PRIMARY KEY (cart_item_id, option_id),
+    FOREIGN KEY (cart_item_id) REFERENCES cart_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (option_id) REFERENCES product_options(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_cart_item_options_option_id ON cart_item_options(option_id);
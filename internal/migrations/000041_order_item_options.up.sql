@@ -0,0 +1,16 @@
+-- The options of an order item as they were when it was ordered. option_id
+-- is kept to give tracked stock back on cancellation, while the option still
+-- exists.
+CREATE TABLE order_item_options (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_item_id UUID NOT NULL,
+    option_id UUID,
+    group_name VARCHAR(100) NOT NULL,
+    option_name VARCHAR(100) NOT NULL,
+    price_delta NUMERIC(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (option_id) REFERENCES product_options(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_item_options_order_item_id ON order_item_options(order_item_id);
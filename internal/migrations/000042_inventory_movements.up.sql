@@ -0,0 +1,22 @@
+-- The stock level at which the store owner is warned, per product.
+ALTER TABLE products ADD COLUMN low_stock_threshold INTEGER NOT NULL DEFAULT 5
+    CHECK (low_stock_threshold >= 0);
+
+-- Every change to a product's stock, written in the same transaction as the
+-- change. Rows are never updated or deleted by the application.
+CREATE TABLE inventory_movements (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('sale', 'cancellation', 'adjustment', 'import')),
+    quantity_change INTEGER NOT NULL,
+    quantity_after INTEGER NOT NULL,
+    order_id UUID,
+    actor_id UUID,
+    note TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_inventory_movements_product_id ON inventory_movements(product_id, created_at DESC);
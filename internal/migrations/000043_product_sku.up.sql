@@ -0,0 +1,4 @@
+-- The store's own code for a product, used to match rows of CSV imports.
+ALTER TABLE products ADD COLUMN sku VARCHAR(64);
+
+CREATE UNIQUE INDEX idx_products_store_sku ON products(store_id, sku) WHERE sku IS NOT NULL;
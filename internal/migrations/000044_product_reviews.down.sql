@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS product_reviews;
+ALTER TABLE stores DROP COLUMN IF EXISTS rating_avg, DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE products DROP COLUMN IF EXISTS rating_avg, DROP COLUMN IF EXISTS rating_count;
@@ -0,0 +1,30 @@
+ALTER TABLE products
+    ADD COLUMN rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE stores
+    ADD COLUMN rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+
+-- A customer's review of a product they received, one per order item.
+CREATE TABLE product_reviews (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL,
+    store_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    order_item_id UUID NOT NULL UNIQUE,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT,
+    image VARCHAR(255),
+    reply TEXT,
+    replied_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_product_reviews_product_id ON product_reviews(product_id, created_at DESC);
+CREATE INDEX idx_product_reviews_store_id ON product_reviews(store_id, created_at DESC);
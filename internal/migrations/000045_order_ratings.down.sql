@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS order_ratings;
+ALTER TABLE drivers DROP COLUMN IF EXISTS courier_rating_avg, DROP COLUMN IF EXISTS courier_rating_count;
+ALTER TABLE stores
+    DROP COLUMN IF EXISTS speed_rating_avg,
+    DROP COLUMN IF EXISTS packaging_rating_avg,
+    DROP COLUMN IF EXISTS courier_rating_avg,
+    DROP COLUMN IF EXISTS order_rating_count;
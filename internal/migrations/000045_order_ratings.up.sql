@@ -0,0 +1,30 @@
+ALTER TABLE stores
+    ADD COLUMN speed_rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN packaging_rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN courier_rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN order_rating_count INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE drivers
+    ADD COLUMN courier_rating_avg NUMERIC(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN courier_rating_count INTEGER NOT NULL DEFAULT 0;
+
+-- The customer's rating of a delivered order as a whole. courier is only
+-- set when a driver delivered the order.
+CREATE TABLE order_ratings (
+    order_id UUID NOT NULL PRIMARY KEY,
+    store_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    driver_id UUID,
+    speed SMALLINT NOT NULL CHECK (speed BETWEEN 1 AND 5),
+    packaging SMALLINT NOT NULL CHECK (packaging BETWEEN 1 AND 5),
+    courier SMALLINT CHECK (courier BETWEEN 1 AND 5),
+    comment TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (driver_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_order_ratings_store_id ON order_ratings(store_id, created_at);
+CREATE INDEX idx_order_ratings_driver_id ON order_ratings(driver_id, created_at);
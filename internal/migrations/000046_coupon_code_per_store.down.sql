@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_coupons_store_code;
+DROP INDEX IF EXISTS idx_coupons_global_code;
+CREATE UNIQUE INDEX idx_coupons_code ON coupons(code);
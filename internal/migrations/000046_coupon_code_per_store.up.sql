@@ -0,0 +1,5 @@
+-- Store coupons only need codes unique within their store; coupons not tied
+-- to a store share one namespace.
+DROP INDEX IF EXISTS idx_coupons_code;
+CREATE UNIQUE INDEX idx_coupons_store_code ON coupons(store_id, code) WHERE store_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_coupons_global_code ON coupons(code) WHERE store_id IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS fk_carts_store_id;
@@ -0,0 +1,13 @@
+-- 000008 could not add this key inside CREATE TABLE, so databases created
+-- from it may be missing it. Carts of stores that no longer exist are
+-- removed first, as the cascade would have done.
+DELETE FROM carts WHERE store_id NOT IN (SELECT id FROM stores);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_carts_store_id') THEN
+        ALTER TABLE carts ADD CONSTRAINT fk_carts_store_id
+            FOREIGN KEY (store_id) REFERENCES stores(id) ON DELETE CASCADE;
+    END IF;
+END
+$$;
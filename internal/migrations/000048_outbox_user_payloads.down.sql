@@ -0,0 +1,2 @@
+-- The removed details cannot be restored.
+SELECT 1;
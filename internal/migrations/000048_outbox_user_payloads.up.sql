@@ -0,0 +1,5 @@
+-- user.signed_up events used to carry the whole user, contact details
+-- included. Keep only what the event now holds.
+UPDATE outbox_events
+SET payload = jsonb_build_object('user_id', aggregate_id, 'created_at', payload->'created_at')
+WHERE type = 'user.signed_up';
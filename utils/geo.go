@@ -0,0 +1,90 @@
+package utils
+
+import "math"
+
+const earthRadiusKm = 6371.0
+
+// HaversineKm returns the great-circle distance in kilometres between two
+// points given in decimal degrees.
+func HaversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Ring is a closed line of [longitude, latitude] positions, in GeoJSON order.
+type Ring [][2]float64
+
+// Polygon is an outer ring followed by any holes, as in a GeoJSON Polygon.
+type Polygon []Ring
+
+// Contains reports whether the point lies inside the polygon's outer ring
+// and outside all of its holes. Points exactly on an edge may fall either
+// way. Coordinates are treated as planar, which is accurate enough for
+// city-sized areas away from the antimeridian.
+func (p Polygon) Contains(lat, lng float64) bool {
+	if len(p) == 0 || !p[0].contains(lat, lng) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.contains(lat, lng) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains casts a ray from the point towards increasing longitude and
+// counts how many edges it crosses.
+func (r Ring) contains(lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		lngI, latI := r[i][0], r[i][1]
+		lngJ, latJ := r[j][0], r[j][1]
+		if (latI > lat) != (latJ > lat) &&
+			lng < (lngJ-lngI)*(lat-latI)/(latJ-latI)+lngI {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Bounds returns the smallest latitude/longitude box around the polygon.
+func (p Polygon) Bounds() (minLat, minLng, maxLat, maxLng float64) {
+	minLat, minLng = math.Inf(1), math.Inf(1)
+	maxLat, maxLng = math.Inf(-1), math.Inf(-1)
+	for _, ring := range p {
+		for _, position := range ring {
+			minLng, maxLng = math.Min(minLng, position[0]), math.Max(maxLng, position[0])
+			minLat, maxLat = math.Min(minLat, position[1]), math.Max(maxLat, position[1])
+		}
+	}
+	return minLat, minLng, maxLat, maxLng
+}
+
+// BoundingBox returns a latitude/longitude box containing every point within
+// radiusKm of the given point. It is meant as a cheap prefilter before an
+// exact distance check, so it errs on the large side near the poles.
+func BoundingBox(lat, lng, radiusKm float64) (minLat, minLng, maxLat, maxLng float64) {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat = math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+
+	cosLat := math.Cos(toRadians(lat))
+	if minLat == -90 || maxLat == 90 || cosLat < 1e-6 {
+		return minLat, -180, maxLat, 180
+	}
+	dLng := dLat / cosLat
+	if lng-dLng < -180 || lng+dLng > 180 {
+		// Boxes crossing the antimeridian are widened to every longitude.
+		return minLat, -180, maxLat, 180
+	}
+	return minLat, lng - dLng, maxLat, lng + dLng
+}
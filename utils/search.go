@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchLetters folds the Arabic letters that are commonly written one for
+// the other. Hamza forms of alef are folded by dropping the marks that
+// decomposition leaves after them.
+var searchLetters = strings.NewReplacer("ٱ", "ا", "ى", "ي", "ة", "ه")
+
+// isSearchMark reports whether r is dropped from search text: combining
+// accents, Arabic harakat, Quranic marks and tatweel.
+func isSearchMark(r rune) bool {
+	switch {
+	case r >= 0x0300 && r <= 0x036f,
+		r >= 0x0610 && r <= 0x061a,
+		r >= 0x064b && r <= 0x065f,
+		r == 0x0670,
+		r >= 0x06d6 && r <= 0x06ed,
+		r == 0x0640:
+		return true
+	}
+	return false
+}
+
+// NormalizeSearchText folds s the way the database's search_normalize
+// function folds indexed text, so that queries match regardless of case,
+// diacritics and Arabic letter variants.
+func NormalizeSearchText(s string) string {
+	decomposed := norm.NFKD.String(strings.ToLower(s))
+	stripped := strings.Map(func(r rune) rune {
+		if isSearchMark(r) {
+			return -1
+		}
+		return r
+	}, decomposed)
+	return searchLetters.Replace(stripped)
+}
+
+// SearchTerms splits the normalized form of s into words of letters and
+// digits.
+func SearchTerms(s string) []string {
+	return strings.FieldsFunc(NormalizeSearchText(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}